@@ -27,144 +27,90 @@ import "fmt"
 // IN THE SOFTWARE.
 // ----------------------------------------------------------------------------
 
-func (c *CPU) read_and_adjust_relative() {
-	pc_as_int := int(c.program_counter)
-	adjustment := int(c.read_immediate())
-	c.program_counter = uint16(pc_as_int + adjustment)
-}
-
 // ----------------------------------------------------------------------------
-// Immediate
-
-func (c *CPU) read_immediate() uint8 {
-	return c.bus.Read(c.program_counter + 1)
-}
-
+// Operand Fetch
 // ----------------------------------------------------------------------------
-// Absolute
-
-func (c *CPU) read_absolute() uint8 {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	return c.bus.Read(addr)
-}
 
-func (c *CPU) write_absolute(data uint8) {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	c.bus.Write(addr, data)
-}
-
-func (c *CPU) read_absolute_16() uint16 {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	return uint16(c.bus.Read(addr)) | (uint16(c.bus.Read(addr+1)) << 8)
+// Fetches the low byte before the high byte, as the hardware does, so the
+// data bus is left holding the high byte of the operand.
+func (c *CPU) read_operand_address() uint16 {
+	low := c.bus.Read(c.program_counter + 1)
+	high := c.bus.Read(c.program_counter + 2)
+	return uint16(high)<<8 | uint16(low)
 }
 
-// ----------------------------------------------------------------------------
-// Zero Page
-
-func (c *CPU) read_zero_page() uint8 {
-	return c.bus.Read(uint16(c.bus.Read(c.program_counter + 1)))
+func (c *CPU) read_immediate() uint8 {
+	return c.bus.Read(c.program_counter + 1)
 }
 
-func (c *CPU) write_zero_page(data uint8) {
-	c.bus.Write(uint16(c.bus.Read(c.program_counter+1)), data)
+// Reads a pointer from the zero page, wrapping within it
+func (c *CPU) read_zero_page_16(addr uint8) uint16 {
+	low := c.bus.Read(uint16(addr))
+	high := c.bus.Read(uint16(addr + 1))
+	return uint16(high)<<8 | uint16(low)
 }
 
 // ----------------------------------------------------------------------------
-// Absolute X
-
-func (c *CPU) read_absolute_x() uint8 {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	return c.bus.Read(addr + uint16(c.x))
-}
-
-func (c *CPU) write_absolute_x(data uint8) {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	c.bus.Write(addr+uint16(c.x), data)
-}
-
+// Effective Address
 // ----------------------------------------------------------------------------
-// Absolute Y
-
-func (c *CPU) read_absolute_y() uint8 {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	return c.bus.Read(addr + uint16(c.y))
-}
-
-func (c *CPU) write_absolute_y(data uint8) {
-	addr := uint16(c.bus.Read(c.program_counter+2))<<8 | uint16(c.bus.Read(c.program_counter+1))
-	c.bus.Write(addr+uint16(c.y), data)
-}
-
+// Indexing that carries into the high byte costs reads an extra cycle, added
+// to the instruction's count through extra_cycles. Stores and read-modify-
+// write instructions always take that cycle, so it is in their counts.
 // ----------------------------------------------------------------------------
-// Zero Page X
 
-func (c *CPU) read_zero_page_x() uint8 {
-	return c.bus.Read(uint16(c.bus.Read(c.program_counter+1) + c.x))
+func (c *CPU) address_by_addressing_mode(addressing_mode AddressingMode) (uint16, bool) {
+	switch addressing_mode {
+	case ABSOLUTE:
+		return c.read_operand_address(), false
+	case ABSOLUTE_X:
+		return indexed(c.read_operand_address(), c.x)
+	case ABSOLUTE_Y:
+		return indexed(c.read_operand_address(), c.y)
+	case ZEROPAGE:
+		return uint16(c.read_immediate()), false
+	case ZEROPAGE_X:
+		return uint16(c.read_immediate() + c.x), false
+	case ZEROPAGE_Y:
+		return uint16(c.read_immediate() + c.y), false
+	case INDIRECT_X:
+		return c.read_zero_page_16(c.read_immediate() + c.x), false
+	case INDIRECT_Y:
+		return indexed(c.read_zero_page_16(c.read_immediate()), c.y)
+	default:
+		panic(fmt.Errorf("invalid addressing mode for memory access: %02x", addressing_mode))
+	}
 }
 
-func (c *CPU) write_zero_page_x(data uint8) {
-	c.bus.Write(uint16(c.bus.Read(c.program_counter+1)+c.x), data)
+func indexed(base uint16, index uint8) (uint16, bool) {
+	addr := base + uint16(index)
+	return addr, addr&0xFF00 != base&0xFF00
 }
 
 // ----------------------------------------------------------------------------
-// Zero Page Y
+// Relative
 
-func (c *CPU) read_zero_page_y() uint8 {
-	return c.bus.Read(uint16(c.bus.Read(c.program_counter+1) + c.y))
-}
-
-func (c *CPU) write_zero_page_y(data uint8) {
-	c.bus.Write(uint16(c.bus.Read(c.program_counter+1)+c.y), data)
+// Takes a branch: PC moves past it and then by its signed offset, costing a
+// cycle more, or two more into another page
+func (c *CPU) read_and_adjust_relative() {
+	offset := int8(c.read_immediate())
+	next := c.program_counter + 2
+	c.program_counter = next + uint16(offset)
+	c.extra_cycles++
+	if c.program_counter&0xFF00 != next&0xFF00 {
+		c.extra_cycles++
+	}
 }
 
 // ----------------------------------------------------------------------------
 // Indirect
 
+// The NMOS part does not carry into the high byte of the pointer, so
+// JMP ($xxFF) reads its high byte from $xx00
 func (c *CPU) read_indirect() uint16 {
-
-	low := c.bus.Read(c.program_counter + 2)
-	high := c.bus.Read(c.program_counter + 1)
-
+	pointer := c.read_operand_address()
+	low := c.bus.Read(pointer)
+	high := c.bus.Read(pointer&0xFF00 | uint16(uint8(pointer)+1))
 	return uint16(high)<<8 | uint16(low)
-
-}
-
-// ----------------------------------------------------------------------------
-// Indirect X
-
-func (c *CPU) read_indirect_x() uint8 {
-
-	low := c.bus.Read(uint16(c.bus.Read(c.program_counter + 2)))
-	high := c.bus.Read(uint16(c.bus.Read(c.program_counter + 1)))
-	addr := uint16(high)<<8 | uint16(low)
-	return c.bus.Read(addr + uint16(c.x))
-
-}
-
-func (c *CPU) write_indirect_x(data uint8) {
-	low := c.bus.Read(uint16(c.bus.Read(c.program_counter + 2)))
-	high := c.bus.Read(uint16(c.bus.Read(c.program_counter + 1)))
-	addr := uint16(high)<<8 | uint16(low)
-	c.bus.Write(addr+uint16(c.x), data)
-}
-
-// ----------------------------------------------------------------------------
-// Indirect Y
-
-func (c *CPU) read_indirect_y() uint8 {
-
-	low := c.bus.Read(uint16(c.bus.Read(c.program_counter + 2)))
-	high := c.bus.Read(uint16(c.bus.Read(c.program_counter + 1)))
-	addr := uint16(high)<<8 | uint16(low)
-	return c.bus.Read(addr) + c.y
-
-}
-
-func (c *CPU) write_indirect_y(data uint8) {
-	low := c.bus.Read(uint16(c.bus.Read(c.program_counter + 2)))
-	high := c.bus.Read(uint16(c.bus.Read(c.program_counter + 1)))
-	addr := uint16(high)<<8 | uint16(low)
-	c.bus.Write(addr, data+c.y)
 }
 
 // ----------------------------------------------------------------------------
@@ -172,51 +118,36 @@ func (c *CPU) write_indirect_y(data uint8) {
 // ----------------------------------------------------------------------------
 
 func (c *CPU) load_by_addressing_mode(addressing_mode AddressingMode) uint8 {
-
 	switch addressing_mode {
 	case IMMEDIATE:
 		return c.read_immediate()
-	case ABSOLUTE:
-		return c.read_absolute()
-	case ABSOLUTE_X:
-		return c.read_absolute_x()
-	case ABSOLUTE_Y:
-		return c.read_absolute_y()
-	case ZEROPAGE:
-		return c.read_zero_page()
-	case ZEROPAGE_X:
-		return c.read_zero_page_x()
-	case ZEROPAGE_Y:
-		return c.read_zero_page_y()
-	case INDIRECT_X:
-		return c.read_indirect_x()
-	case INDIRECT_Y:
-		return c.read_indirect_y()
-	default:
-		panic(fmt.Errorf("invalid addressing mode for load: %02x", addressing_mode))
+	case ACCUMULATOR:
+		return c.accumulator
 	}
-
+	addr, crossed := c.address_by_addressing_mode(addressing_mode)
+	if crossed {
+		c.extra_cycles++
+	}
+	return c.bus.Read(addr)
 }
 
 func (c *CPU) store_by_addressing_mode(addressing_mode AddressingMode, data uint8) {
-	switch addressing_mode {
-	case ABSOLUTE:
-		c.write_absolute(data)
-	case ABSOLUTE_X:
-		c.write_absolute_x(data)
-	case ABSOLUTE_Y:
-		c.write_absolute_y(data)
-	case ZEROPAGE:
-		c.write_zero_page(data)
-	case ZEROPAGE_X:
-		c.write_zero_page_x(data)
-	case ZEROPAGE_Y:
-		c.write_zero_page_y(data)
-	case INDIRECT_X:
-		c.write_indirect_x(data)
-	case INDIRECT_Y:
-		c.write_indirect_y(data)
-	default:
-		panic(fmt.Errorf("invalid addressing mode for store: %02x", addressing_mode))
+	if addressing_mode == ACCUMULATOR {
+		c.accumulator = data
+		return
 	}
+	addr, _ := c.address_by_addressing_mode(addressing_mode)
+	c.bus.Write(addr, data)
+}
+
+// Read-modify-write, resolving the address once
+func (c *CPU) modify_by_addressing_mode(addressing_mode AddressingMode, modify func(uint8) uint8) uint8 {
+	if addressing_mode == ACCUMULATOR {
+		c.accumulator = modify(c.accumulator)
+		return c.accumulator
+	}
+	addr, _ := c.address_by_addressing_mode(addressing_mode)
+	data := modify(c.bus.Read(addr))
+	c.bus.Write(addr, data)
+	return data
 }
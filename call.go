@@ -0,0 +1,94 @@
+package cpu6502
+
+import "fmt"
+
+// ----------------------------------------------------------------------------
+// call.go
+// Calling 6502 subroutines from Go
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Subroutine Calls
+// ----------------------------------------------------------------------------
+// Call runs a subroutine as if it had been reached with JSR from a sentinel
+// address, until the RTS that returns there with the stack as it was. It
+// fails on BRK, which a routine under test should never reach, once the CPU
+// has taken it to the IRQ vector; when the routine pulls more from the stack
+// than was pushed for it - caught within 128 bytes of the call - and after a
+// limit on the cycles taken.
+//
+// The CPU alone is stepped; devices on a scheduler are not clocked, so
+// routines should not wait on them.
+// ----------------------------------------------------------------------------
+
+const (
+	CALL_RETURN = 0xFFFF // the address the sentinel return goes to
+	CALL_LIMIT  = 10000000
+)
+
+// Sets the cycle limit for Call, CALL_LIMIT by default
+func (cpu *CPU) SetCallLimit(cycles uint64) {
+	cpu.call_limit = cycles
+}
+
+// Calls the routine at addr with the given registers and returns the
+// registers after its RTS and the cycles taken, including that RTS. PC is
+// ignored, and a zero SP starts the stack at $FF.
+func (cpu *CPU) Call(addr uint16, registers Registers) (Registers, uint64, error) {
+	limit := cpu.call_limit
+	if limit == 0 {
+		limit = CALL_LIMIT
+	}
+	if registers.SP == 0 {
+		registers.SP = 0xFF
+	}
+	registers.PC = addr
+	cpu.SetRegisters(registers)
+	cpu.remaining_cycles = 0
+	entry := cpu.stack_pointer
+	cpu.push_16(CALL_RETURN - 1)
+
+	var cycles uint64
+	for {
+		pc := cpu.program_counter
+		if pc == CALL_RETURN && cpu.stack_pointer == entry {
+			return cpu.Registers(), cycles, nil
+		}
+		if int8(entry-cpu.stack_pointer) < 0 {
+			return cpu.Registers(), cycles, fmt.Errorf("stack underflow at $%04X: SP $%02X, called with $%02X", pc, cpu.stack_pointer, entry)
+		}
+		if cycles >= limit {
+			return cpu.Registers(), cycles, fmt.Errorf("call to $%04X did not return within %d cycles", addr, limit)
+		}
+
+		breaks := cpu.breaks
+		taken, err := cpu.Step()
+		cycles += taken
+		if err != nil {
+			return cpu.Registers(), cycles, err
+		}
+		if cpu.breaks != breaks {
+			return cpu.Registers(), cycles, fmt.Errorf("BRK at $%04X", pc)
+		}
+	}
+}
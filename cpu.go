@@ -34,6 +34,25 @@ type Bus interface {
 	Write(uint16, uint8)
 }
 
+// A Bus that can fail an access records the failure and hands it back here;
+// the CPU collects it after each instruction and returns it from
+// ExecuteCycle.
+type BusFaulter interface {
+	TakeFault() error
+}
+
+// A Bus with slow devices reports the wait states they inserted during the
+// instruction; the CPU stalls for that many extra cycles.
+type ClockStretcher interface {
+	TakeWaitStates() int
+}
+
+// A Bus that needs to tell opcode fetches apart from data reads is told the
+// address of each instruction before its opcode is read.
+type FetchObserver interface {
+	Fetch(pc uint16)
+}
+
 // ----------------------------------------------------------------------------
 // Structures
 // ----------------------------------------------------------------------------
@@ -47,7 +66,15 @@ type CPU struct {
 	program_counter  uint16
 	bus              Bus
 	remaining_cycles int
+	extra_cycles     int // page crossings and branches taken, this instruction
+	cycles           uint64
 	handlers         map[Instruction]InstructionHandler
+	irq              *InterruptLine
+	nmi              *InterruptLine
+	nmi_edges        uint64
+	traps            map[uint16]Trap
+	call_limit       uint64
+	breaks           uint64 // BRK instructions executed
 }
 
 // ----------------------------------------------------------------------------
@@ -64,11 +91,120 @@ func NewCPU(bus Bus) *CPU {
 		program_counter:  0,
 		bus:              bus,
 		remaining_cycles: 0,
+		irq:              NewInterruptLine(),
+		nmi:              NewInterruptLine(),
 	}
 
 	cpu.handlers = build_call_table(&cpu)
-	startAddress := uint16(bus.Read(0x0ffc)) | uint16(bus.Read(0x0ffd))<<8
-	cpu.program_counter = startAddress
+	cpu.Reset()
 
 	return &cpu
 }
+
+// ----------------------------------------------------------------------------
+// Reset
+
+// Loads the program counter from the reset vector. As on the hardware, the
+// stack pointer ends up at $FD and interrupts are disabled.
+func (cpu *CPU) Reset() {
+	startAddress := uint16(cpu.bus.Read(VECTOR_RESET)) | uint16(cpu.bus.Read(VECTOR_RESET+1))<<8
+	cpu.program_counter = startAddress
+	cpu.stack_pointer = 0xFD
+	cpu.processor_status |= FLAG_IRQ
+	cpu.remaining_cycles = 0
+	cpu.nmi_edges = cpu.nmi.edges
+
+	// Slow vector reads stretch the reset rather than the first instruction.
+	// A fault reading the vector is left for the first cycle to report.
+	if stretcher, ok := cpu.bus.(ClockStretcher); ok {
+		cpu.remaining_cycles += stretcher.TakeWaitStates()
+	}
+}
+
+// ----------------------------------------------------------------------------
+// State
+// ----------------------------------------------------------------------------
+
+// Total number of cycles executed, including wait states
+func (cpu *CPU) Cycles() uint64 {
+	return cpu.cycles
+}
+
+// ----------------------------------------------------------------------------
+// Registers
+
+type Registers struct {
+	A  uint8
+	X  uint8
+	Y  uint8
+	SP uint8
+	P  uint8 // processor status
+	PC uint16
+}
+
+func (cpu *CPU) Registers() Registers {
+	return Registers{
+		A:  cpu.accumulator,
+		X:  cpu.x,
+		Y:  cpu.y,
+		SP: cpu.stack_pointer,
+		P:  cpu.processor_status,
+		PC: cpu.program_counter,
+	}
+}
+
+// Takes effect from the next instruction fetch
+func (cpu *CPU) SetRegisters(r Registers) {
+	cpu.accumulator = r.A
+	cpu.x = r.X
+	cpu.y = r.Y
+	cpu.stack_pointer = r.SP
+	cpu.processor_status = r.P
+	cpu.program_counter = r.PC
+}
+
+// ----------------------------------------------------------------------------
+// Traps
+// ----------------------------------------------------------------------------
+// Go code can stand in for a subroutine: an OS entry point such as CHROUT, a
+// routine from a ROM that cannot be distributed, or a hot library call. When
+// an instruction would be fetched from a trapped address the handler runs
+// instead, with the registers and the bus, taking the time of an RTS. The
+// registers it leaves are loaded back and the CPU returns to the caller,
+// unless the handler moved PC, in which case it carries on from there. An
+// error from the handler stops execution and is returned from ExecuteCycle.
+// ----------------------------------------------------------------------------
+
+type Trap func(registers *Registers, memory Bus) error
+
+const trapCycles = 6
+
+func (cpu *CPU) AddTrap(addr uint16, trap Trap) {
+	if cpu.traps == nil {
+		cpu.traps = make(map[uint16]Trap)
+	}
+	cpu.traps[addr] = trap
+}
+
+func (cpu *CPU) RemoveTrap(addr uint16) {
+	delete(cpu.traps, addr)
+}
+
+func (cpu *CPU) run_trap(trap Trap) error {
+	addr := cpu.program_counter
+	registers := cpu.Registers()
+	err := trap(&registers, cpu.bus)
+	cpu.SetRegisters(registers)
+	if err != nil {
+		return err
+	}
+	if cpu.program_counter == addr {
+		cpu.return_from_subroutine()
+	}
+	return nil
+}
+
+// Returns from the current subroutine, as RTS
+func (cpu *CPU) return_from_subroutine() {
+	cpu.program_counter = cpu.pull_16() + 1
+}
@@ -1,7 +1,8 @@
 package cpu6502
 
 import (
-	"fmt"
+	_ "embed"
+	"errors"
 	"testing"
 )
 
@@ -38,7 +39,7 @@ var instructionTest []byte
 // ----------------------------------------------------------------------------
 
 type Memory struct {
-	data [0xFFFF]uint8
+	data [0x10000]uint8
 }
 
 func (m *Memory) Read(addr uint16) uint8 {
@@ -53,11 +54,301 @@ func (m *Memory) Write(addr uint16, data uint8) {
 // Instruction Set Test
 // ----------------------------------------------------------------------------
 
+// Klaus Dormann's functional test, assembled to run from $0400. Each test
+// case that fails branches to itself; the whole suite passing ends in a jump
+// to itself at $3469.
+const (
+	instructionTestStart   = 0x0400
+	instructionTestSuccess = 0x3469
+	instructionTestCase    = 0x0200 // number of the case running
+)
+
 func TestCPUInstructions(t *testing.T) {
 
+	if testing.Short() {
+		t.Skip("the functional test runs for 96 million cycles")
+	}
 	memory := Memory{}
 	copy(memory.data[:], instructionTest)
 	cpu := NewCPU(&memory)
-	fmt.Printf("cpu: %v\n", cpu)
+	cpu.SetRegisters(Registers{PC: instructionTestStart, SP: 0xFF})
+
+	for {
+		pc := cpu.program_counter
+		if _, err := cpu.Step(); err != nil {
+			t.Fatalf("at $%04X: %v", pc, err)
+		}
+		if cpu.program_counter == pc {
+			break
+		}
+	}
+	if pc := cpu.program_counter; pc != instructionTestSuccess {
+		t.Errorf("trapped at $%04X in test case $%02X", pc, memory.data[instructionTestCase])
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Single Instructions
+// ----------------------------------------------------------------------------
+
+func TestInstructions(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		program []uint8
+		before  Registers
+		memory  map[uint16]uint8
+		after   Registers
+		written map[uint16]uint8
+		cycles  uint64
+	}{
+		{name: "CLD", program: []uint8{0xD8},
+			before: Registers{P: FLAG_DECIMAL | FLAG_CARRY},
+			after:  Registers{P: FLAG_CARRY, PC: 0x0201}, cycles: 2},
+		{name: "SED", program: []uint8{0xF8},
+			after: Registers{P: FLAG_DECIMAL, PC: 0x0201}, cycles: 2},
+		{name: "CLC", program: []uint8{0x18},
+			before: Registers{P: FLAG_CARRY | FLAG_ZERO},
+			after:  Registers{P: FLAG_ZERO, PC: 0x0201}, cycles: 2},
+		{name: "SEC", program: []uint8{0x38},
+			after: Registers{P: FLAG_CARRY, PC: 0x0201}, cycles: 2},
+		{name: "CLV", program: []uint8{0xB8},
+			before: Registers{P: FLAG_OVERFLOW},
+			after:  Registers{PC: 0x0201}, cycles: 2},
+		{name: "NOP", program: []uint8{0xEA},
+			before: Registers{A: 1, X: 2, Y: 3, SP: 4},
+			after:  Registers{A: 1, X: 2, Y: 3, SP: 4, PC: 0x0201}, cycles: 2},
+
+		{name: "TAX", program: []uint8{0xAA},
+			before: Registers{A: 0x80},
+			after:  Registers{A: 0x80, X: 0x80, P: FLAG_NEGATIVE, PC: 0x0201}, cycles: 2},
+		{name: "TAY", program: []uint8{0xA8},
+			before: Registers{Y: 5},
+			after:  Registers{P: FLAG_ZERO, PC: 0x0201}, cycles: 2},
+		{name: "TXA", program: []uint8{0x8A},
+			before: Registers{X: 1},
+			after:  Registers{A: 1, X: 1, PC: 0x0201}, cycles: 2},
+		{name: "TYA", program: []uint8{0x98},
+			before: Registers{A: 7, Y: 0xFF},
+			after:  Registers{A: 0xFF, Y: 0xFF, P: FLAG_NEGATIVE, PC: 0x0201}, cycles: 2},
+		{name: "TSX", program: []uint8{0xBA},
+			before: Registers{SP: 0xF0},
+			after:  Registers{X: 0xF0, SP: 0xF0, P: FLAG_NEGATIVE, PC: 0x0201}, cycles: 2},
+		{name: "TXS leaves the flags", program: []uint8{0x9A},
+			before: Registers{SP: 0xFF, P: FLAG_NEGATIVE},
+			after:  Registers{P: FLAG_NEGATIVE, PC: 0x0201}, cycles: 2},
+
+		{name: "PHA", program: []uint8{0x48},
+			before:  Registers{A: 0x42, SP: 0xFF},
+			after:   Registers{A: 0x42, SP: 0xFE, PC: 0x0201},
+			written: map[uint16]uint8{0x01FF: 0x42}, cycles: 3},
+		{name: "PLA", program: []uint8{0x68},
+			before: Registers{SP: 0xFE},
+			memory: map[uint16]uint8{0x01FF: 0x80},
+			after:  Registers{A: 0x80, SP: 0xFF, P: FLAG_NEGATIVE, PC: 0x0201}, cycles: 4},
+		{name: "PHP sets B", program: []uint8{0x08},
+			before:  Registers{SP: 0xFF, P: FLAG_CARRY},
+			after:   Registers{SP: 0xFE, P: FLAG_CARRY, PC: 0x0201},
+			written: map[uint16]uint8{0x01FF: FLAG_CARRY | FLAG_BRK | FLAG_UNUSED}, cycles: 3},
+		{name: "PLP ignores B", program: []uint8{0x28},
+			before: Registers{SP: 0xFE},
+			memory: map[uint16]uint8{0x01FF: 0xFF},
+			after:  Registers{SP: 0xFF, P: 0xFF &^ FLAG_BRK, PC: 0x0201}, cycles: 4},
+
+		{name: "ASL A", program: []uint8{0x0A},
+			before: Registers{A: 0x81},
+			after:  Registers{A: 0x02, P: FLAG_CARRY, PC: 0x0201}, cycles: 2},
+		{name: "LSR A", program: []uint8{0x4A},
+			before: Registers{A: 0x01, P: FLAG_NEGATIVE},
+			after:  Registers{P: FLAG_ZERO | FLAG_CARRY, PC: 0x0201}, cycles: 2},
+		{name: "ROL A", program: []uint8{0x2A},
+			before: Registers{A: 0x80, P: FLAG_CARRY},
+			after:  Registers{A: 0x01, P: FLAG_CARRY, PC: 0x0201}, cycles: 2},
+		{name: "ROR A", program: []uint8{0x6A},
+			before: Registers{A: 0x01, P: FLAG_CARRY},
+			after:  Registers{A: 0x80, P: FLAG_NEGATIVE | FLAG_CARRY, PC: 0x0201}, cycles: 2},
+		{name: "ASL zero page", program: []uint8{0x06, 0x10},
+			memory:  map[uint16]uint8{0x0010: 0x40},
+			after:   Registers{P: FLAG_NEGATIVE, PC: 0x0202},
+			written: map[uint16]uint8{0x0010: 0x80}, cycles: 5},
+		{name: "ROR absolute", program: []uint8{0x6E, 0x00, 0x03},
+			memory:  map[uint16]uint8{0x0300: 0x02},
+			after:   Registers{PC: 0x0203},
+			written: map[uint16]uint8{0x0300: 0x01}, cycles: 6},
+		{name: "ROR absolute,X", program: []uint8{0x7E, 0x00, 0x03},
+			before:  Registers{X: 1, P: FLAG_CARRY},
+			memory:  map[uint16]uint8{0x0301: 0x00},
+			after:   Registers{X: 1, P: FLAG_NEGATIVE, PC: 0x0203},
+			written: map[uint16]uint8{0x0301: 0x80}, cycles: 7},
+
+		{name: "BRK", program: []uint8{0x00, 0xFF},
+			before:  Registers{SP: 0xFF, P: FLAG_CARRY},
+			memory:  map[uint16]uint8{0xFFFE: 0x00, 0xFFFF: 0x04},
+			after:   Registers{SP: 0xFC, P: FLAG_CARRY | FLAG_IRQ, PC: 0x0400},
+			written: map[uint16]uint8{0x01FF: 0x02, 0x01FE: 0x02, 0x01FD: FLAG_CARRY | FLAG_BRK | FLAG_UNUSED}, cycles: 7},
+
+		{name: "ADC binary overflow", program: []uint8{0x69, 0x50},
+			before: Registers{A: 0x50},
+			after:  Registers{A: 0xA0, P: FLAG_NEGATIVE | FLAG_OVERFLOW, PC: 0x0202}, cycles: 2},
+		{name: "ADC decimal", program: []uint8{0x69, 0x01},
+			before: Registers{A: 0x99, P: FLAG_DECIMAL},
+			after:  Registers{A: 0x00, P: FLAG_DECIMAL | FLAG_CARRY | FLAG_NEGATIVE, PC: 0x0202}, cycles: 2},
+		{name: "SBC decimal", program: []uint8{0xE9, 0x01},
+			before: Registers{A: 0x20, P: FLAG_DECIMAL | FLAG_CARRY},
+			after:  Registers{A: 0x19, P: FLAG_DECIMAL | FLAG_CARRY, PC: 0x0202}, cycles: 2},
+		{name: "CMP equal sets carry", program: []uint8{0xC9, 0x05},
+			before: Registers{A: 0x05},
+			after:  Registers{A: 0x05, P: FLAG_ZERO | FLAG_CARRY, PC: 0x0202}, cycles: 2},
+
+		{name: "BNE backwards", program: []uint8{0xD0, 0xFC},
+			after: Registers{PC: 0x01FE}, cycles: 4},
+		{name: "BEQ not taken", program: []uint8{0xF0, 0x10},
+			after: Registers{PC: 0x0202}, cycles: 2},
+		{name: "LDA absolute,X across a page", program: []uint8{0xBD, 0xFF, 0x02},
+			before: Registers{X: 1},
+			memory: map[uint16]uint8{0x0300: 0x01},
+			after:  Registers{A: 0x01, X: 1, PC: 0x0203}, cycles: 5},
+		{name: "LDA (zero page),Y", program: []uint8{0xB1, 0xFF},
+			before: Registers{Y: 2},
+			memory: map[uint16]uint8{0x00FF: 0x00, 0x0000: 0x03, 0x0302: 0x33},
+			after:  Registers{A: 0x33, Y: 2, PC: 0x0202}, cycles: 5},
+		{name: "STA (zero page,X)", program: []uint8{0x81, 0x10},
+			before:  Registers{A: 0x44, X: 4},
+			memory:  map[uint16]uint8{0x0014: 0x00, 0x0015: 0x03},
+			after:   Registers{A: 0x44, X: 4, PC: 0x0202},
+			written: map[uint16]uint8{0x0300: 0x44}, cycles: 6},
+		{name: "JMP absolute", program: []uint8{0x4C, 0x34, 0x12},
+			after: Registers{PC: 0x1234}, cycles: 3},
+		{name: "JMP indirect at a page end", program: []uint8{0x6C, 0xFF, 0x03},
+			memory: map[uint16]uint8{0x03FF: 0x34, 0x0300: 0x12, 0x0400: 0x56},
+			after:  Registers{PC: 0x1234}, cycles: 5},
+	}
+
+	for _, test := range cases {
+		cpu, ram := newIdleCPU()
+		copy(ram[0x0200:], test.program)
+		for addr, data := range test.memory {
+			ram[addr] = data
+		}
+		test.before.PC = 0x0200
+		cpu.SetRegisters(test.before)
+
+		cycles, err := cpu.Step()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if registers := cpu.Registers(); registers != test.after {
+			t.Errorf("%s: registers %+v, expected %+v", test.name, registers, test.after)
+		}
+		if cycles != test.cycles {
+			t.Errorf("%s: took %d cycles, expected %d", test.name, cycles, test.cycles)
+		}
+		for addr, data := range test.written {
+			if ram[addr] != data {
+				t.Errorf("%s: $%04X is $%02X, expected $%02X", test.name, addr, ram[addr], data)
+			}
+		}
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Traps
+// ----------------------------------------------------------------------------
+
+func TestTrapsReturnToCaller(t *testing.T) {
+
+	cpu, ram := newIdleCPU()
+	copy(ram[0x0200:], []uint8{
+		0xA9, 'H', 0x20, 0xD2, 0xFF, // LDA #'H', JSR CHROUT
+		0xA9, 'I', 0x20, 0xD2, 0xFF, // LDA #'I', JSR CHROUT
+		0x20, 0xE4, 0xFF, // JSR $FFE4
+	})
+	copy(ram[0xFFFC:], []uint8{0x00, 0x02})
+	cpu.Reset()
+
+	var out []uint8
+	cpu.AddTrap(0xFFD2, func(registers *Registers, memory Bus) error {
+		out = append(out, registers.A)
+		registers.X = 0x55
+		return nil
+	})
+	stop := errors.New("stop")
+	cpu.AddTrap(0xFFE4, func(registers *Registers, memory Bus) error {
+		return stop
+	})
+
+	// Two LDA, JSR and trap sequences of 2+6+6 cycles
+	for range 28 {
+		if err := cpu.ExecuteCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registers := cpu.Registers()
+	if string(out) != "HI" {
+		t.Errorf("trap saw %q", out)
+	}
+	if registers.PC != 0x020A || registers.SP != 0xFD || registers.X != 0x55 {
+		t.Errorf("after the traps: %+v", registers)
+	}
+
+	var err error
+	for range 20 {
+		if err = cpu.ExecuteCycle(); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, stop) {
+		t.Errorf("trap error not returned: %v", err)
+	}
+
+	cpu.RemoveTrap(0xFFD2)
+	if _, found := cpu.traps[0xFFD2]; found {
+		t.Errorf("trap not removed")
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Subroutine Calls
+// ----------------------------------------------------------------------------
+
+func TestCallReturnsRegisters(t *testing.T) {
+
+	cpu, ram := newIdleCPU()
+	copy(ram[0x0300:], []uint8{0x86, 0x10, 0xE6, 0x10, 0xA5, 0x10, 0x60}) // STX $10, INC $10, LDA $10, RTS
+	ram[0x0400] = 0x00                                                    // BRK
+	ram[0x0500] = 0x40                                                    // RTI
+
+	registers, cycles, err := cpu.Call(0x0300, Registers{X: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registers.A != 8 || registers.SP != 0xFF || registers.PC != CALL_RETURN {
+		t.Errorf("returned %+v", registers)
+	}
+	if cycles != 17 {
+		t.Errorf("took %d cycles, expected 17", cycles)
+	}
+
+	// Stopped at the vector, the rest of memory being LDA #$A9
+	registers, _, err = cpu.Call(0x0400, Registers{})
+	if err == nil {
+		t.Errorf("no error for BRK")
+	}
+	if registers.PC != 0xA9A9 || registers.SP != 0xFA || ram[0x01FB]&FLAG_BRK == 0 {
+		t.Errorf("BRK left %+v, pushed P $%02X", registers, ram[0x01FB])
+	}
+	if _, _, err := cpu.Call(0x0500, Registers{SP: 0xF0}); err == nil {
+		t.Errorf("no error for stack underflow")
+	}
+
+	// Nothing but LDA #$A9 from $0700
+	cpu.SetCallLimit(100)
+	if _, cycles, err := cpu.Call(0x0700, Registers{}); err == nil || cycles != 100 {
+		t.Errorf("limit not enforced: %d cycles, %v", cycles, err)
+	}
 
 }
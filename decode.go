@@ -73,6 +73,38 @@ func build_call_table(cpu *CPU) map[Instruction]InstructionHandler {
 	t[CPY] = cpu.cpy
 	t[BIT] = cpu.bit
 
+	t[JSR] = cpu.jsr
+	t[RTS] = cpu.rts
+
+	t[ASL] = cpu.asl
+	t[LSR] = cpu.lsr
+	t[ROL] = cpu.rol
+	t[ROR] = cpu.ror
+
+	t[PHA] = cpu.pha
+	t[PLA] = cpu.pla
+	t[PHP] = cpu.php
+	t[PLP] = cpu.plp
+
+	t[TAX] = cpu.tax
+	t[TAY] = cpu.tay
+	t[TXA] = cpu.txa
+	t[TYA] = cpu.tya
+	t[TSX] = cpu.tsx
+	t[TXS] = cpu.txs
+
+	t[CLC] = cpu.clc
+	t[SEC] = cpu.sec
+	t[CLV] = cpu.clv
+	t[CLD] = cpu.cld
+	t[SED] = cpu.sed
+	t[NOP] = cpu.nop
+
+	t[BRK] = cpu.brk
+	t[RTI] = cpu.rti
+	t[SEI] = cpu.sei
+	t[CLI] = cpu.cli
+
 	return t
 }
 
@@ -80,7 +112,9 @@ func build_call_table(cpu *CPU) map[Instruction]InstructionHandler {
 // Instruction Execution
 // ----------------------------------------------------------------------------
 
-func (cpu *CPU) ExecuteCycle() error {
+func (cpu *CPU) ExecuteCycle() (err error) {
+
+	cpu.cycles++
 
 	// Burn off any remaining cycles from the last instruction
 	if cpu.remaining_cycles > 0 {
@@ -88,8 +122,30 @@ func (cpu *CPU) ExecuteCycle() error {
 		return nil
 	}
 
+	// Take any pending interrupt before the next instruction
+	if taken, err := cpu.service_interrupts(); taken {
+		return err
+	}
+
+	// Go code standing in for the subroutine here
+	if trap, found := cpu.traps[cpu.program_counter]; found {
+		if err := cpu.run_trap(trap); err != nil {
+			return err
+		}
+		cpu.remaining_cycles = trapCycles - 1
+		return nil
+	}
+
 	// Read the opcode and load the instruction
+	if observer, ok := cpu.bus.(FetchObserver); ok {
+		observer.Fetch(cpu.program_counter)
+	}
 	opcode := cpu.bus.Read(cpu.program_counter)
+	defer func() {
+		if fault := cpu.collect_bus(); fault != nil {
+			err = fault
+		}
+	}()
 	instruction := instructionTable[opcode]
 	if instruction.instruction == UNDEFINED {
 		return fmt.Errorf("invalid opcode: %02X", opcode)
@@ -101,9 +157,40 @@ func (cpu *CPU) ExecuteCycle() error {
 	if !found {
 		return fmt.Errorf("unsupported instruction: %s", instruction.mnemonic)
 	}
+	cpu.extra_cycles = 0
 	handler(&instruction)
-	cpu.remaining_cycles = instruction.cycles - 1
+	cpu.remaining_cycles = instruction.cycles - 1 + cpu.extra_cycles
+
+	return nil
+
+}
 
+// Run once the bus has been used, however the instruction ended: stalls for
+// any wait states inserted by slow devices and returns any fault raised, which
+// takes precedence over a decode error as it may be the cause
+func (cpu *CPU) collect_bus() error {
+	if stretcher, ok := cpu.bus.(ClockStretcher); ok {
+		cpu.remaining_cycles += stretcher.TakeWaitStates()
+	}
+	if faulter, ok := cpu.bus.(BusFaulter); ok {
+		return faulter.TakeFault()
+	}
 	return nil
+}
 
+// Finishes the instruction in progress, if any, then runs the next one - or
+// the interrupt sequence or trap in its place - to completion. Returns the
+// cycles taken. Devices on a scheduler do not see them.
+func (cpu *CPU) Step() (uint64, error) {
+	start := cpu.cycles
+	for cpu.remaining_cycles > 0 {
+		cpu.ExecuteCycle()
+	}
+	if err := cpu.ExecuteCycle(); err != nil {
+		return cpu.cycles - start, err
+	}
+	for cpu.remaining_cycles > 0 {
+		cpu.ExecuteCycle()
+	}
+	return cpu.cycles - start, nil
 }
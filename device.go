@@ -0,0 +1,53 @@
+package cpu6502
+
+import "math"
+
+// ----------------------------------------------------------------------------
+// device.go
+// Clocked peripheral interface
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Interfaces
+// ----------------------------------------------------------------------------
+// Devices are not ticked every cycle. The scheduler asks each one how far away
+// its next event is (a timer underflow, a serial bit completing) and only
+// ticks it when that event falls due or when the CPU touches its registers.
+// Tick must therefore cope with any number of cycles at once.
+// ----------------------------------------------------------------------------
+
+// Returned from NextEvent by a device that is idle until accessed
+const NO_EVENT uint64 = math.MaxUint64
+
+type Device interface {
+	Reset()
+	Tick(cycles uint64) // Advance by a number of the device's own cycles
+	NextEvent() uint64  // Device cycles until the next event, or NO_EVENT
+	IRQ() bool          // True while the IRQ output is asserted
+}
+
+// A device with registers on the bus
+type BusDevice interface {
+	Bus
+	Device
+}
@@ -0,0 +1,385 @@
+package cpu6502
+
+import "io"
+
+// ----------------------------------------------------------------------------
+// device_acia.go
+// MOS 6551 Asynchronous Communications Interface Adapter
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+
+const (
+	ACIA_DATA    = 0x0
+	ACIA_STATUS  = 0x1 // writing performs a programmed reset
+	ACIA_COMMAND = 0x2
+	ACIA_CONTROL = 0x3
+)
+
+// ----------------------------------------------------------------------------
+// Status register
+
+const (
+	ACIA_STATUS_PARITY  = 0b00000001
+	ACIA_STATUS_FRAMING = 0b00000010
+	ACIA_STATUS_OVERRUN = 0b00000100
+	ACIA_STATUS_RDRF    = 0b00001000 // receive data register full
+	ACIA_STATUS_TDRE    = 0b00010000 // transmit data register empty
+	ACIA_STATUS_DCD     = 0b00100000
+	ACIA_STATUS_DSR     = 0b01000000
+	ACIA_STATUS_IRQ     = 0b10000000
+)
+
+// ----------------------------------------------------------------------------
+// Command and control registers
+
+const (
+	ACIA_COMMAND_DTR     = 0b00000001 // enables the receiver and interrupts
+	ACIA_COMMAND_IRD     = 0b00000010 // disables the receiver interrupt
+	ACIA_COMMAND_TX_MASK = 0b00001100
+	ACIA_COMMAND_TX_IRQ  = 0b00000100 // transmitter interrupt enabled
+	ACIA_COMMAND_ECHO    = 0b00010000
+	ACIA_COMMAND_PARITY  = 0b00100000
+	ACIA_CONTROL_BAUD    = 0b00001111
+	ACIA_CONTROL_LENGTH  = 0b01100000
+	ACIA_CONTROL_STOP    = 0b10000000
+)
+
+// The ACIA is clocked from a 1.8432MHz crystal. Attach it to the scheduler
+// at ACIA_CLOCK:CPU clock and bit times come out exact.
+const ACIA_CLOCK = 1843200
+
+// Crystal cycles per bit for each baud rate selection. Selection zero uses
+// the external 16x clock, which is taken to be the crystal.
+var aciaBitCycles = [16]uint64{
+	16, 36864, 24576, 16752, 13696, 12288, 6144, 3072,
+	1536, 1024, 768, 512, 384, 256, 192, 96,
+}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type ACIA struct {
+	status     uint8
+	command    uint8
+	control    uint8
+	rx_data    uint8
+	rx_timer   uint64 // cycles until the next character could arrive
+	rx_queue   []uint8
+	rx_channel chan uint8
+	rx_stop    chan struct{} // closed to stop the goroutine reading in
+	tx_data    uint8
+	tx_pending bool // holding register is full
+	tx_busy    bool // shift register is sending
+	tx_shift   uint8
+	tx_timer   uint64 // cycles until the shift register is empty
+	out        io.Writer
+	err        error
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+// Received bytes come from in (read on a separate goroutine so a terminal or
+// pipe never blocks the emulator) and transmitted bytes go to out. Either may
+// be nil; Receive queues bytes directly for tests and scripted input.
+//
+// Connecting again or closing stops the goroutine reading the last reader.
+// One blocked in Read exits once that read returns, so close a reader that
+// may never return data.
+// ----------------------------------------------------------------------------
+
+func NewACIA(in io.Reader, out io.Writer) *ACIA {
+	a := &ACIA{}
+	a.Connect(in, out)
+	a.Reset()
+	return a
+}
+
+// Replaces the host side of the serial line, as NewACIA
+func (a *ACIA) Connect(in io.Reader, out io.Writer) {
+	a.disconnect()
+	if in != nil {
+		a.rx_channel = make(chan uint8, 256)
+		a.rx_stop = make(chan struct{})
+		go pump(in, a.rx_channel, a.rx_stop)
+	}
+	a.out = out
+}
+
+// Disconnects the host side of the line. Neither reader nor writer is
+// closed.
+func (a *ACIA) Close() error {
+	a.disconnect()
+	a.out = nil
+	return nil
+}
+
+func (a *ACIA) disconnect() {
+	if a.rx_stop != nil {
+		close(a.rx_stop)
+		a.rx_stop = nil
+	}
+	a.rx_channel = nil
+}
+
+// Copies in to channel until in fails, which closes the channel, or until
+// stop is closed. A nil stop never stops.
+func pump(in io.Reader, channel chan<- uint8, stop <-chan struct{}) {
+	var buffer [256]uint8
+	for {
+		n, err := in.Read(buffer[:])
+		for _, data := range buffer[:n] {
+			select {
+			case channel <- data:
+			case <-stop:
+				return
+			}
+		}
+		if err != nil {
+			close(channel)
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+func (a *ACIA) Receive(data ...uint8) {
+	a.rx_queue = append(a.rx_queue, data...)
+}
+
+// The first error returned by the output writer
+func (a *ACIA) Err() error {
+	return a.err
+}
+
+// ----------------------------------------------------------------------------
+// Timing
+// ----------------------------------------------------------------------------
+
+func (a *ACIA) bit_cycles() uint64 {
+	return aciaBitCycles[a.control&ACIA_CONTROL_BAUD]
+}
+
+func (a *ACIA) word_length() uint {
+	return 8 - uint(a.control&ACIA_CONTROL_LENGTH>>5)
+}
+
+// Start bit, data bits, optional parity and one or two stop bits
+func (a *ACIA) character_cycles() uint64 {
+	bits := uint64(1 + a.word_length() + 1)
+	if a.command&ACIA_COMMAND_PARITY != 0 {
+		bits++
+	}
+	if a.control&ACIA_CONTROL_STOP != 0 {
+		bits++
+	}
+	return bits * a.bit_cycles()
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (a *ACIA) Reset() {
+	a.status = ACIA_STATUS_TDRE
+	a.command = ACIA_COMMAND_IRD
+	a.control = 0
+	a.tx_pending = false
+	a.tx_busy = false
+	a.rx_timer = a.character_cycles()
+}
+
+func (a *ACIA) Tick(cycles uint64) {
+	for cycles > 0 {
+		step := min(cycles, a.rx_timer)
+		if a.tx_busy {
+			step = min(step, a.tx_timer)
+		}
+		cycles -= step
+
+		if a.tx_busy {
+			a.tx_timer -= step
+			if a.tx_timer == 0 {
+				a.transmit_complete()
+			}
+		}
+		a.rx_timer -= step
+		if a.rx_timer == 0 {
+			a.rx_timer = a.character_cycles()
+			a.receive_next()
+		}
+	}
+}
+
+func (a *ACIA) NextEvent() uint64 {
+	next := NO_EVENT
+	if a.tx_busy {
+		next = a.tx_timer
+	}
+	if a.command&ACIA_COMMAND_DTR != 0 {
+		next = min(next, a.rx_timer)
+	}
+	return next
+}
+
+func (a *ACIA) IRQ() bool {
+	return a.status&ACIA_STATUS_IRQ != 0
+}
+
+// ----------------------------------------------------------------------------
+// Receiver
+// ----------------------------------------------------------------------------
+
+func (a *ACIA) receive_next() {
+	if a.command&ACIA_COMMAND_DTR == 0 {
+		return
+	}
+
+	var data uint8
+	if len(a.rx_queue) > 0 {
+		data = a.rx_queue[0]
+		a.rx_queue = a.rx_queue[1:]
+	} else if a.rx_channel != nil {
+		select {
+		case received, ok := <-a.rx_channel:
+			if !ok {
+				a.rx_channel = nil
+				return
+			}
+			data = received
+		default:
+			return
+		}
+	} else {
+		return
+	}
+
+	// The previous character is kept if it was never read
+	if a.status&ACIA_STATUS_RDRF != 0 {
+		a.status |= ACIA_STATUS_OVERRUN
+		return
+	}
+	a.rx_data = data & uint8(0xFF>>(8-a.word_length()))
+	a.status |= ACIA_STATUS_RDRF
+	if a.command&ACIA_COMMAND_IRD == 0 {
+		a.status |= ACIA_STATUS_IRQ
+	}
+
+	// Echo mode retransmits when the transmitter is otherwise unused
+	if a.command&ACIA_COMMAND_ECHO != 0 && a.command&ACIA_COMMAND_TX_MASK == 0 {
+		a.output(a.rx_data)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Transmitter
+// ----------------------------------------------------------------------------
+
+func (a *ACIA) transmit(data uint8) {
+	if a.tx_busy {
+		a.tx_data = data
+		a.tx_pending = true
+		a.status &^= ACIA_STATUS_TDRE
+		return
+	}
+	a.tx_shift = data
+	a.tx_busy = true
+	a.tx_timer = a.character_cycles()
+	a.transmit_ready()
+}
+
+func (a *ACIA) transmit_complete() {
+	a.output(a.tx_shift)
+	a.tx_busy = false
+
+	if a.tx_pending {
+		a.tx_pending = false
+		a.status |= ACIA_STATUS_TDRE
+		a.transmit(a.tx_data)
+		return
+	}
+	a.transmit_ready()
+}
+
+// Interrupts whenever the holding register is found empty with the
+// transmitter interrupt enabled: on enabling it, as a character moves to the
+// shift register, and as each one finishes
+func (a *ACIA) transmit_ready() {
+	if a.status&ACIA_STATUS_TDRE != 0 && a.command&ACIA_COMMAND_DTR != 0 &&
+		a.command&ACIA_COMMAND_TX_MASK == ACIA_COMMAND_TX_IRQ {
+		a.status |= ACIA_STATUS_IRQ
+	}
+}
+
+func (a *ACIA) output(data uint8) {
+	if a.out == nil || a.err != nil {
+		return
+	}
+	_, a.err = a.out.Write([]uint8{data & uint8(0xFF>>(8-a.word_length()))})
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (a *ACIA) Read(addr uint16) uint8 {
+	switch addr & 0x03 {
+	case ACIA_DATA:
+		a.status &^= ACIA_STATUS_RDRF | ACIA_STATUS_OVERRUN |
+			ACIA_STATUS_FRAMING | ACIA_STATUS_PARITY
+		return a.rx_data
+	case ACIA_STATUS:
+		status := a.status
+		a.status &^= ACIA_STATUS_IRQ
+		return status
+	case ACIA_COMMAND:
+		return a.command
+	default: // ACIA_CONTROL
+		return a.control
+	}
+}
+
+func (a *ACIA) Write(addr uint16, data uint8) {
+	switch addr & 0x03 {
+	case ACIA_DATA:
+		a.transmit(data)
+	case ACIA_STATUS:
+		// Programmed reset clears the low command bits and overrun
+		a.command &^= 0b00011111
+		a.status &^= ACIA_STATUS_OVERRUN
+	case ACIA_COMMAND:
+		a.command = data
+		a.transmit_ready()
+	default: // ACIA_CONTROL
+		a.control = data
+		a.rx_timer = min(a.rx_timer, a.character_cycles())
+	}
+}
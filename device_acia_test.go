@@ -0,0 +1,172 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// device_acia_test.go
+// Tests the 6551 ACIA
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Transmitter
+// ----------------------------------------------------------------------------
+
+func TestACIATransmitInterrupts(t *testing.T) {
+
+	var out strings.Builder
+	acia := NewACIA(nil, &out)
+	acia.Write(ACIA_CONTROL, 0x1F) // 19200 baud, 8N1
+	character := acia.character_cycles()
+
+	// Enabling the interrupt with the holding register empty raises it
+	acia.Write(ACIA_COMMAND, ACIA_COMMAND_DTR|ACIA_COMMAND_IRD|ACIA_COMMAND_TX_IRQ)
+	if !acia.IRQ() {
+		t.Errorf("no interrupt on enabling the transmitter interrupt")
+	}
+	if status := acia.Read(ACIA_STATUS); status&ACIA_STATUS_IRQ == 0 || acia.IRQ() {
+		t.Errorf("status read %02X did not acknowledge", status)
+	}
+
+	// The first character moves straight to the shift register
+	acia.Write(ACIA_DATA, 'A')
+	if status := acia.Read(ACIA_STATUS); status&(ACIA_STATUS_IRQ|ACIA_STATUS_TDRE) !=
+		ACIA_STATUS_IRQ|ACIA_STATUS_TDRE {
+		t.Errorf("status read %02X as the first character started", status)
+	}
+
+	// The second waits in the holding register
+	acia.Write(ACIA_DATA, 'B')
+	if status := acia.Read(ACIA_STATUS); status&(ACIA_STATUS_IRQ|ACIA_STATUS_TDRE) != 0 {
+		t.Errorf("status read %02X with the holding register full", status)
+	}
+	acia.Tick(character)
+	if out.String() != "A" || !acia.IRQ() {
+		t.Errorf("sent %q, interrupt %v", out.String(), acia.IRQ())
+	}
+	acia.Read(ACIA_STATUS)
+
+	// Finishing the last character interrupts again
+	acia.Tick(character - 1)
+	if acia.IRQ() {
+		t.Errorf("interrupt before the second character finished")
+	}
+	acia.Tick(1)
+	if out.String() != "AB" || !acia.IRQ() {
+		t.Errorf("sent %q, interrupt %v", out.String(), acia.IRQ())
+	}
+
+	// Disabled, or with DTR off, nothing interrupts
+	acia.Read(ACIA_STATUS)
+	acia.Write(ACIA_COMMAND, ACIA_COMMAND_DTR|ACIA_COMMAND_IRD)
+	acia.Write(ACIA_DATA, 'C')
+	acia.Tick(character)
+	acia.Write(ACIA_COMMAND, ACIA_COMMAND_IRD|ACIA_COMMAND_TX_IRQ)
+	if acia.IRQ() || out.String() != "ABC" {
+		t.Errorf("sent %q, interrupt %v", out.String(), acia.IRQ())
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Receiver
+// ----------------------------------------------------------------------------
+
+func TestACIAReceive(t *testing.T) {
+
+	acia := NewACIA(nil, nil)
+	acia.Write(ACIA_CONTROL, 0x1F)
+	acia.Write(ACIA_COMMAND, ACIA_COMMAND_DTR)
+	acia.Receive('x', 'y', 'z')
+	character := acia.character_cycles()
+
+	acia.Tick(character)
+	status := acia.Read(ACIA_STATUS)
+	if status&ACIA_STATUS_RDRF == 0 || status&ACIA_STATUS_IRQ == 0 {
+		t.Errorf("status read %02X after a character arrived", status)
+	}
+	if data := acia.Read(ACIA_DATA); data != 'x' {
+		t.Errorf("received %02X", data)
+	}
+	if acia.Read(ACIA_STATUS)&ACIA_STATUS_RDRF != 0 {
+		t.Errorf("reading data did not clear RDRF")
+	}
+
+	// A character not read before the next arrives is overrun
+	acia.Tick(2 * character)
+	if status := acia.Read(ACIA_STATUS); status&ACIA_STATUS_OVERRUN == 0 {
+		t.Errorf("status read %02X after an overrun", status)
+	}
+	if data := acia.Read(ACIA_DATA); data != 'y' {
+		t.Errorf("received %02X, expected the character before the overrun", data)
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Host Connection
+// ----------------------------------------------------------------------------
+
+// Hands each slice sent on the channel to one call of Read
+type chanReader chan []uint8
+
+func (r chanReader) Read(buffer []uint8) (int, error) {
+	return copy(buffer, <-r), nil
+}
+
+// Whether a read was waiting within a short time
+func (r chanReader) offer(data string) bool {
+	select {
+	case r <- []uint8(data):
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestACIAReconnectStopsReader(t *testing.T) {
+
+	first := make(chanReader)
+	acia := NewACIA(first, nil)
+	first <- []uint8("a")
+
+	// A reader already blocked in Read returns once more, then is abandoned
+	acia.Connect(nil, nil)
+	first.offer("b")
+	if first.offer("c") {
+		t.Errorf("reader still read after reconnecting")
+	}
+
+	second := make(chanReader)
+	acia.Connect(second, nil)
+	second <- []uint8("d")
+	acia.Close()
+	second.offer("e")
+	if second.offer("f") {
+		t.Errorf("reader still read after closing")
+	}
+
+}
@@ -0,0 +1,437 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_ay.go
+// General Instrument AY-3-8910 / Yamaha YM2149 programmable sound generator
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+
+const (
+	AY_TONE_A_FINE   = 0x0
+	AY_TONE_A_COARSE = 0x1
+	AY_TONE_B_FINE   = 0x2
+	AY_TONE_B_COARSE = 0x3
+	AY_TONE_C_FINE   = 0x4
+	AY_TONE_C_COARSE = 0x5
+	AY_NOISE         = 0x6
+	AY_MIXER         = 0x7
+	AY_LEVEL_A       = 0x8
+	AY_LEVEL_B       = 0x9
+	AY_LEVEL_C       = 0xA
+	AY_ENV_FINE      = 0xB
+	AY_ENV_COARSE    = 0xC
+	AY_ENV_SHAPE     = 0xD
+	AY_PORT_A        = 0xE
+	AY_PORT_B        = 0xF
+)
+
+// ----------------------------------------------------------------------------
+// Register bits
+
+const (
+	AY_MIXER_PORT_A  = 0b01000000 // port A is an output
+	AY_MIXER_PORT_B  = 0b10000000
+	AY_LEVEL_ENV     = 0b00010000 // amplitude follows the envelope
+	AY_ENV_HOLD      = 0b00000001
+	AY_ENV_ALTERNATE = 0b00000010
+	AY_ENV_ATTACK    = 0b00000100
+	AY_ENV_CONTINUE  = 0b00001000
+)
+
+// Bits implemented in each register; the rest read back as zero
+var ayMasks = [16]uint8{
+	0xFF, 0x0F, 0xFF, 0x0F, 0xFF, 0x0F, 0x1F, 0xFF,
+	0x1F, 0x1F, 0x1F, 0xFF, 0xFF, 0x0F, 0xFF, 0xFF,
+}
+
+// Output levels of the logarithmic DAC, full scale 1.0
+var ayLevels = [16]float64{
+	0.0, 0.0106, 0.0150, 0.0222, 0.0320, 0.0466, 0.0665, 0.1039,
+	0.1237, 0.1986, 0.2803, 0.3548, 0.4702, 0.6030, 0.7530, 1.0,
+}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type ayTone struct {
+	counter uint16
+	output  bool
+}
+
+type AY38910 struct {
+	registers [16]uint8
+	address   uint8
+	selected  bool // the latched address had the chip's high nibble
+	port_a    Port
+	port_b    Port
+
+	// Generators: the tones step once every 8 master clocks, toggling every
+	// period steps, and noise and envelope once every 16
+	prescaler  uint64
+	tones      [3]ayTone
+	noise      uint32 // 17-bit LFSR
+	noise_step uint16
+	envelope   uint8 // current level, 0-15
+	env_count  uint16
+	env_up     bool
+	env_hold   bool
+
+	// Sampling, by averaging the mix over each output sample
+	clock   uint64 // master clock in Hz
+	rate    uint64 // sample rate in Hz
+	phase   uint64
+	sum     float64
+	count   int
+	samples []int16
+
+	// Port pin wiring
+	wiring AY38910Wiring
+	mode   uint8 // BDIR and BC1 as bits 1 and 0
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+// Attach the chip to the scheduler at its master clock against the CPU
+// clock, so Tick counts master clock cycles. Output is mono PCM at rate.
+// ----------------------------------------------------------------------------
+
+func NewAY38910(clock uint64, rate uint64) *AY38910 {
+	ay := &AY38910{clock: clock, rate: rate}
+	ay.Reset()
+	return ay
+}
+
+func (ay *AY38910) PortA() *Port {
+	return &ay.port_a
+}
+
+func (ay *AY38910) PortB() *Port {
+	return &ay.port_b
+}
+
+func (ay *AY38910) SampleRate() uint64 {
+	return ay.rate
+}
+
+// Takes the samples rendered so far
+func (ay *AY38910) Samples() []int16 {
+	samples := ay.samples
+	ay.samples = nil
+	return samples
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (ay *AY38910) Reset() {
+	ay.registers = [16]uint8{}
+	ay.address = 0
+	ay.selected = true
+	ay.port_a.reset()
+	ay.port_b.reset()
+	ay.prescaler = 0
+	ay.tones = [3]ayTone{}
+	ay.noise = 1
+	ay.noise_step = 0
+	ay.restart_envelope()
+}
+
+func (ay *AY38910) Tick(cycles uint64) {
+	for range cycles {
+		ay.prescaler++
+		if ay.prescaler%8 == 0 {
+			ay.step_tones()
+		}
+		if ay.prescaler == 16 {
+			ay.prescaler = 0
+			ay.step()
+		}
+
+		ay.sum += ay.mix()
+		ay.count++
+		ay.phase += ay.rate
+		if ay.phase >= ay.clock {
+			ay.phase -= ay.clock
+			ay.samples = append(ay.samples, int16(ay.sum/float64(ay.count)*32767))
+			ay.sum = 0
+			ay.count = 0
+		}
+	}
+}
+
+// Wakes at every output sample, so the audio keeps flowing when nothing
+// touches the chip through the bus
+func (ay *AY38910) NextEvent() uint64 {
+	return (ay.clock - ay.phase + ay.rate - 1) / ay.rate
+}
+
+func (ay *AY38910) IRQ() bool {
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Generators
+// ----------------------------------------------------------------------------
+
+// A full cycle of the square wave takes 16 master clocks per unit of period
+func (ay *AY38910) step_tones() {
+	for i := range ay.tones {
+		tone := &ay.tones[i]
+		period := uint16(ay.registers[i*2]) | uint16(ay.registers[i*2+1])<<8
+		tone.counter++
+		if tone.counter >= max(period, 1) {
+			tone.counter = 0
+			tone.output = !tone.output
+		}
+	}
+}
+
+func (ay *AY38910) step() {
+	ay.noise_step++
+	if ay.noise_step >= max(uint16(ay.registers[AY_NOISE]), 1) {
+		ay.noise_step = 0
+		bit := (ay.noise ^ ay.noise>>3) & 1
+		ay.noise = ay.noise>>1 | bit<<16
+	}
+
+	ay.env_count++
+	period := uint16(ay.registers[AY_ENV_FINE]) | uint16(ay.registers[AY_ENV_COARSE])<<8
+	if ay.env_count >= max(period, 1) {
+		ay.env_count = 0
+		ay.step_envelope()
+	}
+}
+
+func (ay *AY38910) restart_envelope() {
+	ay.env_count = 0
+	ay.env_hold = false
+	ay.env_up = ay.registers[AY_ENV_SHAPE]&AY_ENV_ATTACK != 0
+	if ay.env_up {
+		ay.envelope = 0
+	} else {
+		ay.envelope = 15
+	}
+}
+
+// Sixteen steps per cycle; at the end of a cycle the shape decides whether
+// to stop, hold, reverse or start again
+func (ay *AY38910) step_envelope() {
+	if ay.env_hold {
+		return
+	}
+	if ay.env_up && ay.envelope < 15 {
+		ay.envelope++
+		return
+	}
+	if !ay.env_up && ay.envelope > 0 {
+		ay.envelope--
+		return
+	}
+
+	shape := ay.registers[AY_ENV_SHAPE]
+	switch {
+	case shape&AY_ENV_CONTINUE == 0:
+		ay.env_hold = true
+		ay.envelope = 0
+	case shape&AY_ENV_HOLD != 0:
+		ay.env_hold = true
+		if shape&AY_ENV_ALTERNATE != 0 {
+			ay.envelope ^= 15
+		}
+	case shape&AY_ENV_ALTERNATE != 0:
+		ay.env_up = !ay.env_up
+	default:
+		ay.envelope ^= 15
+	}
+}
+
+// The three channels summed, 0.0 to 1.0
+func (ay *AY38910) mix() float64 {
+	mixer := ay.registers[AY_MIXER]
+	noise := ay.noise&1 != 0
+	total := 0.0
+	for i, tone := range ay.tones {
+		tone_on := tone.output || mixer&(1<<i) != 0
+		noise_on := noise || mixer&(8<<i) != 0
+		if !tone_on || !noise_on {
+			continue
+		}
+		level := ay.registers[AY_LEVEL_A+i]
+		if level&AY_LEVEL_ENV != 0 {
+			total += ayLevels[ay.envelope]
+		} else {
+			total += ayLevels[level&0x0F]
+		}
+	}
+	return total / 3
+}
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+
+// Addresses with a non-zero high nibble select some other chip
+func (ay *AY38910) latch(data uint8) {
+	ay.address = data & 0x0F
+	ay.selected = data&0xF0 == 0
+}
+
+func (ay *AY38910) read_register() uint8 {
+	if !ay.selected {
+		return 0xFF
+	}
+	switch ay.address {
+	case AY_PORT_A:
+		return ay.port_a.Pins()
+	case AY_PORT_B:
+		return ay.port_b.Pins()
+	}
+	return ay.registers[ay.address]
+}
+
+func (ay *AY38910) write_register(data uint8) {
+	if !ay.selected {
+		return
+	}
+	data &= ayMasks[ay.address]
+	ay.registers[ay.address] = data
+
+	switch ay.address {
+	case AY_MIXER:
+		ay.port_a.set_direction(port_direction(data&AY_MIXER_PORT_A != 0))
+		ay.port_b.set_direction(port_direction(data&AY_MIXER_PORT_B != 0))
+	case AY_ENV_SHAPE:
+		ay.restart_envelope()
+	case AY_PORT_A:
+		ay.port_a.set_output(data)
+	case AY_PORT_B:
+		ay.port_b.set_output(data)
+	}
+}
+
+func port_direction(output bool) uint8 {
+	if output {
+		return 0xFF
+	}
+	return 0x00
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+// For a chip decoded straight onto the bus: writes to offset 0 latch the
+// register address, writes to offset 1 store data, and reads return the
+// selected register.
+// ----------------------------------------------------------------------------
+
+func (ay *AY38910) Read(addr uint16) uint8 {
+	return ay.read_register()
+}
+
+func (ay *AY38910) Write(addr uint16, data uint8) {
+	if addr&1 == 0 {
+		ay.latch(data)
+	} else {
+		ay.write_register(data)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Port Wiring
+// ----------------------------------------------------------------------------
+// The usual hookup puts DA0-DA7 on one port and BDIR and BC1 on two pins of
+// the other, with BC2 tied high. The bus mode follows the control pins:
+// BDIR and BC1 both high latch an address, BDIR alone writes and BC1 alone
+// reads, with the chip driving the data port.
+// ----------------------------------------------------------------------------
+
+type AY38910Wiring struct {
+	Data    *Port
+	Control *Port
+	BDIR    uint8
+	BC1     uint8
+}
+
+const (
+	AY_MODE_INACTIVE = 0b00
+	AY_MODE_READ     = 0b01
+	AY_MODE_WRITE    = 0b10
+	AY_MODE_LATCH    = 0b11
+)
+
+type ayData struct {
+	ay *AY38910
+}
+
+type ayControl struct {
+	ay *AY38910
+}
+
+func (ay *AY38910) Connect(wiring AY38910Wiring) {
+	ay.wiring = wiring
+	wiring.Data.Connect(&ayData{ay: ay})
+	wiring.Control.Connect(&ayControl{ay: ay})
+}
+
+func (d *ayData) PortOutput(pins uint8) {
+	d.ay.bus_cycle(pins)
+}
+
+func (d *ayData) PortInput() uint8 {
+	if d.ay.mode != AY_MODE_READ {
+		return 0xFF
+	}
+	return d.ay.read_register()
+}
+
+func (c *ayControl) PortOutput(pins uint8) {
+	ay := c.ay
+	mode := uint8(0)
+	if pins&ay.wiring.BDIR != 0 {
+		mode |= AY_MODE_WRITE
+	}
+	if pins&ay.wiring.BC1 != 0 {
+		mode |= AY_MODE_READ
+	}
+	ay.mode = mode
+	ay.bus_cycle(ay.wiring.Data.driven())
+}
+
+func (c *ayControl) PortInput() uint8 {
+	return 0xFF
+}
+
+func (ay *AY38910) bus_cycle(data uint8) {
+	switch ay.mode {
+	case AY_MODE_LATCH:
+		ay.latch(data)
+	case AY_MODE_WRITE:
+		ay.write_register(data)
+	}
+}
@@ -0,0 +1,131 @@
+package cpu6502
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// device_ay_test.go
+// Tests the AY-3-8910 against a golden WAV rendering
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Golden Files
+// ----------------------------------------------------------------------------
+// Rendered output is compared byte for byte with files in testdata. Run the
+// tests with -update to rewrite them after a deliberate change, and listen
+// to or look at the result before committing it.
+// ----------------------------------------------------------------------------
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func checkGolden(t *testing.T, name string, output []uint8) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, golden) {
+		t.Errorf("output differs from %s; rerun with -update if intended", path)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+// A square wave on A, noise on B and an enveloped tone on C, 50ms at 8kHz
+func TestAY38910GoldenWAV(t *testing.T) {
+
+	ay := NewAY38910(1000000, 8000)
+	registers := []struct {
+		register uint8
+		value    uint8
+	}{
+		{AY_TONE_A_FINE, 50},
+		{AY_TONE_C_FINE, 200},
+		{AY_NOISE, 8},
+		{AY_MIXER, 0b00101010}, // tone on A and C, noise on B
+		{AY_LEVEL_A, 15},
+		{AY_LEVEL_B, 10},
+		{AY_LEVEL_C, AY_LEVEL_ENV},
+		{AY_ENV_FINE, 20},
+		{AY_ENV_SHAPE, AY_ENV_CONTINUE | AY_ENV_ATTACK | AY_ENV_ALTERNATE},
+	}
+	for _, r := range registers {
+		ay.Write(0, r.register)
+		ay.Write(1, r.value)
+	}
+	ay.Tick(50000)
+
+	samples := ay.Samples()
+	if len(samples) != 400 {
+		t.Fatalf("rendered %d samples", len(samples))
+	}
+	var wav bytes.Buffer
+	if err := WriteWAV(&wav, uint32(ay.SampleRate()), samples); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "ay38910.wav", wav.Bytes())
+
+}
+
+// A tone period of 50 at 1MHz is 1250Hz: a full cycle every 16*50 clocks
+func TestAY38910TonePitch(t *testing.T) {
+
+	ay := NewAY38910(1000000, 1000000)
+	ay.Write(0, AY_TONE_A_FINE)
+	ay.Write(1, 50)
+	ay.Write(0, AY_MIXER)
+	ay.Write(1, 0b00111110) // tone on A only
+	ay.Write(0, AY_LEVEL_A)
+	ay.Write(1, 15)
+	ay.Tick(8000)
+
+	var rising []int
+	samples := ay.Samples()
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] == 0 && samples[i] != 0 {
+			rising = append(rising, i)
+		}
+	}
+	if len(rising) < 9 {
+		t.Fatalf("%d rising edges in %d samples", len(rising), len(samples))
+	}
+	for i := 1; i < len(rising); i++ {
+		if period := rising[i] - rising[i-1]; period != 16*50 {
+			t.Errorf("cycle of %d clocks, expected %d", period, 16*50)
+		}
+	}
+
+}
@@ -0,0 +1,410 @@
+package cpu6502
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// device_block.go
+// CompactFlash / IDE style block storage backed by a disk image
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+const SECTOR_SIZE = 512
+
+// ----------------------------------------------------------------------------
+// Disk Images
+// ----------------------------------------------------------------------------
+// Storage is addressed in whole sectors. DiskImage keeps a host file as the
+// backing store; with copy-on-write the file is opened read-only and sectors
+// that are written live in memory, so every run starts from the same image.
+// ----------------------------------------------------------------------------
+
+type BlockStorage interface {
+	Sectors() uint32
+	ReadSector(lba uint32, buffer []uint8) error
+	WriteSector(lba uint32, buffer []uint8) error
+}
+
+type DiskImage struct {
+	file    *os.File
+	sectors uint32
+	overlay map[uint32][]uint8 // nil unless copy-on-write
+}
+
+func OpenDiskImage(path string, copy_on_write bool) (*DiskImage, error) {
+	flag := os.O_RDWR
+	if copy_on_write {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	d := &DiskImage{file: file, sectors: uint32(info.Size() / SECTOR_SIZE)}
+	if copy_on_write {
+		d.overlay = make(map[uint32][]uint8)
+	}
+	return d, nil
+}
+
+func (d *DiskImage) Close() error {
+	return d.file.Close()
+}
+
+func (d *DiskImage) Sectors() uint32 {
+	return d.sectors
+}
+
+// Sectors written since the image was opened, when copy-on-write
+func (d *DiskImage) Modified() int {
+	return len(d.overlay)
+}
+
+// Throws away everything written to the overlay
+func (d *DiskImage) Discard() {
+	if d.overlay != nil {
+		clear(d.overlay)
+	}
+}
+
+func (d *DiskImage) ReadSector(lba uint32, buffer []uint8) error {
+	if lba >= d.sectors {
+		return fmt.Errorf("sector %d beyond end of image", lba)
+	}
+	if sector, ok := d.overlay[lba]; ok {
+		copy(buffer, sector)
+		return nil
+	}
+	_, err := d.file.ReadAt(buffer[:SECTOR_SIZE], int64(lba)*SECTOR_SIZE)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return err
+}
+
+func (d *DiskImage) WriteSector(lba uint32, buffer []uint8) error {
+	if lba >= d.sectors {
+		return fmt.Errorf("sector %d beyond end of image", lba)
+	}
+	if d.overlay != nil {
+		d.overlay[lba] = append([]uint8(nil), buffer[:SECTOR_SIZE]...)
+		return nil
+	}
+	_, err := d.file.WriteAt(buffer[:SECTOR_SIZE], int64(lba)*SECTOR_SIZE)
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+// The ATA task file in 8-bit mode, as wired on most CF card adapters: eight
+// consecutive registers decoded from A0-A2.
+// ----------------------------------------------------------------------------
+
+const (
+	BLOCK_DATA    = 0x0
+	BLOCK_ERROR   = 0x1 // features when written
+	BLOCK_COUNT   = 0x2
+	BLOCK_LBA0    = 0x3
+	BLOCK_LBA1    = 0x4
+	BLOCK_LBA2    = 0x5
+	BLOCK_LBA3    = 0x6 // drive/head, LBA 27-24 in the low nibble
+	BLOCK_STATUS  = 0x7 // command when written
+	BLOCK_LBA_BIT = 0b01000000
+)
+
+// ----------------------------------------------------------------------------
+// Status, error and commands
+
+const (
+	BLOCK_STATUS_ERR  = 0b00000001
+	BLOCK_STATUS_DRQ  = 0b00001000
+	BLOCK_STATUS_DSC  = 0b00010000
+	BLOCK_STATUS_DRDY = 0b01000000
+	BLOCK_STATUS_BSY  = 0b10000000
+	BLOCK_ERROR_ABRT  = 0b00000100
+	BLOCK_ERROR_IDNF  = 0b00010000
+
+	BLOCK_CMD_READ     = 0x20
+	BLOCK_CMD_WRITE    = 0x30
+	BLOCK_CMD_IDENTIFY = 0xEC
+	BLOCK_CMD_FEATURES = 0xEF
+	BLOCK_CMD_FLUSH    = 0xE7
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type BlockDevice struct {
+	storage    BlockStorage
+	features   uint8
+	error_code uint8
+	count      uint8
+	lba        [4]uint8
+	status     uint8
+	command    uint8
+	buffer     [SECTOR_SIZE]uint8
+	index      int
+	current    uint32 // sector being transferred
+	left       int    // sectors left in the command, including the current one
+	err        error
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewBlockDevice(storage BlockStorage) *BlockDevice {
+	b := &BlockDevice{storage: storage}
+	b.Reset()
+	return b
+}
+
+// The first error from the backing storage
+func (b *BlockDevice) Err() error {
+	return b.err
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+// Commands complete instantly, so the device never reports busy and has no
+// events of its own.
+// ----------------------------------------------------------------------------
+
+func (b *BlockDevice) Reset() {
+	b.features = 0
+	b.error_code = 0
+	b.count = 1
+	b.lba = [4]uint8{1, 0, 0, BLOCK_LBA_BIT}
+	b.status = BLOCK_STATUS_DRDY | BLOCK_STATUS_DSC
+	b.command = 0
+	b.index = 0
+	b.left = 0
+}
+
+func (b *BlockDevice) Tick(cycles uint64) {
+}
+
+func (b *BlockDevice) NextEvent() uint64 {
+	return NO_EVENT
+}
+
+func (b *BlockDevice) IRQ() bool {
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Commands
+// ----------------------------------------------------------------------------
+
+func (b *BlockDevice) address() uint32 {
+	return uint32(b.lba[3]&0x0F)<<24 | uint32(b.lba[2])<<16 |
+		uint32(b.lba[1])<<8 | uint32(b.lba[0])
+}
+
+func (b *BlockDevice) set_address(lba uint32) {
+	b.lba[0] = uint8(lba)
+	b.lba[1] = uint8(lba >> 8)
+	b.lba[2] = uint8(lba >> 16)
+	b.lba[3] = b.lba[3]&0xF0 | uint8(lba>>24)&0x0F
+}
+
+func (b *BlockDevice) abort(code uint8) {
+	b.error_code = code
+	b.status = b.status&^(BLOCK_STATUS_DRQ|BLOCK_STATUS_BSY) | BLOCK_STATUS_ERR
+	b.left = 0
+}
+
+func (b *BlockDevice) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+	b.abort(BLOCK_ERROR_ABRT)
+}
+
+func (b *BlockDevice) execute(command uint8) {
+	b.command = command
+	b.error_code = 0
+	b.status &^= BLOCK_STATUS_ERR | BLOCK_STATUS_DRQ
+	b.index = 0
+
+	switch command {
+	case BLOCK_CMD_READ, BLOCK_CMD_WRITE:
+		if b.lba[3]&BLOCK_LBA_BIT == 0 {
+			b.abort(BLOCK_ERROR_ABRT) // CHS addressing is not supported
+			return
+		}
+		b.left = int(b.count)
+		if b.left == 0 {
+			b.left = 256
+		}
+		b.current = b.address()
+		if b.current+uint32(b.left) > b.storage.Sectors() {
+			b.abort(BLOCK_ERROR_IDNF)
+			return
+		}
+		if command == BLOCK_CMD_READ {
+			b.load()
+		} else {
+			b.status |= BLOCK_STATUS_DRQ
+		}
+	case BLOCK_CMD_IDENTIFY:
+		b.identify()
+		b.left = 1
+		b.status |= BLOCK_STATUS_DRQ
+	case BLOCK_CMD_FEATURES, BLOCK_CMD_FLUSH:
+		// Accepted; 8-bit transfers are the only mode
+	default:
+		b.abort(BLOCK_ERROR_ABRT)
+	}
+}
+
+func (b *BlockDevice) load() {
+	if err := b.storage.ReadSector(b.current, b.buffer[:]); err != nil {
+		b.fail(err)
+		return
+	}
+	b.index = 0
+	b.status |= BLOCK_STATUS_DRQ
+}
+
+// The data register has moved a whole sector
+func (b *BlockDevice) sector_done() {
+	if b.command == BLOCK_CMD_WRITE {
+		if err := b.storage.WriteSector(b.current, b.buffer[:]); err != nil {
+			b.fail(err)
+			return
+		}
+	}
+
+	b.left--
+	if b.command != BLOCK_CMD_IDENTIFY {
+		b.count--
+		b.current++
+		b.set_address(b.current)
+	}
+	b.index = 0
+	if b.left == 0 {
+		b.status &^= BLOCK_STATUS_DRQ
+		return
+	}
+	if b.command == BLOCK_CMD_READ {
+		b.load()
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Identify
+// ----------------------------------------------------------------------------
+// Enough of the IDENTIFY DEVICE block for drivers to find the capacity and
+// print a model name. Words are little-endian; strings are byte-swapped.
+// ----------------------------------------------------------------------------
+
+func (b *BlockDevice) identify() {
+	clear(b.buffer[:])
+	word := func(index int, value uint16) {
+		b.buffer[index*2] = uint8(value)
+		b.buffer[index*2+1] = uint8(value >> 8)
+	}
+	text := func(index int, words int, value string) {
+		for i := range words * 2 {
+			c := uint8(' ')
+			if i < len(value) {
+				c = value[i]
+			}
+			b.buffer[index*2+i^1] = c
+		}
+	}
+
+	sectors := b.storage.Sectors()
+	word(0, 0x848A) // CompactFlash signature
+	word(1, uint16(min(sectors/(16*63), 16383)))
+	word(3, 16)
+	word(6, 63)
+	text(10, 10, "CPU6502")
+	text(23, 4, "1.0")
+	text(27, 20, "CPU6502 BLOCK DEVICE")
+	word(49, 0x0200) // LBA supported
+	word(60, uint16(sectors))
+	word(61, uint16(sectors>>16))
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (b *BlockDevice) Read(addr uint16) uint8 {
+	switch addr & 0x07 {
+	case BLOCK_DATA:
+		if b.status&BLOCK_STATUS_DRQ == 0 || b.command == BLOCK_CMD_WRITE {
+			return 0xFF
+		}
+		data := b.buffer[b.index]
+		b.index++
+		if b.index == SECTOR_SIZE {
+			b.sector_done()
+		}
+		return data
+	case BLOCK_ERROR:
+		return b.error_code
+	case BLOCK_COUNT:
+		return b.count
+	case BLOCK_LBA0, BLOCK_LBA1, BLOCK_LBA2, BLOCK_LBA3:
+		return b.lba[addr&0x07-BLOCK_LBA0]
+	default: // BLOCK_STATUS
+		return b.status
+	}
+}
+
+func (b *BlockDevice) Write(addr uint16, data uint8) {
+	switch addr & 0x07 {
+	case BLOCK_DATA:
+		if b.status&BLOCK_STATUS_DRQ == 0 || b.command != BLOCK_CMD_WRITE {
+			return
+		}
+		b.buffer[b.index] = data
+		b.index++
+		if b.index == SECTOR_SIZE {
+			b.sector_done()
+		}
+	case BLOCK_ERROR:
+		b.features = data
+	case BLOCK_COUNT:
+		b.count = data
+	case BLOCK_LBA0, BLOCK_LBA1, BLOCK_LBA2, BLOCK_LBA3:
+		b.lba[addr&0x07-BLOCK_LBA0] = data
+	default: // BLOCK_STATUS
+		b.execute(data)
+	}
+}
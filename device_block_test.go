@@ -0,0 +1,97 @@
+package cpu6502
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// device_block_test.go
+// Tests the block storage device
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+func TestBlockDeviceCopyOnWrite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "disk.img")
+	image := make([]uint8, 4*SECTOR_SIZE)
+	image[2*SECTOR_SIZE] = 0x42
+	if err := os.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := OpenDiskImage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	block := NewBlockDevice(disk)
+
+	command := func(lba uint8, count uint8, cmd uint8) {
+		block.Write(BLOCK_COUNT, count)
+		block.Write(BLOCK_LBA0, lba)
+		block.Write(BLOCK_LBA3, 0xE0)
+		block.Write(BLOCK_STATUS, cmd)
+	}
+
+	command(2, 1, BLOCK_CMD_READ)
+	if block.Read(BLOCK_STATUS)&BLOCK_STATUS_DRQ == 0 {
+		t.Fatalf("no data request after READ SECTORS")
+	}
+	if data := block.Read(BLOCK_DATA); data != 0x42 {
+		t.Errorf("first byte = $%02X, want $42", data)
+	}
+
+	command(1, 2, BLOCK_CMD_WRITE)
+	for i := range 2 * SECTOR_SIZE {
+		block.Write(BLOCK_DATA, uint8(i))
+	}
+	if block.Read(BLOCK_STATUS)&(BLOCK_STATUS_DRQ|BLOCK_STATUS_ERR) != 0 {
+		t.Errorf("status = $%02X after writing two sectors", block.Read(BLOCK_STATUS))
+	}
+	if disk.Modified() != 2 {
+		t.Errorf("modified = %d, want 2", disk.Modified())
+	}
+
+	command(1, 1, BLOCK_CMD_READ)
+	block.Read(BLOCK_DATA)
+	if data := block.Read(BLOCK_DATA); data != 1 {
+		t.Errorf("read back $%02X, want $01", data)
+	}
+
+	// The image itself is untouched
+	contents, _ := os.ReadFile(path)
+	if contents[SECTOR_SIZE+1] != 0 {
+		t.Errorf("image written through the overlay")
+	}
+
+	command(3, 2, BLOCK_CMD_READ)
+	if block.Read(BLOCK_ERROR) != BLOCK_ERROR_IDNF {
+		t.Errorf("error = $%02X reading past the end", block.Read(BLOCK_ERROR))
+	}
+
+}
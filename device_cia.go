@@ -0,0 +1,322 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_cia.go
+// MOS 6526 Complex Interface Adapter
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+// The two ports and both interval timers are emulated. The time of day clock
+// and serial register are storage only: TOD does not run and the serial
+// port never shifts. Timers count the system clock, or for timer B the
+// underflows of timer A; counting CNT pulses is not supported.
+// ----------------------------------------------------------------------------
+
+const (
+	CIA_PRA    = 0x0
+	CIA_PRB    = 0x1
+	CIA_DDRA   = 0x2
+	CIA_DDRB   = 0x3
+	CIA_TA_LO  = 0x4
+	CIA_TA_HI  = 0x5
+	CIA_TB_LO  = 0x6
+	CIA_TB_HI  = 0x7
+	CIA_TOD_10 = 0x8
+	CIA_TOD_S  = 0x9
+	CIA_TOD_M  = 0xA
+	CIA_TOD_H  = 0xB
+	CIA_SDR    = 0xC
+	CIA_ICR    = 0xD
+	CIA_CRA    = 0xE
+	CIA_CRB    = 0xF
+)
+
+// ----------------------------------------------------------------------------
+// Interrupt control register
+
+const (
+	CIA_ICR_TA   = 0b00000001
+	CIA_ICR_TB   = 0b00000010
+	CIA_ICR_TOD  = 0b00000100
+	CIA_ICR_SDR  = 0b00001000
+	CIA_ICR_FLAG = 0b00010000
+	CIA_ICR_IRQ  = 0b10000000 // set on read when an enabled flag is set
+	CIA_ICR_SET  = 0b10000000 // on write, set rather than clear mask bits
+)
+
+// ----------------------------------------------------------------------------
+// Control registers
+
+const (
+	CIA_CR_START   = 0b00000001
+	CIA_CR_RUNMODE = 0b00001000 // one-shot
+	CIA_CR_LOAD    = 0b00010000 // strobe, force load from the latch
+	CIA_CR_INMODE  = 0b00100000 // timer A counts CNT
+	CIA_CRB_INMODE = 0b01100000 // timer B input: system clock, CNT, timer A
+	CIA_CRB_TA     = 0b01000000 // timer B counts timer A underflows
+)
+
+// ----------------------------------------------------------------------------
+// Interval Timer
+// ----------------------------------------------------------------------------
+// Counts down from the latch, underflowing latch+1 cycles after it starts,
+// then reloads. In one-shot mode it stops at the underflow. Writing the high
+// byte of the latch loads a stopped timer, and starts it in one-shot mode.
+// ----------------------------------------------------------------------------
+
+type ciaTimer struct {
+	latch   uint16
+	counter uint16
+	control uint8
+}
+
+func (t *ciaTimer) running() bool {
+	return t.control&CIA_CR_START != 0
+}
+
+func (t *ciaTimer) write_low(data uint8) {
+	t.latch = t.latch&0xFF00 | uint16(data)
+}
+
+func (t *ciaTimer) write_high(data uint8) {
+	t.latch = t.latch&0x00FF | uint16(data)<<8
+	if !t.running() {
+		t.counter = t.latch
+		if t.control&CIA_CR_RUNMODE != 0 {
+			t.control |= CIA_CR_START
+		}
+	}
+}
+
+func (t *ciaTimer) write_control(data uint8) {
+	if data&CIA_CR_LOAD != 0 {
+		t.counter = t.latch
+	}
+	t.control = data &^ CIA_CR_LOAD
+}
+
+// Counts n pulses and returns the number of underflows
+func (t *ciaTimer) advance(n uint64) uint64 {
+	if !t.running() || n == 0 {
+		return 0
+	}
+	if n <= uint64(t.counter) {
+		t.counter -= uint16(n)
+		return 0
+	}
+	n -= uint64(t.counter) + 1
+	t.counter = t.latch
+	if t.control&CIA_CR_RUNMODE != 0 {
+		t.control &^= CIA_CR_START
+		return 1
+	}
+	period := uint64(t.latch) + 1
+	t.counter -= uint16(n % period)
+	return 1 + n/period
+}
+
+// Pulses until the next underflow
+func (t *ciaTimer) next_event() uint64 {
+	if !t.running() {
+		return NO_EVENT
+	}
+	return uint64(t.counter) + 1
+}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type CIA struct {
+	port_a  Port
+	port_b  Port
+	timer_a ciaTimer
+	timer_b ciaTimer
+	tod     [4]uint8
+	sdr     uint8
+	flags   uint8
+	mask    uint8
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewCIA() *CIA {
+	c := &CIA{}
+	c.Reset()
+	return c
+}
+
+func (c *CIA) PortA() *Port {
+	return &c.port_a
+}
+
+func (c *CIA) PortB() *Port {
+	return &c.port_b
+}
+
+// A falling edge on the FLAG input, from the serial bus or cassette
+func (c *CIA) Flag() {
+	c.flags |= CIA_ICR_FLAG
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (c *CIA) Reset() {
+	c.port_a.reset()
+	c.port_b.reset()
+	c.timer_a = ciaTimer{latch: 0xFFFF, counter: 0xFFFF}
+	c.timer_b = ciaTimer{latch: 0xFFFF, counter: 0xFFFF}
+	c.tod = [4]uint8{}
+	c.sdr = 0
+	c.flags = 0
+	c.mask = 0
+}
+
+func (c *CIA) Tick(cycles uint64) {
+	var underflows uint64
+	if c.timer_a.control&CIA_CR_INMODE == 0 {
+		underflows = c.timer_a.advance(cycles)
+	}
+	if underflows > 0 {
+		c.flags |= CIA_ICR_TA
+	}
+
+	var pulses uint64
+	switch c.timer_b.control & CIA_CRB_INMODE {
+	case 0:
+		pulses = cycles
+	case CIA_CRB_TA, CIA_CRB_INMODE:
+		pulses = underflows
+	}
+	if c.timer_b.advance(pulses) > 0 {
+		c.flags |= CIA_ICR_TB
+	}
+}
+
+func (c *CIA) NextEvent() uint64 {
+	next := uint64(NO_EVENT)
+	if c.timer_a.control&CIA_CR_INMODE == 0 {
+		next = c.timer_a.next_event()
+	}
+	switch c.timer_b.control & CIA_CRB_INMODE {
+	case 0:
+		next = min(next, c.timer_b.next_event())
+	case CIA_CRB_TA, CIA_CRB_INMODE:
+		// Timer B underflows with the timer A underflow that takes it
+		// through zero
+		a, b := next, c.timer_b.next_event()
+		switch {
+		case a == NO_EVENT || b == NO_EVENT:
+		case b == 1:
+			next = a
+		case c.timer_a.control&CIA_CR_RUNMODE == 0:
+			next = a + (b-1)*(uint64(c.timer_a.latch)+1)
+		}
+	}
+	return next
+}
+
+func (c *CIA) IRQ() bool {
+	return c.flags&c.mask != 0
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (c *CIA) Read(addr uint16) uint8 {
+	switch addr & 0x0F {
+	case CIA_PRA:
+		return c.port_a.Pins()
+	case CIA_PRB:
+		return c.port_b.Pins()
+	case CIA_DDRA:
+		return c.port_a.direction
+	case CIA_DDRB:
+		return c.port_b.direction
+	case CIA_TA_LO:
+		return uint8(c.timer_a.counter)
+	case CIA_TA_HI:
+		return uint8(c.timer_a.counter >> 8)
+	case CIA_TB_LO:
+		return uint8(c.timer_b.counter)
+	case CIA_TB_HI:
+		return uint8(c.timer_b.counter >> 8)
+	case CIA_TOD_10, CIA_TOD_S, CIA_TOD_M, CIA_TOD_H:
+		return c.tod[addr&0x03]
+	case CIA_SDR:
+		return c.sdr
+	case CIA_ICR:
+		icr := c.flags
+		if c.IRQ() {
+			icr |= CIA_ICR_IRQ
+		}
+		c.flags = 0
+		return icr
+	case CIA_CRA:
+		return c.timer_a.control
+	default: // CIA_CRB
+		return c.timer_b.control
+	}
+}
+
+func (c *CIA) Write(addr uint16, data uint8) {
+	switch addr & 0x0F {
+	case CIA_PRA:
+		c.port_a.set_output(data)
+	case CIA_PRB:
+		c.port_b.set_output(data)
+	case CIA_DDRA:
+		c.port_a.set_direction(data)
+	case CIA_DDRB:
+		c.port_b.set_direction(data)
+	case CIA_TA_LO:
+		c.timer_a.write_low(data)
+	case CIA_TA_HI:
+		c.timer_a.write_high(data)
+	case CIA_TB_LO:
+		c.timer_b.write_low(data)
+	case CIA_TB_HI:
+		c.timer_b.write_high(data)
+	case CIA_TOD_10, CIA_TOD_S, CIA_TOD_M, CIA_TOD_H:
+		c.tod[addr&0x03] = data
+	case CIA_SDR:
+		c.sdr = data
+	case CIA_ICR:
+		if data&CIA_ICR_SET != 0 {
+			c.mask |= data & 0x1F
+		} else {
+			c.mask &^= data & 0x1F
+		}
+	case CIA_CRA:
+		c.timer_a.write_control(data)
+	default: // CIA_CRB
+		c.timer_b.write_control(data)
+	}
+}
@@ -0,0 +1,222 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_cia_test.go
+// Tests the 6526 CIA timers and interrupt control
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Timers
+// ----------------------------------------------------------------------------
+
+func TestCIAOneShotStartsOnHighLatch(t *testing.T) {
+
+	cia := NewCIA()
+	cia.Write(CIA_CRA, CIA_CR_RUNMODE)
+	cia.Write(CIA_TA_LO, 9)
+	if cia.Read(CIA_CRA)&CIA_CR_START != 0 {
+		t.Errorf("started by the low byte")
+	}
+	cia.Write(CIA_TA_HI, 0)
+	if cia.Read(CIA_CRA)&CIA_CR_START == 0 || cia.Read(CIA_TA_LO) != 9 {
+		t.Fatalf("high byte did not load and start the timer")
+	}
+
+	if next := cia.NextEvent(); next != 10 {
+		t.Errorf("expected next event in 10 cycles, got %d", next)
+	}
+	cia.Tick(9)
+	if cia.Read(CIA_ICR) != 0 {
+		t.Errorf("timer fired early")
+	}
+	cia.Tick(1)
+	if icr := cia.Read(CIA_ICR); icr != CIA_ICR_TA {
+		t.Errorf("ICR read %02X at the underflow", icr)
+	}
+
+	// Stopped and reloaded, and the high byte starts it again
+	if cia.Read(CIA_CRA)&CIA_CR_START != 0 || cia.Read(CIA_TA_LO) != 9 {
+		t.Errorf("one-shot timer still running")
+	}
+	if next := cia.NextEvent(); next != NO_EVENT {
+		t.Errorf("stopped timer has an event in %d cycles", next)
+	}
+	cia.Write(CIA_TA_HI, 0)
+	cia.Tick(10)
+	if cia.Read(CIA_ICR) != CIA_ICR_TA {
+		t.Errorf("one-shot timer did not restart")
+	}
+
+	// A running continuous timer only takes the new latch at the underflow
+	cia.Write(CIA_CRB, CIA_CR_START|CIA_CR_LOAD)
+	cia.Write(CIA_TB_LO, 4)
+	cia.Write(CIA_TB_HI, 0)
+	if counter := uint16(cia.Read(CIA_TB_HI))<<8 | uint16(cia.Read(CIA_TB_LO)); counter != 0xFFFF {
+		t.Errorf("running timer reloaded to $%04X", counter)
+	}
+	cia.Tick(0x10000)
+	if cia.Read(CIA_ICR) != CIA_ICR_TB || cia.Read(CIA_TB_LO) != 4 {
+		t.Errorf("latch not taken at the underflow")
+	}
+	cia.Tick(5 * 3)
+	if cia.Read(CIA_ICR) != CIA_ICR_TB || cia.Read(CIA_TB_LO) != 4 {
+		t.Errorf("continuous timer stopped")
+	}
+
+}
+
+func TestCIATimerBCountsTimerA(t *testing.T) {
+
+	cia := NewCIA()
+	cia.Write(CIA_TA_LO, 4)
+	cia.Write(CIA_TA_HI, 0)
+	cia.Write(CIA_TB_LO, 2)
+	cia.Write(CIA_TB_HI, 0)
+	cia.Write(CIA_CRB, CIA_CR_START|CIA_CRB_TA)
+
+	// Timer A is stopped, so timer B never gets a pulse
+	if next := cia.NextEvent(); next != NO_EVENT {
+		t.Errorf("event in %d cycles with timer A stopped", next)
+	}
+	cia.Write(CIA_CRA, CIA_CR_START)
+
+	// Timer A underflows every 5 cycles, and timer B on the third of them
+	if next := cia.NextEvent(); next != 15 {
+		t.Errorf("expected next event in 15 cycles, got %d", next)
+	}
+	cia.Tick(14)
+	if icr := cia.Read(CIA_ICR); icr != CIA_ICR_TA {
+		t.Errorf("ICR read %02X before timer B ran out", icr)
+	}
+	if counter := cia.Read(CIA_TB_LO); counter != 0 {
+		t.Errorf("timer B at %d after two timer A underflows", counter)
+	}
+	if next := cia.NextEvent(); next != 1 {
+		t.Errorf("expected next event in 1 cycle, got %d", next)
+	}
+	cia.Tick(1)
+	if icr := cia.Read(CIA_ICR); icr != CIA_ICR_TA|CIA_ICR_TB {
+		t.Errorf("ICR read %02X at the underflow", icr)
+	}
+
+	// Many periods in one tick
+	cia.Tick(15 * 4)
+	if icr := cia.Read(CIA_ICR); icr != CIA_ICR_TA|CIA_ICR_TB || cia.Read(CIA_TB_LO) != 2 {
+		t.Errorf("ICR read %02X, timer B at %d", icr, cia.Read(CIA_TB_LO))
+	}
+
+	// With timer A in one-shot mode, timer B waits on it alone
+	cia.Write(CIA_CRA, CIA_CR_START|CIA_CR_RUNMODE)
+	if next := cia.NextEvent(); next != 5 {
+		t.Errorf("expected next event in 5 cycles, got %d", next)
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Interrupts and Registers
+// ----------------------------------------------------------------------------
+
+func TestCIAInterruptControl(t *testing.T) {
+
+	cia := NewCIA()
+	cia.Flag()
+	if cia.IRQ() {
+		t.Errorf("interrupt with the source masked")
+	}
+	if icr := cia.Read(CIA_ICR); icr != CIA_ICR_FLAG {
+		t.Errorf("ICR read %02X", icr)
+	}
+	if icr := cia.Read(CIA_ICR); icr != 0 {
+		t.Errorf("reading did not clear the flags: %02X", icr)
+	}
+
+	// Bit 7 set sets the mask bits written, clear clears them
+	cia.Write(CIA_ICR, CIA_ICR_SET|CIA_ICR_FLAG|CIA_ICR_TA)
+	cia.Flag()
+	if !cia.IRQ() {
+		t.Fatalf("no interrupt with the source enabled")
+	}
+	if icr := cia.Read(CIA_ICR); icr != CIA_ICR_IRQ|CIA_ICR_FLAG {
+		t.Errorf("ICR read %02X", icr)
+	}
+	if cia.IRQ() {
+		t.Errorf("reading ICR did not acknowledge")
+	}
+	cia.Write(CIA_ICR, CIA_ICR_FLAG)
+	cia.Flag()
+	if cia.IRQ() {
+		t.Errorf("interrupt after clearing the mask bit")
+	}
+	cia.Write(CIA_TA_LO, 0)
+	cia.Write(CIA_TA_HI, 0)
+	cia.Write(CIA_CRA, CIA_CR_START)
+	cia.Tick(1)
+	if !cia.IRQ() {
+		t.Errorf("timer A no longer enabled")
+	}
+
+}
+
+func TestCIARegisters(t *testing.T) {
+
+	cia := NewCIA()
+	device := &portRecorder{input: 0x0F}
+	cia.PortB().Connect(device)
+	cia.Write(CIA_DDRB, 0xF0)
+	cia.Write(CIA_PRB, 0xA5)
+	if pins := cia.Read(CIA_PRB); pins != 0xAF {
+		t.Errorf("port B pins read %02X", pins)
+	}
+	if n := len(device.outputs); n == 0 || device.outputs[n-1]&0xF0 != 0xA0 {
+		t.Errorf("port B drove %02X", device.outputs)
+	}
+
+	// The time of day clock and serial register only store
+	writes := map[uint16]uint8{
+		CIA_DDRA: 0x3F, CIA_TOD_10: 0x01, CIA_TOD_S: 0x02, CIA_TOD_M: 0x03,
+		CIA_TOD_H: 0x04, CIA_SDR: 0x55, CIA_CRA: CIA_CR_RUNMODE,
+		CIA_DDRB: 0xF0, CIA_CRB: CIA_CRB_TA,
+	}
+	for addr, data := range writes {
+		cia.Write(0xDC00+addr, data)
+	}
+	for addr, data := range writes {
+		if got := cia.Read(0xDC00 + addr); got != data {
+			t.Errorf("register %X read %02X, expected %02X", addr, got, data)
+		}
+	}
+
+	cia.Write(CIA_PRA, 0x15)
+	if pins := cia.Read(CIA_PRA); pins&0x3F != 0x15 {
+		t.Errorf("port A pins read %02X", pins)
+	}
+
+	cia.Reset()
+	if cia.Read(CIA_TA_LO) != 0xFF || cia.Read(CIA_TB_HI) != 0xFF || cia.Read(CIA_SDR) != 0 {
+		t.Errorf("reset did not restore the registers")
+	}
+
+}
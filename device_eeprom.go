@@ -0,0 +1,282 @@
+package cpu6502
+
+import "fmt"
+
+// ----------------------------------------------------------------------------
+// device_eeprom.go
+// 28C256 style parallel EEPROM with page writes and data protection
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Write Cycle
+// ----------------------------------------------------------------------------
+// Writes load a 64 byte page buffer. Each write must follow the last within
+// the load window; when the window closes the page is programmed, which takes
+// the write time. While programming, reads return the complement of bit 7 of
+// the last byte written (DATA polling) with bit 6 toggling on every read, and
+// writes are ignored.
+//
+// The JEDEC protection sequences are written to $5555 and $2AAA, taken
+// modulo the size of the part. Once protection is on, only page loads that
+// follow the three byte unlock sequence are programmed.
+// ----------------------------------------------------------------------------
+
+const (
+	EEPROM_PAGE_SIZE = 64
+	EEPROM_ADDRESS_1 = 0x5555
+	EEPROM_ADDRESS_2 = 0x2AAA
+	EEPROM_POLL_BIT  = 0b10000000
+	EEPROM_TOGGLE    = 0b01000000
+)
+
+type eepromWrite struct {
+	addr uint16
+	data uint8
+}
+
+var (
+	eepromUnlock  = []eepromWrite{{EEPROM_ADDRESS_1, 0xAA}, {EEPROM_ADDRESS_2, 0x55}, {EEPROM_ADDRESS_1, 0xA0}}
+	eepromDisable = []eepromWrite{
+		{EEPROM_ADDRESS_1, 0xAA}, {EEPROM_ADDRESS_2, 0x55}, {EEPROM_ADDRESS_1, 0x80},
+		{EEPROM_ADDRESS_1, 0xAA}, {EEPROM_ADDRESS_2, 0x55}, {EEPROM_ADDRESS_1, 0x20},
+	}
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type EEPROM struct {
+	data          []uint8
+	write_cycles  uint64
+	window_cycles uint64
+	page          uint16 // page address of the buffer
+	buffer        [EEPROM_PAGE_SIZE]uint8
+	loaded        [EEPROM_PAGE_SIZE]bool
+	loading       bool
+	holding       bool // writes held back as a possible command sequence
+	programming   bool
+	timer         uint64 // cycles left in the window or the write
+	last          uint8
+	toggle        uint8
+	protected     bool
+	unlocked      bool // unlock sequence seen, the page load may program
+	sequence      []eepromWrite
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+// Times are in the cycles of whatever clock the EEPROM is attached to the
+// scheduler with. A 28C256 takes up to 10ms to write and allows 150us
+// between page loads.
+// ----------------------------------------------------------------------------
+
+func NewEEPROM(size int, write_cycles uint64, window_cycles uint64) *EEPROM {
+	if size&(size-1) != 0 || size == 0 {
+		panic(fmt.Errorf("EEPROM size %d is not a power of two", size))
+	}
+	e := &EEPROM{
+		data:          make([]uint8, size),
+		write_cycles:  write_cycles,
+		window_cycles: window_cycles,
+	}
+	for i := range e.data {
+		e.data[i] = 0xFF
+	}
+	return e
+}
+
+// The contents, for loading an image or checking what was flashed
+func (e *EEPROM) Data() []uint8 {
+	return e.data
+}
+
+// True from the first write of a page load until programming completes
+func (e *EEPROM) Busy() bool {
+	return e.holding || e.loading || e.programming
+}
+
+func (e *EEPROM) Protected() bool {
+	return e.protected
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+// The contents and protection state are non-volatile; a write in progress
+// is allowed to finish
+func (e *EEPROM) Reset() {
+	for e.NextEvent() != NO_EVENT {
+		e.Tick(e.timer)
+	}
+}
+
+func (e *EEPROM) Tick(cycles uint64) {
+	for cycles > 0 && e.NextEvent() != NO_EVENT {
+		step := min(cycles, e.timer)
+		cycles -= step
+		e.timer -= step
+		if e.timer > 0 {
+			continue
+		}
+		switch {
+		case e.holding:
+			e.flush()
+		case e.loading:
+			e.program()
+		default:
+			e.programming = false
+		}
+	}
+}
+
+func (e *EEPROM) NextEvent() uint64 {
+	if e.holding || e.loading || e.programming {
+		return e.timer
+	}
+	return NO_EVENT
+}
+
+func (e *EEPROM) IRQ() bool {
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Programming
+// ----------------------------------------------------------------------------
+
+func (e *EEPROM) mask(addr uint16) uint16 {
+	return addr & uint16(len(e.data)-1)
+}
+
+// The load window has closed; commit the page buffer
+func (e *EEPROM) program() {
+	e.loading = false
+	if e.protected && !e.unlocked {
+		e.loaded = [EEPROM_PAGE_SIZE]bool{}
+		return
+	}
+	e.unlocked = false
+	for i, loaded := range e.loaded {
+		if loaded {
+			e.data[int(e.page)+i] = e.buffer[i]
+		}
+	}
+	e.loaded = [EEPROM_PAGE_SIZE]bool{}
+	e.programming = true
+	e.timer = e.write_cycles
+	if e.timer == 0 {
+		e.programming = false
+	}
+}
+
+func (e *EEPROM) load(addr uint16, data uint8) {
+	addr = e.mask(addr)
+	e.page = addr &^ (EEPROM_PAGE_SIZE - 1)
+	e.buffer[addr%EEPROM_PAGE_SIZE] = data
+	e.loaded[addr%EEPROM_PAGE_SIZE] = true
+	e.last = data
+	e.loading = true
+	e.timer = e.window_cycles
+	if e.timer == 0 {
+		e.program()
+	}
+}
+
+func sequence_matches(sequence []eepromWrite, writes []eepromWrite, mask uint16) bool {
+	if len(writes) > len(sequence) {
+		return false
+	}
+	for i, w := range writes {
+		if w.addr != sequence[i].addr&mask || w.data != sequence[i].data {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes that could be part of a protection sequence are held back until
+// the sequence completes or turns out to be ordinary data
+func (e *EEPROM) command(addr uint16, data uint8) {
+	mask := uint16(len(e.data) - 1)
+	e.sequence = append(e.sequence, eepromWrite{e.mask(addr), data})
+	e.holding = true
+	e.timer = e.window_cycles
+
+	switch {
+	case len(e.sequence) == len(eepromUnlock) && sequence_matches(eepromUnlock, e.sequence, mask):
+		e.sequence = nil
+		e.holding = false
+		e.protected = true
+		e.unlocked = true
+	case len(e.sequence) == len(eepromDisable) && sequence_matches(eepromDisable, e.sequence, mask):
+		e.sequence = nil
+		e.holding = false
+		e.protected = false
+	case sequence_matches(eepromUnlock, e.sequence, mask), sequence_matches(eepromDisable, e.sequence, mask):
+		if e.timer == 0 {
+			e.flush()
+		}
+	default:
+		e.flush()
+	}
+}
+
+// Not a command after all; whatever was held back is data
+func (e *EEPROM) flush() {
+	held := e.sequence
+	e.sequence = nil
+	e.holding = false
+	for _, w := range held {
+		if !e.programming {
+			e.load(w.addr, w.data)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (e *EEPROM) Read(addr uint16) uint8 {
+	if e.programming {
+		status := ^e.last&EEPROM_POLL_BIT | e.toggle | e.last&^(EEPROM_POLL_BIT|EEPROM_TOGGLE)
+		e.toggle ^= EEPROM_TOGGLE
+		return status
+	}
+	return e.data[e.mask(addr)]
+}
+
+func (e *EEPROM) Write(addr uint16, data uint8) {
+	if e.programming {
+		return
+	}
+	// A page load under way, or the one that follows the unlock sequence
+	if e.loading || e.unlocked {
+		e.load(addr, data)
+		return
+	}
+	e.command(addr, data)
+}
@@ -0,0 +1,148 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_eeprom_test.go
+// Tests the 28C256-style EEPROM write cycle and data protection
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+const (
+	testEEPROMWrite  = 1000
+	testEEPROMWindow = 10
+)
+
+func writeEEPROM(e *EEPROM, writes []eepromWrite) {
+	for _, w := range writes {
+		e.Write(w.addr, w.data)
+	}
+}
+
+func TestEEPROMPageWrite(t *testing.T) {
+
+	e := NewEEPROM(0x8000, testEEPROMWrite, testEEPROMWindow)
+
+	// Each write restarts the load window
+	e.Write(0x0100, 0x11)
+	e.Tick(testEEPROMWindow - 1)
+	e.Write(0x0101, 0x22)
+	e.Tick(testEEPROMWindow - 1)
+	e.Write(0x0102, 0x33)
+	if !e.Busy() || e.Read(0x0100) != 0xFF {
+		t.Errorf("page programmed while still loading")
+	}
+	if next := e.NextEvent(); next != testEEPROMWindow {
+		t.Errorf("window closes in %d cycles", next)
+	}
+	e.Tick(testEEPROMWindow)
+
+	// DATA polling reads the complement of bit 7, with bit 6 toggling
+	first, second := e.Read(0x0000), e.Read(0x0000)
+	if first&EEPROM_POLL_BIT == 0x33&EEPROM_POLL_BIT {
+		t.Errorf("polled %02X, expected bit 7 inverted", first)
+	}
+	if (first^second)&EEPROM_TOGGLE == 0 {
+		t.Errorf("bit 6 did not toggle: %02X then %02X", first, second)
+	}
+
+	// Writes while programming are ignored
+	e.Write(0x0200, 0x44)
+	e.Tick(testEEPROMWrite - 1)
+	if !e.Busy() {
+		t.Errorf("write finished early")
+	}
+	e.Tick(1)
+	if e.Busy() {
+		t.Errorf("still busy after the write time")
+	}
+	if data := e.Data(); data[0x0100] != 0x11 || data[0x0101] != 0x22 ||
+		data[0x0102] != 0x33 || data[0x0200] != 0xFF {
+		t.Errorf("programmed % X and %02X", data[0x0100:0x0103], data[0x0200])
+	}
+	if e.Read(0x0102) != 0x33 || e.Read(0x0102) != 0x33 {
+		t.Errorf("reads still polling after the write")
+	}
+
+}
+
+func TestEEPROMDataProtection(t *testing.T) {
+
+	e := NewEEPROM(0x8000, testEEPROMWrite, testEEPROMWindow)
+	settle := func() {
+		for e.NextEvent() != NO_EVENT {
+			e.Tick(e.NextEvent())
+		}
+	}
+
+	// The unlock sequence turns protection on and programs the page after it
+	writeEEPROM(e, eepromUnlock)
+	e.Write(0x0000, 0x01)
+	settle()
+	if !e.Protected() || e.Data()[0x0000] != 0x01 {
+		t.Errorf("protected %v, programmed %02X after unlocking", e.Protected(), e.Data()[0x0000])
+	}
+	for _, w := range eepromUnlock {
+		if e.Data()[w.addr&0x7FFF] != 0xFF {
+			t.Errorf("unlock sequence written to $%04X", w.addr&0x7FFF)
+		}
+	}
+
+	// A plain page load is dropped without a write cycle
+	e.Write(0x0000, 0x02)
+	e.Tick(testEEPROMWindow)
+	if e.Busy() || e.Data()[0x0000] != 0x01 {
+		t.Errorf("protected EEPROM wrote %02X", e.Data()[0x0000])
+	}
+
+	// Each page load needs its own unlock
+	writeEEPROM(e, eepromUnlock)
+	e.Write(0x0000, 0x03)
+	settle()
+	e.Write(0x0000, 0x04)
+	settle()
+	if e.Data()[0x0000] != 0x03 {
+		t.Errorf("read %02X, expected only the unlocked load", e.Data()[0x0000])
+	}
+
+	// The disable sequence takes protection off
+	writeEEPROM(e, eepromDisable)
+	settle()
+	e.Write(0x0000, 0x05)
+	settle()
+	if e.Protected() || e.Data()[0x0000] != 0x05 {
+		t.Errorf("protected %v, wrote %02X after disabling", e.Protected(), e.Data()[0x0000])
+	}
+
+	// A sequence broken off is data after all
+	e.Write(EEPROM_ADDRESS_1, 0xAA)
+	e.Write(EEPROM_ADDRESS_1+1, 0x54)
+	settle()
+	if data := e.Data()[EEPROM_ADDRESS_1:]; data[0] != 0xAA || data[1] != 0x54 {
+		t.Errorf("broken sequence wrote %02X %02X", data[0], data[1])
+	}
+
+}
@@ -0,0 +1,582 @@
+package cpu6502
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// device_lcd.go
+// HD44780 character LCD controller
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Instructions
+// ----------------------------------------------------------------------------
+
+const (
+	LCD_CLEAR         = 0b00000001
+	LCD_HOME          = 0b00000010
+	LCD_ENTRY_MODE    = 0b00000100
+	LCD_DISPLAY       = 0b00001000
+	LCD_SHIFT         = 0b00010000
+	LCD_FUNCTION      = 0b00100000
+	LCD_SET_CGRAM     = 0b01000000
+	LCD_SET_DDRAM     = 0b10000000
+	LCD_BUSY          = 0b10000000
+	LCD_ENTRY_INC     = 0b00000010
+	LCD_ENTRY_SHIFT   = 0b00000001
+	LCD_DISPLAY_ON    = 0b00000100
+	LCD_CURSOR_ON     = 0b00000010
+	LCD_BLINK_ON      = 0b00000001
+	LCD_SHIFT_DISPLAY = 0b00001000
+	LCD_SHIFT_RIGHT   = 0b00000100
+	LCD_FUNCTION_8BIT = 0b00010000
+	LCD_FUNCTION_2ROW = 0b00001000
+)
+
+// Execution times in microseconds at the nominal 270kHz oscillator
+const (
+	LCD_TIME_CLEAR = 1520
+	LCD_TIME_OTHER = 37
+	LCD_TIME_DATA  = 41
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type HD44780 struct {
+	columns     int
+	rows        int
+	clock       uint64 // CPU clock in Hz, to convert busy times to cycles
+	ddram       [0x80]uint8
+	cgram       [0x40]uint8
+	address     uint8
+	cgram_mode  bool // address refers to CGRAM
+	increment   bool
+	entry_shift bool
+	display_on  bool
+	cursor_on   bool
+	blink_on    bool
+	eight_bit   bool
+	two_rows    bool
+	shift       int
+	busy        uint64 // cycles until the busy flag clears
+	nibble      bool   // first half of a 4-bit transfer has been seen
+	high        uint8
+	missed      int
+	OnChange    func() // called after anything visible changes
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewHD44780(columns int, rows int, clock uint64) *HD44780 {
+	if columns <= 0 || rows <= 0 {
+		panic(fmt.Errorf("LCD of %dx%d characters", columns, rows))
+	}
+	l := &HD44780{columns: columns, rows: rows, clock: clock}
+	l.Reset()
+	return l
+}
+
+// Instructions and data written while the controller was busy, which the
+// real part ignores
+func (l *HD44780) Missed() int {
+	return l.missed
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+// Power-on state: 8-bit interface, one row, display off, incrementing
+func (l *HD44780) Reset() {
+	for i := range l.ddram {
+		l.ddram[i] = ' '
+	}
+	l.address = 0
+	l.cgram_mode = false
+	l.increment = true
+	l.entry_shift = false
+	l.display_on = false
+	l.cursor_on = false
+	l.blink_on = false
+	l.eight_bit = true
+	l.two_rows = false
+	l.shift = 0
+	l.busy = 0
+	l.nibble = false
+	l.changed()
+}
+
+func (l *HD44780) Tick(cycles uint64) {
+	l.busy -= min(l.busy, cycles)
+}
+
+func (l *HD44780) NextEvent() uint64 {
+	if l.busy == 0 {
+		return NO_EVENT
+	}
+	return l.busy
+}
+
+func (l *HD44780) IRQ() bool {
+	return false
+}
+
+func (l *HD44780) changed() {
+	if l.OnChange != nil {
+		l.OnChange()
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Addressing
+// ----------------------------------------------------------------------------
+// In two row mode DDRAM is split into two 40 byte halves at $00 and $40.
+// Four row displays fold each half in two, so row 2 continues row 0.
+// ----------------------------------------------------------------------------
+
+func (l *HD44780) line_length() int {
+	if l.two_rows {
+		return 40
+	}
+	return 80
+}
+
+func (l *HD44780) step_address(up bool) {
+	if l.cgram_mode {
+		if up {
+			l.address = (l.address + 1) & 0x3F
+		} else {
+			l.address = (l.address - 1) & 0x3F
+		}
+		return
+	}
+
+	if !l.two_rows {
+		if up {
+			l.address = uint8((int(l.address) + 1) % 80)
+		} else {
+			l.address = uint8((int(l.address) + 79) % 80)
+		}
+		return
+	}
+
+	// Moving off the end of one line continues on the other
+	switch {
+	case up && l.address == 0x27:
+		l.address = 0x40
+	case up && l.address == 0x67:
+		l.address = 0x00
+	case !up && l.address == 0x00:
+		l.address = 0x67
+	case !up && l.address == 0x40:
+		l.address = 0x27
+	case up:
+		l.address++
+	default:
+		l.address--
+	}
+}
+
+func (l *HD44780) shift_display(right bool) {
+	length := l.line_length()
+	if right {
+		l.shift = (l.shift + length - 1) % length
+	} else {
+		l.shift = (l.shift + 1) % length
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Execution
+// ----------------------------------------------------------------------------
+
+func (l *HD44780) start(microseconds uint64) {
+	l.busy = microseconds * l.clock / 1000000
+}
+
+func (l *HD44780) instruction(data uint8) {
+	switch {
+	case data&LCD_SET_DDRAM != 0:
+		l.address = data & 0x7F
+		l.cgram_mode = false
+	case data&LCD_SET_CGRAM != 0:
+		l.address = data & 0x3F
+		l.cgram_mode = true
+	case data&LCD_FUNCTION != 0:
+		l.eight_bit = data&LCD_FUNCTION_8BIT != 0
+		l.two_rows = data&LCD_FUNCTION_2ROW != 0
+		l.nibble = false
+	case data&LCD_SHIFT != 0:
+		if data&LCD_SHIFT_DISPLAY != 0 {
+			l.shift_display(data&LCD_SHIFT_RIGHT != 0)
+		} else {
+			l.step_address(data&LCD_SHIFT_RIGHT != 0)
+		}
+	case data&LCD_DISPLAY != 0:
+		l.display_on = data&LCD_DISPLAY_ON != 0
+		l.cursor_on = data&LCD_CURSOR_ON != 0
+		l.blink_on = data&LCD_BLINK_ON != 0
+	case data&LCD_ENTRY_MODE != 0:
+		l.increment = data&LCD_ENTRY_INC != 0
+		l.entry_shift = data&LCD_ENTRY_SHIFT != 0
+	case data&LCD_HOME != 0:
+		l.address = 0
+		l.cgram_mode = false
+		l.shift = 0
+		l.start(LCD_TIME_CLEAR)
+		l.changed()
+		return
+	case data&LCD_CLEAR != 0:
+		for i := range l.ddram {
+			l.ddram[i] = ' '
+		}
+		l.address = 0
+		l.cgram_mode = false
+		l.increment = true
+		l.shift = 0
+		l.start(LCD_TIME_CLEAR)
+		l.changed()
+		return
+	}
+	l.start(LCD_TIME_OTHER)
+	l.changed()
+}
+
+func (l *HD44780) write_data(data uint8) {
+	if l.cgram_mode {
+		l.cgram[l.address] = data & 0x1F
+	} else {
+		l.ddram[l.address] = data
+		if l.entry_shift {
+			l.shift_display(!l.increment)
+		}
+	}
+	l.step_address(l.increment)
+	l.start(LCD_TIME_DATA)
+	l.changed()
+}
+
+func (l *HD44780) read_data() uint8 {
+	var data uint8
+	if l.cgram_mode {
+		data = l.cgram[l.address]
+	} else {
+		data = l.ddram[l.address]
+	}
+	l.step_address(l.increment)
+	l.start(LCD_TIME_DATA)
+	return data
+}
+
+func (l *HD44780) status() uint8 {
+	status := l.address
+	if l.busy > 0 {
+		status |= LCD_BUSY
+	}
+	return status
+}
+
+// ----------------------------------------------------------------------------
+// Interface
+// ----------------------------------------------------------------------------
+// One transfer per enable pulse. In 4-bit mode only D7-D4 carry data, high
+// nibble first, and it takes two pulses to move a byte.
+// ----------------------------------------------------------------------------
+
+func (l *HD44780) write(rs bool, data uint8) {
+	if !l.eight_bit {
+		if !l.nibble {
+			l.high = data & 0xF0
+			l.nibble = true
+			return
+		}
+		data = l.high | data>>4
+		l.nibble = false
+	}
+
+	if l.busy > 0 {
+		l.missed++
+		return
+	}
+	if rs {
+		l.write_data(data)
+	} else {
+		l.instruction(data)
+	}
+}
+
+func (l *HD44780) read(rs bool) uint8 {
+	if !l.eight_bit && l.nibble {
+		l.nibble = false
+		return l.high
+	}
+
+	var data uint8
+	if rs {
+		data = l.read_data()
+	} else {
+		data = l.status()
+	}
+
+	if !l.eight_bit {
+		l.high = data << 4
+		l.nibble = true
+		return data & 0xF0
+	}
+	return data
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+// For an LCD wired straight to the bus with RS on A0, so offset 0 is the
+// instruction register and offset 1 the data register.
+// ----------------------------------------------------------------------------
+
+func (l *HD44780) Read(addr uint16) uint8 {
+	return l.read(addr&1 != 0)
+}
+
+func (l *HD44780) Write(addr uint16, data uint8) {
+	l.write(addr&1 != 0, data)
+}
+
+// ----------------------------------------------------------------------------
+// Port Wiring
+// ----------------------------------------------------------------------------
+// Connects the LCD to port pins. The data lines start at DataShift on the
+// data port (D0 in 8-bit wiring, D4 when only four lines are wired), and RS,
+// RW and E are pin masks on the control port. Data and control may share a
+// port. Writes latch on the falling edge of E; reads drive the data pins
+// while E is high.
+// ----------------------------------------------------------------------------
+
+type HD44780Wiring struct {
+	Data      *Port
+	DataShift uint
+	FourBit   bool
+	Control   *Port
+	RS        uint8
+	RW        uint8
+	E         uint8
+}
+
+type lcdPins struct {
+	lcd      *HD44780
+	wiring   HD44780Wiring
+	data     uint8
+	control  uint8
+	primed   bool // initial levels have been seen
+	strobe   bool // E rose since the LCD was connected
+	driving  bool
+	out      uint8
+	data_pin *lcdPinSide
+}
+
+type lcdPinSide struct {
+	pins    *lcdPins
+	control bool
+	data    bool
+}
+
+func (l *HD44780) Connect(wiring HD44780Wiring) {
+	pins := &lcdPins{lcd: l, wiring: wiring, data: 0xFF, control: 0xFF}
+	if wiring.Data == wiring.Control {
+		side := &lcdPinSide{pins: pins, control: true, data: true}
+		pins.data_pin = side
+		wiring.Data.Connect(side)
+		return
+	}
+	pins.data_pin = &lcdPinSide{pins: pins, data: true}
+	wiring.Data.Connect(pins.data_pin)
+	wiring.Control.Connect(&lcdPinSide{pins: pins, control: true})
+}
+
+func (p *lcdPins) data_mask() uint8 {
+	if p.wiring.FourBit {
+		return 0x0F << p.wiring.DataShift
+	}
+	return 0xFF << p.wiring.DataShift
+}
+
+func (p *lcdPins) update(control uint8) {
+	w := p.wiring
+	was_high := p.control&w.E != 0
+	p.control = control
+	is_high := control&w.E != 0
+	rs := control&w.RS != 0
+	rw := control&w.RW != 0
+
+	// Undriven pins float high, so the levels seen on connection are not
+	// an enable pulse
+	if !p.primed {
+		p.primed = true
+		return
+	}
+
+	switch {
+	case is_high && !was_high:
+		p.strobe = true
+		if !rw {
+			break
+		}
+		data := p.lcd.read(rs)
+		if w.FourBit {
+			p.out = (data >> 4) << w.DataShift
+		} else {
+			p.out = data << w.DataShift
+		}
+		p.driving = true
+	case !is_high && was_high && p.strobe:
+		p.strobe = false
+		if !rw {
+			data := p.data >> w.DataShift
+			if w.FourBit {
+				data = (data & 0x0F) << 4
+			}
+			p.lcd.write(rs, data)
+		}
+		p.driving = false
+	}
+}
+
+func (s *lcdPinSide) PortOutput(pins uint8) {
+	if s.data {
+		s.pins.data = pins
+	}
+	if s.control {
+		s.pins.update(pins)
+	}
+}
+
+func (s *lcdPinSide) PortInput() uint8 {
+	if !s.data || !s.pins.driving {
+		return 0xFF
+	}
+	mask := s.pins.data_mask()
+	return s.pins.out&mask | ^mask
+}
+
+// ----------------------------------------------------------------------------
+// Rendering
+// ----------------------------------------------------------------------------
+
+// The A00 character ROM is ASCII from $20 to $7D apart from the yen sign and
+// arrows. Custom CGRAM characters and the katakana half render as '?'.
+func lcdRune(code uint8) rune {
+	switch {
+	case code == 0x5C:
+		return '¥'
+	case code == 0x7E:
+		return '→'
+	case code == 0x7F:
+		return '←'
+	case code >= 0x20 && code < 0x7E:
+		return rune(code)
+	case code == 0xDF:
+		return '°'
+	default:
+		return '?'
+	}
+}
+
+func (l *HD44780) row_address(row int) int {
+	base := 0
+	if row%2 == 1 {
+		base = 0x40
+	}
+	if row >= 2 {
+		base += l.columns
+	}
+	return base
+}
+
+// The visible text, one line per row. A display that is switched off shows
+// blank lines.
+func (l *HD44780) Text() string {
+	lines := make([]string, l.rows)
+	length := l.line_length()
+	for row := range l.rows {
+		var line strings.Builder
+		for column := range l.columns {
+			if !l.display_on {
+				line.WriteByte(' ')
+				continue
+			}
+			offset := l.row_address(row)
+			base := offset &^ 0x3F
+			if !l.two_rows {
+				base = 0
+			}
+			position := (offset - base + column + l.shift) % length
+			line.WriteRune(lcdRune(l.ddram[base+position]))
+		}
+		lines[row] = line.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Draws the display in a box at the top of an ANSI terminal, with the
+// cursor shown in inverse video
+func (l *HD44780) RenderANSI(w io.Writer) error {
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	border := "+" + strings.Repeat("-", l.columns) + "+\r\n"
+	screen.WriteString(border)
+
+	lines := strings.Split(l.Text(), "\n")
+	cursor_row, cursor_column := -1, -1
+	if l.display_on && l.cursor_on && !l.cgram_mode {
+		for row := range l.rows {
+			offset := int(l.address) - l.row_address(row)
+			length := l.line_length()
+			column := ((offset-l.shift)%length + length) % length
+			if offset >= 0 && offset < length && column < l.columns {
+				cursor_row, cursor_column = row, column
+			}
+		}
+	}
+
+	for row, line := range lines {
+		screen.WriteString("|")
+		for column, r := range []rune(line) {
+			if row == cursor_row && column == cursor_column {
+				fmt.Fprintf(&screen, "\x1b[7m%c\x1b[0m", r)
+			} else {
+				screen.WriteRune(r)
+			}
+		}
+		screen.WriteString("|\r\n")
+	}
+	screen.WriteString(border)
+
+	_, err := io.WriteString(w, screen.String())
+	return err
+}
@@ -0,0 +1,108 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_lcd_test.go
+// Tests the HD44780 LCD
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+func TestLCDFourBitOnVIAPort(t *testing.T) {
+
+	via := NewVIA()
+	lcd := NewHD44780(16, 2, 1000000)
+	lcd.Connect(HD44780Wiring{
+		Data: via.PortB(), Control: via.PortB(), FourBit: true,
+		RS: 0x10, RW: 0x20, E: 0x40,
+	})
+	via.Write(VIA_DDRB, 0xFF)
+
+	nibble := func(rs uint8, data uint8) {
+		via.Write(VIA_ORB, rs|data)
+		via.Write(VIA_ORB, rs|data|0x40)
+		via.Write(VIA_ORB, rs|data)
+		lcd.Tick(100)
+	}
+	send := func(rs uint8, data uint8) {
+		nibble(rs, data>>4)
+		nibble(rs, data&0x0F)
+	}
+
+	nibble(0, 0x2) // 4-bit interface, sent as one 8-bit transfer
+	send(0, LCD_FUNCTION|LCD_FUNCTION_2ROW)
+	send(0, LCD_DISPLAY|LCD_DISPLAY_ON)
+	for _, c := range "Hi" {
+		send(0x10, uint8(c))
+	}
+	send(0, LCD_SET_DDRAM|0x40)
+	send(0x10, '!')
+
+	want := "Hi              \n!               "
+	if lcd.Text() != want {
+		t.Errorf("text = %q, want %q", lcd.Text(), want)
+	}
+	if lcd.Missed() != 0 {
+		t.Errorf("missed = %d, want 0", lcd.Missed())
+	}
+
+}
+
+func TestLCDBusyFlag(t *testing.T) {
+
+	lcd := NewHD44780(16, 2, 1000000)
+	lcd.Write(0, LCD_FUNCTION|LCD_FUNCTION_8BIT|LCD_FUNCTION_2ROW)
+	if lcd.Read(0)&LCD_BUSY == 0 {
+		t.Fatalf("busy flag clear straight after an instruction")
+	}
+
+	// Ignored while busy
+	lcd.Write(0, LCD_DISPLAY|LCD_DISPLAY_ON)
+	if lcd.Missed() != 1 {
+		t.Errorf("missed = %d, want 1", lcd.Missed())
+	}
+
+	lcd.Tick(LCD_TIME_OTHER)
+	if lcd.Read(0)&LCD_BUSY != 0 {
+		t.Errorf("busy flag still set after %dus", LCD_TIME_OTHER)
+	}
+
+}
+
+func TestLCDRejectsEmpty(t *testing.T) {
+
+	for _, size := range [][2]int{{0, 2}, {16, 0}, {16, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%dx%d LCD created", size[0], size[1])
+				}
+			}()
+			NewHD44780(size[0], size[1], 1000000)
+		}()
+	}
+
+}
@@ -0,0 +1,343 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_pia.go
+// MC6821 / MOS 6520 Peripheral Interface Adapter
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+// Offsets 0 and 2 reach either the data direction register or the peripheral
+// register, depending on bit 2 of the matching control register.
+// ----------------------------------------------------------------------------
+
+const (
+	PIA_PRA = 0x0 // or DDRA
+	PIA_CRA = 0x1
+	PIA_PRB = 0x2 // or DDRB
+	PIA_CRB = 0x3
+)
+
+// ----------------------------------------------------------------------------
+// Control register bits
+
+const (
+	PIA_CR_C1_IRQ    = 0b00000001 // C1 interrupt enable
+	PIA_CR_C1_RISING = 0b00000010 // C1 active on the rising edge
+	PIA_CR_PORT      = 0b00000100 // select peripheral register, not DDR
+	PIA_CR_C2_IRQ    = 0b00001000 // C2 interrupt enable (input mode)
+	PIA_CR_C2_RISING = 0b00010000 // C2 active on the rising edge (input mode)
+	PIA_CR_C2_OUTPUT = 0b00100000
+	PIA_CR_C2_FLAG   = 0b01000000
+	PIA_CR_C1_FLAG   = 0b10000000
+	PIA_CR_C2_MANUAL = 0b00010000 // C2 follows bit 3 (output mode)
+	PIA_CR_C2_PULSE  = 0b00001000 // C2 restored after one cycle (output mode)
+	PIA_CR_WRITABLE  = 0b00111111
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type piaSide struct {
+	port   Port
+	cr     uint8
+	c1     bool
+	c2     bool // input level
+	c2_out bool
+	pulse  bool
+	on_c2  func(bool)
+}
+
+type PIA struct {
+	a     piaSide
+	b     piaSide
+	OnCA2 func(bool) // CA2 output changed
+	OnCB2 func(bool) // CB2 output changed
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewPIA() *PIA {
+	p := &PIA{}
+	p.a.on_c2 = func(level bool) {
+		if p.OnCA2 != nil {
+			p.OnCA2(level)
+		}
+	}
+	p.b.on_c2 = func(level bool) {
+		if p.OnCB2 != nil {
+			p.OnCB2(level)
+		}
+	}
+	p.Reset()
+	return p
+}
+
+func (p *PIA) PortA() *Port {
+	return &p.a.port
+}
+
+func (p *PIA) PortB() *Port {
+	return &p.b.port
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (p *PIA) Reset() {
+	for _, side := range []*piaSide{&p.a, &p.b} {
+		side.port.reset()
+		side.cr = 0
+		side.c1, side.c2, side.c2_out, side.pulse = true, true, true, false
+	}
+}
+
+// The only timed behaviour is the end of a C2 pulse
+func (p *PIA) Tick(cycles uint64) {
+	for _, side := range []*piaSide{&p.a, &p.b} {
+		if side.pulse {
+			side.pulse = false
+			p.set_c2_out(side, true)
+		}
+	}
+}
+
+func (p *PIA) NextEvent() uint64 {
+	if p.a.pulse || p.b.pulse {
+		return 1
+	}
+	return NO_EVENT
+}
+
+func (p *PIA) IRQ() bool {
+	return p.IRQA() || p.IRQB()
+}
+
+func (p *PIA) IRQA() bool {
+	return p.a.irq()
+}
+
+func (p *PIA) IRQB() bool {
+	return p.b.irq()
+}
+
+func (s *piaSide) irq() bool {
+	if s.cr&PIA_CR_C1_FLAG != 0 && s.cr&PIA_CR_C1_IRQ != 0 {
+		return true
+	}
+	return s.cr&PIA_CR_C2_FLAG != 0 && s.cr&PIA_CR_C2_IRQ != 0 &&
+		s.cr&PIA_CR_C2_OUTPUT == 0
+}
+
+// ----------------------------------------------------------------------------
+// Control Lines
+// ----------------------------------------------------------------------------
+
+func (p *PIA) set_c2_out(side *piaSide, level bool) {
+	if side.c2_out != level {
+		side.c2_out = level
+		side.on_c2(level)
+	}
+}
+
+func (p *PIA) set_c1(side *piaSide, level bool) {
+	if side.c1 == level {
+		return
+	}
+	side.c1 = level
+	if level != (side.cr&PIA_CR_C1_RISING != 0) {
+		return
+	}
+
+	// Active edge; ends a handshake in progress
+	side.cr |= PIA_CR_C1_FLAG
+	if side.cr&(PIA_CR_C2_OUTPUT|PIA_CR_C2_MANUAL|PIA_CR_C2_PULSE) == PIA_CR_C2_OUTPUT {
+		p.set_c2_out(side, true)
+	}
+}
+
+func (p *PIA) set_c2(side *piaSide, level bool) {
+	if side.c2 == level {
+		return
+	}
+	side.c2 = level
+	if side.cr&PIA_CR_C2_OUTPUT == 0 && level == (side.cr&PIA_CR_C2_RISING != 0) {
+		side.cr |= PIA_CR_C2_FLAG
+	}
+}
+
+// A read of PRA or a write of PRB starts a handshake on C2
+func (p *PIA) handshake(side *piaSide) {
+	if side.cr&(PIA_CR_C2_OUTPUT|PIA_CR_C2_MANUAL) != PIA_CR_C2_OUTPUT {
+		return
+	}
+	p.set_c2_out(side, false)
+	side.pulse = side.cr&PIA_CR_C2_PULSE != 0
+}
+
+func (p *PIA) write_control(side *piaSide, data uint8) {
+	side.cr = side.cr&^PIA_CR_WRITABLE | data&PIA_CR_WRITABLE
+	if side.cr&(PIA_CR_C2_OUTPUT|PIA_CR_C2_MANUAL) == PIA_CR_C2_OUTPUT|PIA_CR_C2_MANUAL {
+		p.set_c2_out(side, side.cr&PIA_CR_C2_IRQ != 0)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// External Inputs
+// ----------------------------------------------------------------------------
+
+func (p *PIA) SetCA1(level bool) {
+	p.set_c1(&p.a, level)
+}
+
+func (p *PIA) SetCA2(level bool) {
+	p.set_c2(&p.a, level)
+}
+
+func (p *PIA) SetCB1(level bool) {
+	p.set_c1(&p.b, level)
+}
+
+func (p *PIA) SetCB2(level bool) {
+	p.set_c2(&p.b, level)
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (p *PIA) Read(addr uint16) uint8 {
+	switch addr & 0x03 {
+	case PIA_PRA:
+		if p.a.cr&PIA_CR_PORT == 0 {
+			return p.a.port.direction
+		}
+		p.a.cr &^= PIA_CR_C1_FLAG | PIA_CR_C2_FLAG
+		p.handshake(&p.a)
+		return p.a.port.Pins()
+	case PIA_CRA:
+		return p.a.cr
+	case PIA_PRB:
+		if p.b.cr&PIA_CR_PORT == 0 {
+			return p.b.port.direction
+		}
+		p.b.cr &^= PIA_CR_C1_FLAG | PIA_CR_C2_FLAG
+		// Output pins read back the output register
+		port := &p.b.port
+		return port.output&port.direction | port.Pins()&^port.direction
+	default: // PIA_CRB
+		return p.b.cr
+	}
+}
+
+func (p *PIA) Write(addr uint16, data uint8) {
+	switch addr & 0x03 {
+	case PIA_PRA:
+		if p.a.cr&PIA_CR_PORT == 0 {
+			p.a.port.set_direction(data)
+		} else {
+			p.a.port.set_output(data)
+		}
+	case PIA_CRA:
+		p.write_control(&p.a, data)
+	case PIA_PRB:
+		if p.b.cr&PIA_CR_PORT == 0 {
+			p.b.port.set_direction(data)
+		} else {
+			p.b.port.set_output(data)
+			p.handshake(&p.b)
+		}
+	default: // PIA_CRB
+		p.write_control(&p.b, data)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Go-side Peripherals
+// ----------------------------------------------------------------------------
+// A keyboard puts a key code on port A and strobes CA1, and a character
+// output takes each byte written to port B as CB2 strobes low, then answers
+// on CB1. This is the wiring of the Apple-1 and most trainer boards.
+// ----------------------------------------------------------------------------
+
+type PIAKeyboard struct {
+	pia  *PIA
+	data uint8
+}
+
+func NewPIAKeyboard(pia *PIA) *PIAKeyboard {
+	k := &PIAKeyboard{pia: pia, data: 0xFF}
+	pia.PortA().Connect(k)
+	return k
+}
+
+func (k *PIAKeyboard) PortOutput(uint8) {
+}
+
+func (k *PIAKeyboard) PortInput() uint8 {
+	return k.data
+}
+
+// Strobes both edges so either CA1 polarity sees the key
+func (k *PIAKeyboard) Press(key uint8) {
+	k.data = key
+	k.pia.SetCA1(!k.pia.a.c1)
+	k.pia.SetCA1(!k.pia.a.c1)
+}
+
+type PIAOutput struct {
+	pia    *PIA
+	pins   uint8
+	output func(uint8)
+}
+
+func NewPIAOutput(pia *PIA, output func(uint8)) *PIAOutput {
+	o := &PIAOutput{pia: pia, output: output}
+	pia.PortB().Connect(o)
+	previous := pia.OnCB2
+	pia.OnCB2 = func(level bool) {
+		if previous != nil {
+			previous(level)
+		}
+		if !level {
+			o.output(o.pins)
+			pia.SetCB1(!pia.b.c1)
+			pia.SetCB1(!pia.b.c1)
+		}
+	}
+	return o
+}
+
+func (o *PIAOutput) PortOutput(pins uint8) {
+	o.pins = pins
+}
+
+// Never busy
+func (o *PIAOutput) PortInput() uint8 {
+	return 0x7F // PB7 low
+}
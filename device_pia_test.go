@@ -0,0 +1,150 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_pia_test.go
+// Tests the 6820/6821 PIA and the Go-side keyboard and output
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Control Lines
+// ----------------------------------------------------------------------------
+
+func TestPIAC1Edges(t *testing.T) {
+
+	pia := NewPIA()
+	pia.Write(PIA_CRA, PIA_CR_PORT|PIA_CR_C1_IRQ) // falling edge
+	pia.Write(PIA_CRB, PIA_CR_PORT|PIA_CR_C1_RISING)
+
+	pia.SetCA1(false)
+	if pia.Read(PIA_CRA)&PIA_CR_C1_FLAG == 0 || !pia.IRQA() {
+		t.Errorf("no CA1 flag or interrupt on a falling edge")
+	}
+	pia.Read(PIA_PRA)
+	if pia.Read(PIA_CRA)&PIA_CR_C1_FLAG != 0 || pia.IRQ() {
+		t.Errorf("reading port A did not clear the CA1 flag")
+	}
+	pia.SetCA1(true)
+	if pia.Read(PIA_CRA)&PIA_CR_C1_FLAG != 0 {
+		t.Errorf("CA1 flag set on a rising edge")
+	}
+
+	// CB1 flags the rising edge, but with its interrupt disabled
+	pia.SetCB1(false)
+	if pia.Read(PIA_CRB)&PIA_CR_C1_FLAG != 0 {
+		t.Errorf("CB1 flag set on a falling edge")
+	}
+	pia.SetCB1(true)
+	if pia.Read(PIA_CRB)&PIA_CR_C1_FLAG == 0 || pia.IRQB() {
+		t.Errorf("CB1 flag %v, interrupt %v on a rising edge",
+			pia.Read(PIA_CRB)&PIA_CR_C1_FLAG != 0, pia.IRQB())
+	}
+
+	// The flag is read only
+	pia.Write(PIA_CRB, PIA_CR_PORT)
+	if pia.Read(PIA_CRB)&PIA_CR_C1_FLAG == 0 {
+		t.Errorf("writing the control register cleared the CB1 flag")
+	}
+	pia.Read(PIA_PRB)
+	if pia.Read(PIA_CRB)&PIA_CR_C1_FLAG != 0 {
+		t.Errorf("reading port B did not clear the CB1 flag")
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Ports
+// ----------------------------------------------------------------------------
+
+func TestPIARegisterSelect(t *testing.T) {
+
+	pia := NewPIA()
+	device := &portRecorder{input: 0x30}
+	pia.PortB().Connect(device)
+
+	// Bit 2 of the control register clear reaches the DDR
+	pia.Write(PIA_PRB, 0x0F)
+	if ddr := pia.Read(PIA_PRB); ddr != 0x0F {
+		t.Errorf("DDR B read %02X", ddr)
+	}
+	pia.Write(PIA_CRB, PIA_CR_PORT)
+	pia.Write(PIA_PRB, 0xA5)
+	if pins := device.outputs[len(device.outputs)-1]; pins != 0xF5 {
+		t.Errorf("port B drove %02X", pins)
+	}
+
+	// Outputs read back the register, inputs the pins
+	if data := pia.Read(PIA_PRB); data != 0x35 {
+		t.Errorf("port B read %02X", data)
+	}
+	pia.Write(PIA_CRB, 0)
+	if ddr := pia.Read(PIA_PRB); ddr != 0x0F {
+		t.Errorf("DDR B read %02X after selecting it again", ddr)
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Go-side Peripherals
+// ----------------------------------------------------------------------------
+
+func TestPIAKeyboardAndOutput(t *testing.T) {
+
+	pia := NewPIA()
+	keyboard := NewPIAKeyboard(pia)
+	var printed []uint8
+	NewPIAOutput(pia, func(data uint8) {
+		printed = append(printed, data)
+	})
+
+	// Set up as the Woz Monitor does
+	pia.Write(PIA_PRB, 0x7F)
+	pia.Write(PIA_CRA, 0xA7)
+	pia.Write(PIA_CRB, 0xA7)
+
+	keyboard.Press('A' | 0x80)
+	if pia.Read(PIA_CRA)&PIA_CR_C1_FLAG == 0 {
+		t.Fatalf("key press not flagged")
+	}
+	if key := pia.Read(PIA_PRA); key != 'A'|0x80 {
+		t.Errorf("keyboard read %02X", key)
+	}
+	if pia.Read(PIA_CRA)&PIA_CR_C1_FLAG != 0 {
+		t.Errorf("reading the key did not clear the flag")
+	}
+
+	// The display takes the byte on CB2 and acknowledges on CB1
+	if pia.Read(PIA_PRB)&0x80 != 0 {
+		t.Errorf("display busy")
+	}
+	pia.Write(PIA_PRB, 'A')
+	pia.Write(PIA_PRB, 'B')
+	if string(printed) != "\xC1\xC2" { // PB7 is an input, floating high
+		t.Errorf("printed %q", printed)
+	}
+	if !pia.IRQB() {
+		t.Errorf("no acknowledge on CB1")
+	}
+
+}
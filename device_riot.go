@@ -0,0 +1,247 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_riot.go
+// MOS 6532 RAM-I/O-Timer
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+// The chip's RAM select line is wired differently on every board (A7 on the
+// KIM-1 and 2600 RAM, A9 for 2600 I/O), so the RAM and the I/O registers are
+// mapped separately: RAM() returns the 128 bytes of RAM and the RIOT itself
+// is the I/O space, decoded from A0-A4 as on the datasheet.
+// ----------------------------------------------------------------------------
+
+const (
+	RIOT_DRA  = 0x00
+	RIOT_DDRA = 0x01
+	RIOT_DRB  = 0x02
+	RIOT_DDRB = 0x03
+
+	RIOT_A0 = 0b00001 // read flags / edge polarity
+	RIOT_A1 = 0b00010 // PA7 interrupt enable
+	RIOT_A2 = 0b00100 // timer and edge detect, rather than ports
+	RIOT_A3 = 0b01000 // timer interrupt enable
+	RIOT_A4 = 0b10000 // write timer, rather than edge control
+
+	RIOT_FLAG_TIMER = 0b10000000
+	RIOT_FLAG_PA7   = 0b01000000
+	RIOT_PA7        = 0b10000000
+)
+
+// Timer prescalers selected by A1-A0 when the timer is written
+var riotPrescalers = [4]uint64{1, 8, 64, 1024}
+
+// ----------------------------------------------------------------------------
+// Interval Timer
+// ----------------------------------------------------------------------------
+// Shared with the 6530. The counter decrements on the cycle after it is
+// written and every prescaler cycles after that. Once it counts through zero
+// the flag is set and it carries on decrementing every cycle until the next
+// write, so software can read how long ago the timer expired.
+// ----------------------------------------------------------------------------
+
+type riotTimer struct {
+	counter   uint8
+	prescaler uint64
+	divider   uint64 // cycles until the next decrement, less one
+	expired   bool
+	flag      bool
+	irq       bool // timer interrupt enabled
+}
+
+func (t *riotTimer) write(value uint8, prescaler uint64, irq bool) {
+	t.counter = value
+	t.prescaler = prescaler
+	t.divider = 0
+	t.expired = false
+	t.flag = false
+	t.irq = irq
+}
+
+func (t *riotTimer) read(irq bool) uint8 {
+	t.flag = false
+	t.irq = irq
+	return t.counter
+}
+
+func (t *riotTimer) tick(cycles uint64) {
+	for range cycles {
+		if t.divider > 0 {
+			t.divider--
+			continue
+		}
+		t.counter--
+		if t.counter == 0xFF && !t.expired {
+			t.expired = true
+			t.flag = true
+		}
+		if !t.expired {
+			t.divider = t.prescaler - 1
+		}
+	}
+}
+
+func (t *riotTimer) next_event() uint64 {
+	if t.expired {
+		return NO_EVENT
+	}
+	return t.divider + 1 + uint64(t.counter)*t.prescaler
+}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type RIOT struct {
+	ram        RAM
+	port_a     Port
+	port_b     Port
+	timer      riotTimer
+	pa7        bool
+	pa7_flag   bool
+	pa7_irq    bool
+	pa7_rising bool
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewRIOT() *RIOT {
+	r := &RIOT{ram: NewRAM(128)}
+	r.port_a.on_input = r.edge_detect
+	r.Reset()
+	return r
+}
+
+func (r *RIOT) RAM() RAM {
+	return r.ram
+}
+
+func (r *RIOT) PortA() *Port {
+	return &r.port_a
+}
+
+func (r *RIOT) PortB() *Port {
+	return &r.port_b
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (r *RIOT) Reset() {
+	r.port_a.reset()
+	r.port_b.reset()
+	r.timer = riotTimer{prescaler: 1, expired: true}
+	r.pa7 = r.port_a.Pins()&RIOT_PA7 != 0
+	r.pa7_flag = false
+	r.pa7_irq = false
+	r.pa7_rising = false
+}
+
+func (r *RIOT) Tick(cycles uint64) {
+	r.timer.tick(cycles)
+}
+
+func (r *RIOT) NextEvent() uint64 {
+	return r.timer.next_event()
+}
+
+func (r *RIOT) IRQ() bool {
+	return r.timer.flag && r.timer.irq || r.pa7_flag && r.pa7_irq
+}
+
+// ----------------------------------------------------------------------------
+// Edge Detection
+
+func (r *RIOT) edge_detect() {
+	level := r.port_a.Pins()&RIOT_PA7 != 0
+	if level != r.pa7 {
+		r.pa7 = level
+		if level == r.pa7_rising {
+			r.pa7_flag = true
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (r *RIOT) Read(addr uint16) uint8 {
+	if addr&RIOT_A2 == 0 {
+		switch addr & 0x03 {
+		case RIOT_DRA:
+			return r.port_a.Pins()
+		case RIOT_DDRA:
+			return r.port_a.direction
+		case RIOT_DRB:
+			// Output pins read back the output register
+			return r.port_b.output&r.port_b.direction | r.port_b.Pins()&^r.port_b.direction
+		default: // RIOT_DDRB
+			return r.port_b.direction
+		}
+	}
+
+	if addr&RIOT_A0 == 0 {
+		return r.timer.read(addr&RIOT_A3 != 0)
+	}
+
+	var flags uint8
+	if r.timer.flag {
+		flags |= RIOT_FLAG_TIMER
+	}
+	if r.pa7_flag {
+		flags |= RIOT_FLAG_PA7
+	}
+	r.pa7_flag = false
+	return flags
+}
+
+func (r *RIOT) Write(addr uint16, data uint8) {
+	if addr&RIOT_A2 == 0 {
+		switch addr & 0x03 {
+		case RIOT_DRA:
+			r.port_a.set_output(data)
+		case RIOT_DDRA:
+			r.port_a.set_direction(data)
+		case RIOT_DRB:
+			r.port_b.set_output(data)
+		default: // RIOT_DDRB
+			r.port_b.set_direction(data)
+		}
+		r.edge_detect()
+		return
+	}
+
+	if addr&RIOT_A4 != 0 {
+		r.timer.write(data, riotPrescalers[addr&0x03], addr&RIOT_A3 != 0)
+	} else {
+		r.pa7_rising = addr&RIOT_A0 != 0
+		r.pa7_irq = addr&RIOT_A1 != 0
+	}
+}
@@ -0,0 +1,121 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_riot_test.go
+// Tests the 6532 RIOT
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Interval Timer
+// ----------------------------------------------------------------------------
+
+func TestRIOTTimerPrescaler(t *testing.T) {
+
+	riot := NewRIOT()
+	riot.Write(RIOT_A4|RIOT_A3|RIOT_A2|0x01, 3) // 3 x 8 cycles, interrupt enabled
+
+	// The first decrement is on the next cycle, then every eight
+	if next := riot.NextEvent(); next != 25 {
+		t.Errorf("expected next event in 25 cycles, got %d", next)
+	}
+	riot.Tick(1)
+	if counter := riot.Read(RIOT_A3 | RIOT_A2); counter != 2 {
+		t.Errorf("counter %d after one cycle", counter)
+	}
+	riot.Tick(7)
+	if counter := riot.Read(RIOT_A3 | RIOT_A2); counter != 2 {
+		t.Errorf("counter %d before the prescaler ran out", counter)
+	}
+	riot.Tick(16)
+	if riot.IRQ() {
+		t.Errorf("timer fired early")
+	}
+	riot.Tick(1)
+	if !riot.IRQ() {
+		t.Fatalf("timer did not fire")
+	}
+
+	// After the underflow it counts every cycle, and never fires again
+	riot.Tick(3)
+	if flags := riot.Read(RIOT_A2 | RIOT_A0); flags&RIOT_FLAG_TIMER == 0 {
+		t.Errorf("flags read %02X with the timer expired", flags)
+	}
+	if !riot.IRQ() {
+		t.Errorf("reading the flags cleared the timer interrupt")
+	}
+	if counter := riot.Read(RIOT_A3 | RIOT_A2); counter != 0xFC {
+		t.Errorf("counter %02X three cycles after the underflow", counter)
+	}
+	if riot.IRQ() || riot.Read(RIOT_A2|RIOT_A0)&RIOT_FLAG_TIMER != 0 {
+		t.Errorf("reading the timer did not clear the flag")
+	}
+	if next := riot.NextEvent(); next != NO_EVENT {
+		t.Errorf("expired timer has an event in %d cycles", next)
+	}
+
+	// Reading with A3 low disables the interrupt
+	riot.Write(RIOT_A4|RIOT_A3|RIOT_A2, 0)
+	riot.Read(RIOT_A2)
+	riot.Tick(1)
+	if riot.IRQ() || riot.Read(RIOT_A2|RIOT_A0)&RIOT_FLAG_TIMER == 0 {
+		t.Errorf("interrupt with the timer interrupt disabled")
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Edge Detection
+// ----------------------------------------------------------------------------
+
+func TestRIOTPA7Edge(t *testing.T) {
+
+	riot := NewRIOT()
+	device := &portRecorder{input: 0x00}
+	riot.PortA().Connect(device)
+	riot.PortA().InputChanged()
+	riot.Write(RIOT_A2|RIOT_A1|RIOT_A0, 0) // rising edge, interrupt enabled
+
+	device.input = RIOT_PA7
+	riot.PortA().InputChanged()
+	if !riot.IRQ() {
+		t.Fatalf("no interrupt on a rising edge")
+	}
+
+	// Reading the flags clears the edge flag
+	if flags := riot.Read(RIOT_A2 | RIOT_A0); flags != RIOT_FLAG_PA7 {
+		t.Errorf("flags read %02X", flags)
+	}
+	if riot.IRQ() || riot.Read(RIOT_A2|RIOT_A0) != 0 {
+		t.Errorf("edge flag not cleared on read")
+	}
+
+	// The falling edge is ignored
+	device.input = 0
+	riot.PortA().InputChanged()
+	if riot.IRQ() {
+		t.Errorf("interrupt on a falling edge")
+	}
+
+}
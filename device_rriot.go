@@ -0,0 +1,153 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_rriot.go
+// MOS 6530 ROM-RAM-I/O-Timer
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+// Each 6530 is mask programmed with 1K of ROM and has 64 bytes of RAM, both
+// mapped separately with ROM() and RAM(). The I/O space is decoded from
+// A0-A3: with A2 low, A0-A1 pick a port register; with A2 high a write sets
+// the timer with the prescaler from A0-A1, and a read returns the timer, or
+// the interrupt flag in bit 7 when A0 is set. A3 enables the interrupt.
+// ----------------------------------------------------------------------------
+
+const (
+	RRIOT_PAD      = 0x00
+	RRIOT_PADD     = 0x01
+	RRIOT_PBD      = 0x02
+	RRIOT_PBDD     = 0x03
+	RRIOT_TIMER    = 0b0100
+	RRIOT_IRQ      = 0b1000
+	RRIOT_FLAG     = 0b10000000
+	RRIOT_ROM_SIZE = 1024
+	RRIOT_RAM_SIZE = 64
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type RRIOT struct {
+	rom    ROM
+	ram    RAM
+	port_a Port
+	port_b Port
+	timer  riotTimer
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewRRIOT(rom ROM) *RRIOT {
+	r := &RRIOT{rom: rom, ram: NewRAM(RRIOT_RAM_SIZE)}
+	r.Reset()
+	return r
+}
+
+func (r *RRIOT) ROM() ROM {
+	return r.rom
+}
+
+func (r *RRIOT) RAM() RAM {
+	return r.ram
+}
+
+func (r *RRIOT) PortA() *Port {
+	return &r.port_a
+}
+
+func (r *RRIOT) PortB() *Port {
+	return &r.port_b
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (r *RRIOT) Reset() {
+	r.port_a.reset()
+	r.port_b.reset()
+	r.timer = riotTimer{prescaler: 1, expired: true}
+}
+
+func (r *RRIOT) Tick(cycles uint64) {
+	r.timer.tick(cycles)
+}
+
+func (r *RRIOT) NextEvent() uint64 {
+	return r.timer.next_event()
+}
+
+func (r *RRIOT) IRQ() bool {
+	return r.timer.flag && r.timer.irq
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (r *RRIOT) Read(addr uint16) uint8 {
+	if addr&RRIOT_TIMER == 0 {
+		switch addr & 0x03 {
+		case RRIOT_PAD:
+			return r.port_a.Pins()
+		case RRIOT_PADD:
+			return r.port_a.direction
+		case RRIOT_PBD:
+			// Output pins read back the output register
+			return r.port_b.output&r.port_b.direction | r.port_b.Pins()&^r.port_b.direction
+		default: // RRIOT_PBDD
+			return r.port_b.direction
+		}
+	}
+
+	if addr&0x01 == 0 {
+		return r.timer.read(addr&RRIOT_IRQ != 0)
+	}
+	if r.timer.flag {
+		return RRIOT_FLAG
+	}
+	return 0
+}
+
+func (r *RRIOT) Write(addr uint16, data uint8) {
+	if addr&RRIOT_TIMER == 0 {
+		switch addr & 0x03 {
+		case RRIOT_PAD:
+			r.port_a.set_output(data)
+		case RRIOT_PADD:
+			r.port_a.set_direction(data)
+		case RRIOT_PBD:
+			r.port_b.set_output(data)
+		default: // RRIOT_PBDD
+			r.port_b.set_direction(data)
+		}
+		return
+	}
+	r.timer.write(data, riotPrescalers[addr&0x03], addr&RRIOT_IRQ != 0)
+}
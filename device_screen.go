@@ -0,0 +1,230 @@
+package cpu6502
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// device_screen.go
+// Memory-mapped character cell display
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Layout
+// ----------------------------------------------------------------------------
+// Character codes are stored row by row from offset zero. With a colour plane
+// the attribute bytes follow immediately, one per cell, with the foreground
+// colour in the low nibble and the background in the high nibble. Map the
+// screen wherever the program expects it, for Size() bytes.
+// ----------------------------------------------------------------------------
+
+const (
+	SCREEN_ATTRIBUTE_DEFAULT = 0x07 // light grey on black
+	SCREEN_CELL_WIDTH        = FONT_WIDTH + 1
+	SCREEN_CELL_HEIGHT       = FONT_HEIGHT + 1
+)
+
+// The sixteen CGA colours used by the attribute plane
+var screenPalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xAA, 0xFF},
+	color.RGBA{0x00, 0xAA, 0x00, 0xFF}, color.RGBA{0x00, 0xAA, 0xAA, 0xFF},
+	color.RGBA{0xAA, 0x00, 0x00, 0xFF}, color.RGBA{0xAA, 0x00, 0xAA, 0xFF},
+	color.RGBA{0xAA, 0x55, 0x00, 0xFF}, color.RGBA{0xAA, 0xAA, 0xAA, 0xFF},
+	color.RGBA{0x55, 0x55, 0x55, 0xFF}, color.RGBA{0x55, 0x55, 0xFF, 0xFF},
+	color.RGBA{0x55, 0xFF, 0x55, 0xFF}, color.RGBA{0x55, 0xFF, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0x55, 0x55, 0xFF}, color.RGBA{0xFF, 0x55, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0xFF, 0x55, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+// ANSI colour numbers for each CGA colour, which orders red and blue the
+// other way round
+var screenANSI = [16]int{0, 4, 2, 6, 1, 5, 3, 7, 60, 64, 62, 66, 61, 65, 63, 67}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type TextScreen struct {
+	columns    int
+	rows       int
+	characters []uint8
+	attributes []uint8 // nil without a colour plane
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewTextScreen(columns int, rows int, colour bool) *TextScreen {
+	if columns <= 0 || rows <= 0 {
+		panic(fmt.Errorf("text screen of %dx%d characters", columns, rows))
+	}
+	s := &TextScreen{
+		columns:    columns,
+		rows:       rows,
+		characters: make([]uint8, columns*rows),
+	}
+	if colour {
+		s.attributes = make([]uint8, columns*rows)
+	}
+	s.Clear()
+	return s
+}
+
+// Bytes of address space the screen occupies
+func (s *TextScreen) Size() int {
+	return len(s.characters) + len(s.attributes)
+}
+
+func (s *TextScreen) Clear() {
+	for i := range s.characters {
+		s.characters[i] = ' '
+	}
+	for i := range s.attributes {
+		s.attributes[i] = SCREEN_ATTRIBUTE_DEFAULT
+	}
+}
+
+func (s *TextScreen) attribute(cell int) uint8 {
+	if s.attributes == nil {
+		return SCREEN_ATTRIBUTE_DEFAULT
+	}
+	return s.attributes[cell]
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (s *TextScreen) Read(addr uint16) uint8 {
+	offset := int(addr) % s.Size()
+	if offset < len(s.characters) {
+		return s.characters[offset]
+	}
+	return s.attributes[offset-len(s.characters)]
+}
+
+func (s *TextScreen) Write(addr uint16, data uint8) {
+	offset := int(addr) % s.Size()
+	if offset < len(s.characters) {
+		s.characters[offset] = data
+	} else {
+		s.attributes[offset-len(s.characters)] = data
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Text Output
+// ----------------------------------------------------------------------------
+
+// The screen as plain text, one line per row, with codes outside printable
+// ASCII shown as '.' and NUL as a space
+func (s *TextScreen) Text() string {
+	lines := make([]string, s.rows)
+	for row := range s.rows {
+		line := make([]uint8, s.columns)
+		for column := range s.columns {
+			line[column] = screen_char(s.characters[row*s.columns+column])
+		}
+		lines[row] = string(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func screen_char(code uint8) uint8 {
+	switch {
+	case code == 0:
+		return ' '
+	case code < FONT_FIRST || code > FONT_LAST:
+		return '.'
+	}
+	return code
+}
+
+// Draws the screen at the top left of an ANSI terminal, switching colours
+// only where the attributes change
+func (s *TextScreen) RenderANSI(w io.Writer) error {
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for row := range s.rows {
+		current := -1
+		for column := range s.columns {
+			cell := row*s.columns + column
+			if s.attributes != nil && int(s.attributes[cell]) != current {
+				current = int(s.attributes[cell])
+				fmt.Fprintf(&screen, "\x1b[%d;%dm",
+					30+screenANSI[current&0x0F], 40+screenANSI[current>>4])
+			}
+			screen.WriteByte(screen_char(s.characters[cell]))
+		}
+		if s.attributes != nil {
+			screen.WriteString("\x1b[0m")
+		}
+		screen.WriteString("\r\n")
+	}
+	_, err := io.WriteString(w, screen.String())
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// Image Output
+// ----------------------------------------------------------------------------
+
+// The screen drawn with the built-in font, each pixel scale pixels square
+func (s *TextScreen) Image(scale int) *image.Paletted {
+	scale = max(scale, 1)
+	bounds := image.Rect(0, 0,
+		s.columns*SCREEN_CELL_WIDTH*scale, s.rows*SCREEN_CELL_HEIGHT*scale)
+	img := image.NewPaletted(bounds, screenPalette)
+
+	for row := range s.rows {
+		for column := range s.columns {
+			cell := row*s.columns + column
+			attribute := s.attribute(cell)
+			rows := glyph(s.characters[cell])
+			for y := range SCREEN_CELL_HEIGHT * scale {
+				for x := range SCREEN_CELL_WIDTH * scale {
+					gx, gy := x/scale, y/scale
+					index := attribute >> 4
+					if gx < FONT_WIDTH && gy < FONT_HEIGHT &&
+						rows[gy]&(0x10>>gx) != 0 {
+						index = attribute & 0x0F
+					}
+					img.SetColorIndex(
+						column*SCREEN_CELL_WIDTH*scale+x,
+						row*SCREEN_CELL_HEIGHT*scale+y, index)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func (s *TextScreen) WritePNG(w io.Writer, scale int) error {
+	return png.Encode(w, s.Image(scale))
+}
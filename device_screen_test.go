@@ -0,0 +1,72 @@
+package cpu6502
+
+import (
+	"bytes"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// device_screen_test.go
+// Tests the memory-mapped text screen
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+func TestTextScreenGoldenPNG(t *testing.T) {
+
+	screen := NewTextScreen(6, 2, true)
+	for i, c := range []uint8("Hello!") {
+		screen.Write(uint16(i), c)
+	}
+	screen.Write(6, '>')
+	screen.Write(7, 0x01)    // unprintable
+	screen.Write(12+0, 0x1E) // yellow on blue
+	screen.Write(12+5, 0x4F) // white on red
+
+	if text := screen.Text(); text != "Hello!\n>.    " {
+		t.Errorf("text %q", text)
+	}
+	var png bytes.Buffer
+	if err := screen.WritePNG(&png, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "screen.png", png.Bytes())
+
+}
+
+func TestTextScreenRejectsEmpty(t *testing.T) {
+
+	for _, size := range [][2]int{{0, 25}, {80, 0}, {-1, 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%dx%d screen created", size[0], size[1])
+				}
+			}()
+			NewTextScreen(size[0], size[1], false)
+		}()
+	}
+
+}
@@ -0,0 +1,385 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_sdcard.go
+// SD card in SPI mode, bit-banged from port pins
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Commands and Responses
+// ----------------------------------------------------------------------------
+
+const (
+	SD_CMD0   = 0  // GO_IDLE_STATE
+	SD_CMD8   = 8  // SEND_IF_COND
+	SD_CMD12  = 12 // STOP_TRANSMISSION
+	SD_CMD16  = 16 // SET_BLOCKLEN
+	SD_CMD17  = 17 // READ_SINGLE_BLOCK
+	SD_CMD18  = 18 // READ_MULTIPLE_BLOCK
+	SD_CMD24  = 24 // WRITE_BLOCK
+	SD_CMD55  = 55 // APP_CMD
+	SD_CMD58  = 58 // READ_OCR
+	SD_CMD59  = 59 // CRC_ON_OFF
+	SD_ACMD41 = 41 // SD_SEND_OP_COND, after CMD55
+
+	SD_R1_IDLE      = 0b00000001
+	SD_R1_ILLEGAL   = 0b00000100
+	SD_R1_ADDRESS   = 0b00100000
+	SD_R1_PARAMETER = 0b01000000
+
+	SD_TOKEN_DATA     = 0xFE
+	SD_TOKEN_ACCEPTED = 0x05
+	SD_TOKEN_ERROR    = 0x08 // data error token, out of range
+	SD_OCR_HCS        = 0x40000000
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type SDCard struct {
+	storage       BlockStorage
+	spi           bool // CMD0 has been seen with CS low
+	idle          bool
+	app           bool // last command was CMD55
+	op_cond       int  // ACMD41 attempts since CMD0
+	high_capacity bool // block addressing, negotiated by ACMD41
+	command       [6]uint8
+	received      int
+	output        []uint8 // bytes queued to shift out
+	writing       bool    // waiting for or receiving a data block
+	write_lba     uint32
+	block         []uint8
+	reading       bool // streaming blocks for CMD18
+	read_lba      uint32
+	err           error
+
+	// Pin level state
+	clk   uint8
+	mosi  uint8
+	miso  uint8
+	cs    uint8
+	pins  uint8
+	in    uint8
+	out   uint8
+	bits  int
+	level bool // MISO
+}
+
+// SPI lines as pin masks on one port
+type SDCardWiring struct {
+	Port *Port
+	CLK  uint8
+	MOSI uint8
+	MISO uint8
+	CS   uint8
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewSDCard(storage BlockStorage) *SDCard {
+	s := &SDCard{storage: storage}
+	s.Reset()
+	return s
+}
+
+// Wires the card to port pins. The port drives CLK, MOSI and CS and reads
+// MISO; SPI mode 0, so the card samples MOSI on the rising edge of CLK and
+// changes MISO on the falling edge.
+func (s *SDCard) Connect(wiring SDCardWiring) {
+	s.clk, s.mosi, s.miso, s.cs = wiring.CLK, wiring.MOSI, wiring.MISO, wiring.CS
+	s.pins = 0xFF
+	wiring.Port.Connect(s)
+}
+
+// The first error from the backing storage
+func (s *SDCard) Err() error {
+	return s.err
+}
+
+// Power cycles the card back to SD bus mode
+func (s *SDCard) Reset() {
+	s.spi = false
+	s.idle = true
+	s.app = false
+	s.op_cond = 0
+	s.high_capacity = false
+	s.received = 0
+	s.output = nil
+	s.writing = false
+	s.block = nil
+	s.reading = false
+	s.out = 0xFF
+	s.bits = 0
+	s.level = true
+}
+
+// ----------------------------------------------------------------------------
+// Pins
+// ----------------------------------------------------------------------------
+
+func (s *SDCard) PortOutput(pins uint8) {
+	previous := s.pins
+	s.pins = pins
+
+	if pins&s.cs != 0 {
+		// Deselected; a partial byte or command frame is abandoned
+		s.bits = 0
+		s.received = 0
+		s.level = true
+		return
+	}
+	if previous&s.cs != 0 {
+		s.bits = 0
+		s.level = s.out&0x80 != 0
+	}
+
+	rising := pins&s.clk != 0 && previous&s.clk == 0
+	falling := pins&s.clk == 0 && previous&s.clk != 0
+	switch {
+	case rising:
+		s.in = s.in<<1 | b2u8(pins&s.mosi != 0)
+		s.bits++
+	case falling:
+		if s.bits == 8 {
+			s.bits = 0
+			s.out = s.Exchange(s.in)
+		} else if s.bits > 0 {
+			s.out <<= 1
+		}
+		s.level = s.out&0x80 != 0
+	}
+}
+
+func (s *SDCard) PortInput() uint8 {
+	if s.level {
+		return 0xFF
+	}
+	return ^s.miso
+}
+
+func b2u8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ----------------------------------------------------------------------------
+// Byte Protocol
+// ----------------------------------------------------------------------------
+// Exchange takes the byte the host has just sent and returns the byte the
+// card shifts out in the next slot. It can be driven directly by a hardware
+// SPI interface instead of the pins.
+// ----------------------------------------------------------------------------
+
+func (s *SDCard) Exchange(in uint8) uint8 {
+	switch {
+	case s.writing:
+		s.receive_block(in)
+	case s.received > 0 || in&0xC0 == 0x40:
+		s.command[s.received] = in
+		s.received++
+		if s.received == len(s.command) {
+			s.received = 0
+			s.execute()
+		}
+	}
+	return s.next()
+}
+
+func (s *SDCard) next() uint8 {
+	if len(s.output) == 0 && s.reading {
+		s.queue_block(s.read_lba)
+		s.read_lba++
+	}
+	if len(s.output) == 0 {
+		return 0xFF
+	}
+	out := s.output[0]
+	s.output = s.output[1:]
+	return out
+}
+
+func (s *SDCard) respond(data ...uint8) {
+	// One byte of NCR delay before every response
+	s.output = append(append(s.output[:0], 0xFF), data...)
+}
+
+func (s *SDCard) r1() uint8 {
+	if s.idle {
+		return SD_R1_IDLE
+	}
+	return 0
+}
+
+// Block number from a read or write argument, by byte address on standard
+// capacity cards
+func (s *SDCard) lba(arg uint32) (uint32, bool) {
+	if s.high_capacity {
+		return arg, true
+	}
+	return arg / SECTOR_SIZE, arg%SECTOR_SIZE == 0
+}
+
+func (s *SDCard) execute() {
+	index := s.command[0] & 0x3F
+	arg := uint32(s.command[1])<<24 | uint32(s.command[2])<<16 |
+		uint32(s.command[3])<<8 | uint32(s.command[4])
+	app := s.app
+	s.app = false
+
+	if !s.spi {
+		if index == SD_CMD0 {
+			s.spi = true
+			s.idle = true
+			s.op_cond = 0
+			s.respond(SD_R1_IDLE)
+		}
+		return
+	}
+
+	if app {
+		if index == SD_ACMD41 {
+			// Ready on the second attempt, as a real card takes a while
+			s.op_cond++
+			if s.op_cond >= 2 {
+				s.idle = false
+			}
+			s.high_capacity = arg&SD_OCR_HCS != 0
+			s.respond(s.r1())
+			return
+		}
+		s.respond(s.r1() | SD_R1_ILLEGAL)
+		return
+	}
+
+	switch index {
+	case SD_CMD0:
+		s.idle = true
+		s.op_cond = 0
+		s.reading = false
+		s.respond(SD_R1_IDLE)
+	case SD_CMD8:
+		s.respond(s.r1(), 0x00, 0x00, uint8(arg>>8)&0x0F, uint8(arg))
+	case SD_CMD12:
+		s.reading = false
+		s.respond(0xFF, s.r1())
+	case SD_CMD16:
+		if arg != SECTOR_SIZE {
+			s.respond(s.r1() | SD_R1_PARAMETER)
+		} else {
+			s.respond(s.r1())
+		}
+	case SD_CMD17, SD_CMD18:
+		lba, aligned := s.lba(arg)
+		switch {
+		case !aligned:
+			s.respond(s.r1() | SD_R1_ADDRESS)
+		case lba >= s.storage.Sectors():
+			s.respond(s.r1() | SD_R1_PARAMETER)
+		default:
+			s.respond(s.r1())
+			s.queue_block(lba)
+			s.reading = index == SD_CMD18
+			s.read_lba = lba + 1
+		}
+	case SD_CMD24:
+		lba, aligned := s.lba(arg)
+		switch {
+		case !aligned:
+			s.respond(s.r1() | SD_R1_ADDRESS)
+		case lba >= s.storage.Sectors():
+			s.respond(s.r1() | SD_R1_PARAMETER)
+		default:
+			s.respond(s.r1())
+			s.writing = true
+			s.write_lba = lba
+			s.block = make([]uint8, 0, SECTOR_SIZE+3)
+		}
+	case SD_CMD55:
+		s.app = true
+		s.respond(s.r1())
+	case SD_CMD58:
+		ocr := uint8(0x80) // powered up
+		if s.high_capacity {
+			ocr |= 0x40
+		}
+		s.respond(s.r1(), ocr, 0xFF, 0x80, 0x00)
+	case SD_CMD59:
+		s.respond(s.r1())
+	default:
+		s.respond(s.r1() | SD_R1_ILLEGAL)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Data Blocks
+// ----------------------------------------------------------------------------
+
+// A start token, the data and a CRC that nothing checks
+func (s *SDCard) queue_block(lba uint32) {
+	if lba >= s.storage.Sectors() {
+		s.reading = false
+		s.output = append(s.output, SD_TOKEN_ERROR)
+		return
+	}
+	var buffer [SECTOR_SIZE]uint8
+	if err := s.storage.ReadSector(lba, buffer[:]); err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		s.reading = false
+		s.output = append(s.output, 0xFF, SD_TOKEN_ERROR)
+		return
+	}
+	s.output = append(s.output, 0xFF, SD_TOKEN_DATA)
+	s.output = append(s.output, buffer[:]...)
+	s.output = append(s.output, 0xFF, 0xFF)
+}
+
+// The host sends filler until the start token, then the block and its CRC
+func (s *SDCard) receive_block(in uint8) {
+	if len(s.block) == 0 && in != SD_TOKEN_DATA {
+		return
+	}
+	s.block = append(s.block, in)
+	if len(s.block) < SECTOR_SIZE+3 {
+		return
+	}
+
+	s.writing = false
+	response := uint8(SD_TOKEN_ACCEPTED)
+	if err := s.storage.WriteSector(s.write_lba, s.block[1:SECTOR_SIZE+1]); err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		response = 0x0D // write error
+	}
+	s.block = nil
+
+	// Data response, then busy until the write completes
+	s.output = append(s.output[:0], response, 0x00, 0x00)
+}
@@ -0,0 +1,218 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_sdcard_test.go
+// Tests the SD card's SPI command set, by byte and through port pins
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Helpers
+// ----------------------------------------------------------------------------
+
+// Sectors held in memory
+type memoryStorage []uint8
+
+func (m memoryStorage) Sectors() uint32 {
+	return uint32(len(m) / SECTOR_SIZE)
+}
+
+func (m memoryStorage) ReadSector(lba uint32, buffer []uint8) error {
+	copy(buffer, m[lba*SECTOR_SIZE:])
+	return nil
+}
+
+func (m memoryStorage) WriteSector(lba uint32, buffer []uint8) error {
+	copy(m[lba*SECTOR_SIZE:], buffer)
+	return nil
+}
+
+// Sends a command frame and returns the R1 response, or $FF if none came
+// within eight bytes
+func sdCommand(card *SDCard, index uint8, arg uint32) uint8 {
+	frame := []uint8{0x40 | index, uint8(arg >> 24), uint8(arg >> 16), uint8(arg >> 8), uint8(arg), 0x01}
+	for _, data := range frame {
+		card.Exchange(data)
+	}
+	for range 8 {
+		if r1 := card.Exchange(0xFF); r1 != 0xFF {
+			return r1
+		}
+	}
+	return 0xFF
+}
+
+// Clocks out n bytes
+func sdRead(card *SDCard, n int) []uint8 {
+	data := make([]uint8, n)
+	for i := range data {
+		data[i] = card.Exchange(0xFF)
+	}
+	return data
+}
+
+// ----------------------------------------------------------------------------
+// Commands
+// ----------------------------------------------------------------------------
+
+func TestSDCardInitialisation(t *testing.T) {
+
+	card := NewSDCard(make(memoryStorage, 4*SECTOR_SIZE))
+
+	// Nothing but CMD0 is answered in SD bus mode
+	if r1 := sdCommand(card, SD_CMD8, 0x1AA); r1 != 0xFF {
+		t.Errorf("CMD8 answered with %02X before CMD0", r1)
+	}
+	if r1 := sdCommand(card, SD_CMD0, 0); r1 != SD_R1_IDLE {
+		t.Errorf("CMD0 answered %02X", r1)
+	}
+
+	// CMD8 echoes the voltage range and check pattern
+	if r1 := sdCommand(card, SD_CMD8, 0x1AA); r1 != SD_R1_IDLE {
+		t.Errorf("CMD8 answered %02X", r1)
+	}
+	if r7 := sdRead(card, 4); r7[2] != 0x01 || r7[3] != 0xAA {
+		t.Errorf("CMD8 returned % X", r7)
+	}
+
+	// The card leaves the idle state on the second ACMD41
+	for attempt, expected := range []uint8{SD_R1_IDLE, 0x00} {
+		if r1 := sdCommand(card, SD_CMD55, 0); r1 != SD_R1_IDLE {
+			t.Errorf("CMD55 answered %02X", r1)
+		}
+		if r1 := sdCommand(card, SD_ACMD41, SD_OCR_HCS); r1 != expected {
+			t.Errorf("ACMD41 attempt %d answered %02X", attempt+1, r1)
+		}
+	}
+	if r1 := sdCommand(card, SD_CMD58, 0); r1 != 0 {
+		t.Errorf("CMD58 answered %02X", r1)
+	}
+	if ocr := sdRead(card, 4); ocr[0] != 0xC0 {
+		t.Errorf("OCR % X, expected power up and high capacity", ocr)
+	}
+	if r1 := sdCommand(card, 63, 0); r1 != SD_R1_ILLEGAL {
+		t.Errorf("unknown command answered %02X", r1)
+	}
+
+}
+
+func TestSDCardBlocks(t *testing.T) {
+
+	storage := make(memoryStorage, 4*SECTOR_SIZE)
+	storage[2*SECTOR_SIZE] = 0x42
+	card := NewSDCard(storage)
+	sdCommand(card, SD_CMD0, 0)
+	sdCommand(card, SD_CMD55, 0)
+	sdCommand(card, SD_ACMD41, 0)
+	sdCommand(card, SD_CMD55, 0)
+	sdCommand(card, SD_ACMD41, 0) // standard capacity: byte addresses
+
+	// The data token follows after a gap, then the block and its CRC
+	if r1 := sdCommand(card, SD_CMD17, 2*SECTOR_SIZE); r1 != 0 {
+		t.Fatalf("CMD17 answered %02X", r1)
+	}
+	block := sdRead(card, SECTOR_SIZE+3)
+	if block[0] != 0xFF || block[1] != SD_TOKEN_DATA || block[2] != 0x42 {
+		t.Errorf("block starts % X", block[:3])
+	}
+	if r1 := sdCommand(card, SD_CMD17, 2*SECTOR_SIZE+1); r1 != SD_R1_ADDRESS {
+		t.Errorf("misaligned CMD17 answered %02X", r1)
+	}
+	if r1 := sdCommand(card, SD_CMD17, 4*SECTOR_SIZE); r1 != SD_R1_PARAMETER {
+		t.Errorf("CMD17 past the end answered %02X", r1)
+	}
+
+	// Filler, the token, the block and a CRC, then accepted and busy
+	if r1 := sdCommand(card, SD_CMD24, SECTOR_SIZE); r1 != 0 {
+		t.Fatalf("CMD24 answered %02X", r1)
+	}
+	card.Exchange(0xFF)
+	card.Exchange(SD_TOKEN_DATA)
+	for i := range SECTOR_SIZE {
+		card.Exchange(uint8(i))
+	}
+	card.Exchange(0xFF)
+	if response := card.Exchange(0xFF); response&0x1F != SD_TOKEN_ACCEPTED {
+		t.Errorf("data response %02X", response)
+	}
+	if busy := card.Exchange(0xFF); busy != 0x00 {
+		t.Errorf("not busy after the write: %02X", busy)
+	}
+	if storage[SECTOR_SIZE+1] != 1 || storage[2*SECTOR_SIZE-1] != 0xFF {
+		t.Errorf("block not written")
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Pins
+// ----------------------------------------------------------------------------
+
+const (
+	sdCLK  = 0x01
+	sdMOSI = 0x02
+	sdCS   = 0x04
+	sdMISO = 0x80
+)
+
+// Shifts a byte each way in SPI mode 0
+func sdPinExchange(port *Port, data uint8) uint8 {
+	var in uint8
+	for bit := 7; bit >= 0; bit-- {
+		mosi := data >> bit & 0x01 * sdMOSI
+		port.set_output(mosi)
+		port.set_output(mosi | sdCLK)
+		in = in<<1 | b2u8(port.Pins()&sdMISO != 0)
+		port.set_output(mosi)
+	}
+	return in
+}
+
+func TestSDCardDeselectAbandonsCommand(t *testing.T) {
+
+	port := &Port{}
+	card := NewSDCard(make(memoryStorage, SECTOR_SIZE))
+	card.Connect(SDCardWiring{Port: port, CLK: sdCLK, MOSI: sdMOSI, MISO: sdMISO, CS: sdCS})
+	port.set_direction(sdCLK | sdMOSI | sdCS)
+
+	// Half a CMD8, then the card is deselected
+	sdPinExchange(port, 0x48)
+	sdPinExchange(port, 0x00)
+	port.set_output(sdCS)
+	port.set_output(0)
+
+	for _, data := range []uint8{0x40, 0x00, 0x00, 0x00, 0x00, 0x95} {
+		sdPinExchange(port, data)
+	}
+	r1 := uint8(0xFF)
+	for range 8 {
+		if r1 = sdPinExchange(port, 0xFF); r1 != 0xFF {
+			break
+		}
+	}
+	if r1 != SD_R1_IDLE {
+		t.Errorf("CMD0 after a deselect answered %02X", r1)
+	}
+
+}
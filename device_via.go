@@ -0,0 +1,649 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// device_via.go
+// MOS 6522 Versatile Interface Adapter
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Registers
+// ----------------------------------------------------------------------------
+
+const (
+	VIA_ORB    = 0x0
+	VIA_ORA    = 0x1
+	VIA_DDRB   = 0x2
+	VIA_DDRA   = 0x3
+	VIA_T1CL   = 0x4
+	VIA_T1CH   = 0x5
+	VIA_T1LL   = 0x6
+	VIA_T1LH   = 0x7
+	VIA_T2CL   = 0x8
+	VIA_T2CH   = 0x9
+	VIA_SR     = 0xA
+	VIA_ACR    = 0xB
+	VIA_PCR    = 0xC
+	VIA_IFR    = 0xD
+	VIA_IER    = 0xE
+	VIA_ORA_NH = 0xF
+)
+
+// ----------------------------------------------------------------------------
+// Interrupt flags (IFR and IER)
+
+const (
+	VIA_INT_CA2 = 0b00000001
+	VIA_INT_CA1 = 0b00000010
+	VIA_INT_SR  = 0b00000100
+	VIA_INT_CB2 = 0b00001000
+	VIA_INT_CB1 = 0b00010000
+	VIA_INT_T2  = 0b00100000
+	VIA_INT_T1  = 0b01000000
+	VIA_INT_ANY = 0b10000000
+)
+
+// ----------------------------------------------------------------------------
+// Auxiliary and peripheral control registers
+
+const (
+	VIA_ACR_PA_LATCH = 0b00000001
+	VIA_ACR_PB_LATCH = 0b00000010
+	VIA_ACR_SR_MASK  = 0b00011100
+	VIA_ACR_T2_PULSE = 0b00100000
+	VIA_ACR_T1_FREE  = 0b01000000
+	VIA_ACR_T1_PB7   = 0b10000000
+	VIA_ACR_SR_SHIFT = 2
+	VIA_PCR_CB_SHIFT = 4
+	VIA_PB7          = 0b10000000
+)
+
+// Shift register modes (ACR bits 4-2)
+const (
+	VIA_SR_DISABLED = iota
+	VIA_SR_IN_T2
+	VIA_SR_IN_PHI2
+	VIA_SR_IN_CB1
+	VIA_SR_OUT_FREE
+	VIA_SR_OUT_T2
+	VIA_SR_OUT_PHI2
+	VIA_SR_OUT_CB1
+)
+
+// C2 output modes (PCR bits 3-1 and 7-5)
+const (
+	VIA_CTL_HANDSHAKE = 0b100
+	VIA_CTL_PULSE     = 0b101
+	VIA_CTL_LOW       = 0b110
+	VIA_CTL_HIGH      = 0b111
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+// The two halves of the chip (CA1/CA2 with port A, CB1/CB2 with port B) are
+// identical apart from which register access triggers handshaking, so they
+// share a control line structure.
+// ----------------------------------------------------------------------------
+
+type viaControl struct {
+	c1         bool // input level of C1
+	c2         bool // input level of C2
+	c2_out     bool // output level of C2
+	pulse      bool // C2 pulse output in progress
+	flag_c1    uint8
+	flag_c2    uint8
+	on_c2      func(bool)
+	port       *Port
+	latch      uint8
+	latch_mask uint8 // ACR bit enabling the input latch
+}
+
+type VIA struct {
+	port_a     Port
+	port_b     Port
+	ca         viaControl
+	cb         viaControl
+	t1_counter uint16
+	t1_latch   uint16
+	t1_reload  bool
+	t1_armed   bool
+	pb7        bool
+	t2_counter uint16
+	t2_latch   uint8
+	t2_hold    bool
+	t2_armed   bool
+	pb6        bool
+	sr         uint8
+	sr_bits    int
+	sr_active  bool
+	sr_timer   uint64
+	cb1_out    bool
+	acr        uint8
+	pcr        uint8
+	ifr        uint8
+	ier        uint8
+	OnCA2      func(bool) // CA2 output changed
+	OnCB1      func(bool) // CB1 shift clock output changed
+	OnCB2      func(bool) // CB2 output changed
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewVIA() *VIA {
+	v := &VIA{}
+	v.ca = viaControl{
+		flag_c1:    VIA_INT_CA1,
+		flag_c2:    VIA_INT_CA2,
+		port:       &v.port_a,
+		latch_mask: VIA_ACR_PA_LATCH,
+		on_c2: func(level bool) {
+			if v.OnCA2 != nil {
+				v.OnCA2(level)
+			}
+		},
+	}
+	v.cb = viaControl{
+		flag_c1:    VIA_INT_CB1,
+		flag_c2:    VIA_INT_CB2,
+		port:       &v.port_b,
+		latch_mask: VIA_ACR_PB_LATCH,
+		on_c2: func(level bool) {
+			if v.OnCB2 != nil {
+				v.OnCB2(level)
+			}
+		},
+	}
+	v.Reset()
+	return v
+}
+
+func (v *VIA) PortA() *Port {
+	return &v.port_a
+}
+
+func (v *VIA) PortB() *Port {
+	return &v.port_b
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+// ----------------------------------------------------------------------------
+
+func (v *VIA) Reset() {
+	v.port_a.reset()
+	v.port_b.reset()
+	v.ca.c1, v.ca.c2, v.ca.c2_out, v.ca.pulse = true, true, true, false
+	v.cb.c1, v.cb.c2, v.cb.c2_out, v.cb.pulse = true, true, true, false
+	v.t1_reload, v.t1_armed, v.pb7 = false, false, true
+	v.t2_hold, v.t2_armed, v.pb6 = false, false, true
+	v.sr_active, v.sr_bits, v.cb1_out = false, 0, true
+	v.acr, v.pcr, v.ifr, v.ier = 0, 0, 0, 0
+}
+
+func (v *VIA) Tick(cycles uint64) {
+	for range cycles {
+		v.step()
+	}
+}
+
+func (v *VIA) NextEvent() uint64 {
+	next := NO_EVENT
+
+	if v.t1_armed || v.acr&VIA_ACR_T1_FREE != 0 {
+		if v.t1_reload {
+			next = min(next, uint64(v.t1_latch)+2)
+		} else {
+			next = min(next, uint64(v.t1_counter)+1)
+		}
+	}
+	if v.t2_armed && v.acr&VIA_ACR_T2_PULSE == 0 {
+		if v.t2_hold {
+			next = min(next, uint64(v.t2_counter)+2)
+		} else {
+			next = min(next, uint64(v.t2_counter)+1)
+		}
+	}
+	if v.sr_timed() {
+		next = min(next, v.sr_timer)
+	}
+	if v.ca.pulse || v.cb.pulse {
+		next = 1
+	}
+
+	return next
+}
+
+func (v *VIA) IRQ() bool {
+	return v.ifr&v.ier&^VIA_INT_ANY != 0
+}
+
+// ----------------------------------------------------------------------------
+// Clocking
+// ----------------------------------------------------------------------------
+
+func (v *VIA) step() {
+
+	// Timer 1 - counts N, N-1 ... 0, FFFF then reloads in free-run mode,
+	// giving a period of N+2 cycles
+	if v.t1_reload {
+		v.t1_counter = v.t1_latch
+		v.t1_reload = false
+	} else {
+		v.t1_counter--
+		if v.t1_counter == 0xFFFF {
+			v.t1_timeout()
+		}
+	}
+
+	// Timer 2 - interval mode only; pulse counting is driven by PB6
+	if v.acr&VIA_ACR_T2_PULSE == 0 {
+		if v.t2_hold {
+			v.t2_hold = false
+		} else {
+			v.t2_counter--
+			if v.t2_counter == 0xFFFF && v.t2_armed {
+				v.t2_armed = false
+				v.set_flag(VIA_INT_T2)
+			}
+		}
+	}
+
+	// Shift register under T2 or system clock control
+	if v.sr_timed() {
+		v.sr_timer--
+		if v.sr_timer == 0 {
+			v.sr_timer = v.sr_period()
+			v.cb1_out = !v.cb1_out
+			if v.OnCB1 != nil {
+				v.OnCB1(v.cb1_out)
+			}
+			v.sr_edge(v.cb1_out)
+		}
+	}
+
+	// End any C2 pulse outputs
+	v.end_pulse(&v.ca)
+	v.end_pulse(&v.cb)
+
+}
+
+func (v *VIA) t1_timeout() {
+	if v.acr&VIA_ACR_T1_FREE != 0 {
+		v.set_flag(VIA_INT_T1)
+		v.t1_reload = true
+		v.pb7 = !v.pb7
+	} else if v.t1_armed {
+		v.t1_armed = false
+		v.set_flag(VIA_INT_T1)
+		v.pb7 = true
+	}
+	v.update_pb7()
+}
+
+func (v *VIA) update_pb7() {
+	if v.acr&VIA_ACR_T1_PB7 == 0 {
+		v.port_b.force(0, 0)
+	} else if v.pb7 {
+		v.port_b.force(VIA_PB7, VIA_PB7)
+	} else {
+		v.port_b.force(VIA_PB7, 0)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Shift Register
+// ----------------------------------------------------------------------------
+// Data shifts out MSB first onto CB2, recirculating into bit 0, and shifts in
+// from CB2 into bit 0. CB1 carries the shift clock: output bits change on its
+// falling edge and input bits are sampled on its rising edge. Each bit takes
+// two T2 timeouts (N+2 cycles each) or two system clocks.
+// ----------------------------------------------------------------------------
+
+func (v *VIA) sr_mode() uint8 {
+	return (v.acr & VIA_ACR_SR_MASK) >> VIA_ACR_SR_SHIFT
+}
+
+func (v *VIA) sr_timed() bool {
+	if !v.sr_active {
+		return false
+	}
+	switch v.sr_mode() {
+	case VIA_SR_IN_T2, VIA_SR_IN_PHI2, VIA_SR_OUT_FREE, VIA_SR_OUT_T2, VIA_SR_OUT_PHI2:
+		return true
+	}
+	return false
+}
+
+func (v *VIA) sr_period() uint64 {
+	switch v.sr_mode() {
+	case VIA_SR_IN_PHI2, VIA_SR_OUT_PHI2:
+		return 1
+	default:
+		return uint64(v.t2_latch) + 2
+	}
+}
+
+func (v *VIA) sr_start() {
+	v.clear_flag(VIA_INT_SR)
+	v.sr_bits = 0
+	v.sr_active = v.sr_mode() != VIA_SR_DISABLED
+	v.sr_timer = v.sr_period()
+}
+
+func (v *VIA) sr_edge(rising bool) {
+	mode := v.sr_mode()
+	out := mode >= VIA_SR_OUT_FREE
+
+	if !rising {
+		if out {
+			bit := v.sr >> 7
+			v.sr = v.sr<<1 | bit
+			v.set_c2_out(&v.cb, bit == 1)
+		}
+		return
+	}
+
+	if !out {
+		var bit uint8
+		if v.cb.c2 {
+			bit = 1
+		}
+		v.sr = v.sr<<1 | bit
+	}
+
+	v.sr_bits++
+	if v.sr_bits == 8 {
+		v.sr_bits = 0
+		if mode != VIA_SR_OUT_FREE {
+			v.sr_active = false
+			v.set_flag(VIA_INT_SR)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Interrupt Flags
+// ----------------------------------------------------------------------------
+
+func (v *VIA) set_flag(flag uint8) {
+	v.ifr |= flag
+}
+
+func (v *VIA) clear_flag(flag uint8) {
+	v.ifr &^= flag
+}
+
+// ----------------------------------------------------------------------------
+// Control Lines
+// ----------------------------------------------------------------------------
+// Each half of the PCR holds the C1 active edge in bit 0 and the C2 mode in
+// bits 3-1. Modes 0-3 make C2 an input (bit 1 selects the active edge and
+// bit 0 makes it independent of port accesses); modes 4-7 make it an output.
+// ----------------------------------------------------------------------------
+
+func (v *VIA) control_pcr(ctl *viaControl) uint8 {
+	if ctl == &v.ca {
+		return v.pcr & 0x0F
+	}
+	return v.pcr >> VIA_PCR_CB_SHIFT
+}
+
+func (v *VIA) set_c2_out(ctl *viaControl, level bool) {
+	if ctl.c2_out != level {
+		ctl.c2_out = level
+		ctl.on_c2(level)
+	}
+}
+
+func (v *VIA) end_pulse(ctl *viaControl) {
+	if ctl.pulse {
+		ctl.pulse = false
+		v.set_c2_out(ctl, true)
+	}
+}
+
+func (v *VIA) set_c1(ctl *viaControl, level bool) {
+	if ctl.c1 == level {
+		return
+	}
+	ctl.c1 = level
+	pcr := v.control_pcr(ctl)
+	if level != (pcr&1 != 0) {
+		return
+	}
+
+	// Active edge
+	v.set_flag(ctl.flag_c1)
+	if v.acr&ctl.latch_mask != 0 {
+		ctl.latch = ctl.port.Pins()
+	}
+	if pcr>>1 == VIA_CTL_HANDSHAKE {
+		v.set_c2_out(ctl, true)
+	}
+}
+
+func (v *VIA) set_c2(ctl *viaControl, level bool) {
+	if ctl.c2 == level {
+		return
+	}
+	ctl.c2 = level
+	mode := v.control_pcr(ctl) >> 1
+	if mode&0b100 == 0 && level == (mode&0b010 != 0) {
+		v.set_flag(ctl.flag_c2)
+	}
+}
+
+// A read or write of the port register clears its interrupt flags and
+// drives the handshake and pulse outputs
+func (v *VIA) port_access(ctl *viaControl, write bool) {
+	mode := v.control_pcr(ctl) >> 1
+	v.clear_flag(ctl.flag_c1)
+	if mode&0b101 != 0b001 {
+		v.clear_flag(ctl.flag_c2)
+	}
+
+	// Port B handshakes on writes only
+	if ctl == &v.cb && !write {
+		return
+	}
+	switch mode {
+	case VIA_CTL_HANDSHAKE:
+		v.set_c2_out(ctl, false)
+	case VIA_CTL_PULSE:
+		v.set_c2_out(ctl, false)
+		ctl.pulse = true
+	}
+}
+
+func (v *VIA) update_c2_mode(ctl *viaControl) {
+	switch v.control_pcr(ctl) >> 1 {
+	case VIA_CTL_LOW:
+		v.set_c2_out(ctl, false)
+	case VIA_CTL_HIGH, VIA_CTL_HANDSHAKE, VIA_CTL_PULSE:
+		v.set_c2_out(ctl, true)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// External Inputs
+// ----------------------------------------------------------------------------
+
+func (v *VIA) SetCA1(level bool) {
+	v.set_c1(&v.ca, level)
+}
+
+func (v *VIA) SetCA2(level bool) {
+	v.set_c2(&v.ca, level)
+}
+
+func (v *VIA) SetCB1(level bool) {
+	rising := level && !v.cb.c1
+	changed := level != v.cb.c1
+	v.set_c1(&v.cb, level)
+
+	// Externally clocked shift register
+	mode := v.sr_mode()
+	if changed && v.sr_active && (mode == VIA_SR_IN_CB1 || mode == VIA_SR_OUT_CB1) {
+		v.sr_edge(rising)
+	}
+}
+
+func (v *VIA) SetCB2(level bool) {
+	v.set_c2(&v.cb, level)
+}
+
+// Timer 2 counts falling edges on PB6 in pulse counting mode
+func (v *VIA) SetPB6(level bool) {
+	falling := v.pb6 && !level
+	v.pb6 = level
+	if falling && v.acr&VIA_ACR_T2_PULSE != 0 {
+		v.t2_counter--
+		if v.t2_counter == 0 && v.t2_armed {
+			v.t2_armed = false
+			v.set_flag(VIA_INT_T2)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (v *VIA) read_port(ctl *viaControl) uint8 {
+	port := ctl.port
+	input := port.Pins()
+	if v.acr&ctl.latch_mask != 0 {
+		input = ctl.latch
+	}
+
+	// Port B returns the output register for output pins, port A the pins
+	if ctl == &v.cb {
+		outputs := port.direction | port.override
+		return port.driven()&outputs | input&^outputs
+	}
+	return input
+}
+
+func (v *VIA) Read(addr uint16) uint8 {
+	switch addr & 0x0F {
+	case VIA_ORB:
+		v.port_access(&v.cb, false)
+		return v.read_port(&v.cb)
+	case VIA_ORA:
+		v.port_access(&v.ca, false)
+		return v.read_port(&v.ca)
+	case VIA_DDRB:
+		return v.port_b.direction
+	case VIA_DDRA:
+		return v.port_a.direction
+	case VIA_T1CL:
+		v.clear_flag(VIA_INT_T1)
+		return uint8(v.t1_counter)
+	case VIA_T1CH:
+		return uint8(v.t1_counter >> 8)
+	case VIA_T1LL:
+		return uint8(v.t1_latch)
+	case VIA_T1LH:
+		return uint8(v.t1_latch >> 8)
+	case VIA_T2CL:
+		v.clear_flag(VIA_INT_T2)
+		return uint8(v.t2_counter)
+	case VIA_T2CH:
+		return uint8(v.t2_counter >> 8)
+	case VIA_SR:
+		v.sr_start()
+		return v.sr
+	case VIA_ACR:
+		return v.acr
+	case VIA_PCR:
+		return v.pcr
+	case VIA_IFR:
+		if v.IRQ() {
+			return v.ifr | VIA_INT_ANY
+		}
+		return v.ifr
+	case VIA_IER:
+		return v.ier | VIA_INT_ANY
+	default: // VIA_ORA_NH
+		return v.read_port(&v.ca)
+	}
+}
+
+func (v *VIA) Write(addr uint16, data uint8) {
+	switch addr & 0x0F {
+	case VIA_ORB:
+		v.port_access(&v.cb, true)
+		v.port_b.set_output(data)
+	case VIA_ORA:
+		v.port_access(&v.ca, true)
+		v.port_a.set_output(data)
+	case VIA_DDRB:
+		v.port_b.set_direction(data)
+	case VIA_DDRA:
+		v.port_a.set_direction(data)
+	case VIA_T1CL, VIA_T1LL:
+		v.t1_latch = v.t1_latch&0xFF00 | uint16(data)
+	case VIA_T1CH:
+		v.t1_latch = v.t1_latch&0x00FF | uint16(data)<<8
+		v.clear_flag(VIA_INT_T1)
+		v.t1_reload = true
+		v.t1_armed = true
+		v.pb7 = false
+		v.update_pb7()
+	case VIA_T1LH:
+		v.t1_latch = v.t1_latch&0x00FF | uint16(data)<<8
+		v.clear_flag(VIA_INT_T1)
+	case VIA_T2CL:
+		v.t2_latch = data
+	case VIA_T2CH:
+		v.t2_counter = uint16(data)<<8 | uint16(v.t2_latch)
+		v.clear_flag(VIA_INT_T2)
+		v.t2_hold = true
+		v.t2_armed = true
+	case VIA_SR:
+		v.sr = data
+		v.sr_start()
+	case VIA_ACR:
+		v.acr = data
+		v.update_pb7()
+		if !v.sr_timed() {
+			v.sr_timer = v.sr_period()
+		}
+	case VIA_PCR:
+		v.pcr = data
+		v.update_c2_mode(&v.ca)
+		v.update_c2_mode(&v.cb)
+	case VIA_IFR:
+		v.clear_flag(data &^ VIA_INT_ANY)
+	case VIA_IER:
+		if data&VIA_INT_ANY != 0 {
+			v.ier |= data &^ VIA_INT_ANY
+		} else {
+			v.ier &^= data
+		}
+	default: // VIA_ORA_NH
+		v.port_a.set_output(data)
+	}
+}
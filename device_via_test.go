@@ -0,0 +1,200 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// device_via_test.go
+// Tests the 6522 VIA
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Port Recorder
+// ----------------------------------------------------------------------------
+
+type portRecorder struct {
+	outputs []uint8
+	input   uint8
+}
+
+func (p *portRecorder) PortOutput(pins uint8) {
+	p.outputs = append(p.outputs, pins)
+}
+
+func (p *portRecorder) PortInput() uint8 {
+	return p.input
+}
+
+// ----------------------------------------------------------------------------
+// Timers
+// ----------------------------------------------------------------------------
+
+func TestVIATimer1OneShot(t *testing.T) {
+
+	via := NewVIA()
+	via.Write(VIA_IER, VIA_INT_ANY|VIA_INT_T1)
+	via.Write(VIA_T1CL, 10)
+	via.Write(VIA_T1CH, 0)
+
+	// The flag sets N+2 cycles after the counter is written
+	if next := via.NextEvent(); next != 12 {
+		t.Errorf("expected next event in 12 cycles, got %d", next)
+	}
+	via.Tick(11)
+	if via.IRQ() {
+		t.Errorf("timer 1 fired early")
+	}
+	via.Tick(1)
+	if !via.IRQ() {
+		t.Fatalf("timer 1 did not fire")
+	}
+
+	// Reading the low counter acknowledges; one-shot does not fire again
+	via.Read(VIA_T1CL)
+	via.Tick(0x20000)
+	if via.IRQ() {
+		t.Errorf("one-shot timer fired twice")
+	}
+
+}
+
+func TestVIATimer1FreeRunTogglesPB7(t *testing.T) {
+
+	via := NewVIA()
+	recorder := &portRecorder{input: 0xFF}
+	via.PortB().Connect(recorder)
+	via.Write(VIA_ACR, VIA_ACR_T1_FREE|VIA_ACR_T1_PB7)
+	via.Write(VIA_T1CL, 4)
+	via.Write(VIA_T1CH, 0)
+
+	if via.PortB().Pins()&VIA_PB7 != 0 {
+		t.Errorf("PB7 not driven low on timer start")
+	}
+	for period := range 3 {
+		via.Tick(6)
+		level := via.PortB().Pins()&VIA_PB7 != 0
+		if level != (period%2 == 0) {
+			t.Errorf("PB7 wrong after period %d", period)
+		}
+		if via.Read(VIA_IFR)&VIA_INT_T1 == 0 {
+			t.Errorf("T1 flag not set after period %d", period)
+		}
+		via.Write(VIA_IFR, VIA_INT_T1)
+	}
+
+}
+
+func TestVIATimer2Interval(t *testing.T) {
+
+	via := NewVIA()
+	via.Write(VIA_IER, VIA_INT_ANY|VIA_INT_T2)
+	via.Write(VIA_T2CL, 0x00)
+	via.Write(VIA_T2CH, 0x01)
+	via.Tick(0x101)
+	if via.IRQ() {
+		t.Errorf("timer 2 fired early")
+	}
+	via.Tick(1)
+	if !via.IRQ() {
+		t.Errorf("timer 2 did not fire")
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Shift Register
+// ----------------------------------------------------------------------------
+
+func TestVIAShiftOutUnderPhi2(t *testing.T) {
+
+	via := NewVIA()
+	cb2 := true
+	var shifted uint8
+	var clocks int
+	via.OnCB2 = func(level bool) {
+		cb2 = level
+	}
+	via.OnCB1 = func(level bool) {
+		if level {
+			shifted <<= 1
+			if cb2 {
+				shifted |= 1
+			}
+			clocks++
+		}
+	}
+	via.Write(VIA_ACR, VIA_SR_OUT_PHI2<<VIA_ACR_SR_SHIFT)
+	via.Write(VIA_SR, 0b10100101)
+	via.Tick(16)
+
+	if via.Read(VIA_IFR)&VIA_INT_SR == 0 {
+		t.Errorf("shift register flag not set after 8 bits")
+	}
+	if clocks != 8 || shifted != 0b10100101 {
+		t.Errorf("expected 8 clocks of A5, got %d of %02X", clocks, shifted)
+	}
+	if via.Read(VIA_SR) != 0b10100101 {
+		t.Errorf("shift register did not recirculate")
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Handshaking
+// ----------------------------------------------------------------------------
+
+func TestVIACA1HandshakeAndLatch(t *testing.T) {
+
+	via := NewVIA()
+	keyboard := &portRecorder{input: 0x41}
+	via.PortA().Connect(keyboard)
+	var ca2 []bool
+	via.OnCA2 = func(level bool) {
+		ca2 = append(ca2, level)
+	}
+
+	via.Write(VIA_ACR, VIA_ACR_PA_LATCH)
+	via.Write(VIA_PCR, VIA_CTL_HANDSHAKE<<1)
+	via.Write(VIA_IER, VIA_INT_ANY|VIA_INT_CA1)
+
+	// Data strobed on the falling edge of CA1
+	via.SetCA1(false)
+	keyboard.input = 0x00
+	if !via.IRQ() {
+		t.Fatalf("CA1 edge did not interrupt")
+	}
+	if data := via.Read(VIA_ORA); data != 0x41 {
+		t.Errorf("expected latched 41, got %02X", data)
+	}
+	if via.IRQ() {
+		t.Errorf("reading ORA did not clear CA1")
+	}
+	if len(ca2) != 1 || ca2[0] != false {
+		t.Errorf("CA2 handshake not driven low: %v", ca2)
+	}
+	via.SetCA1(true)
+	via.SetCA1(false)
+	if len(ca2) != 2 || ca2[1] != true {
+		t.Errorf("CA2 handshake not released: %v", ca2)
+	}
+
+}
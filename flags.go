@@ -31,6 +31,7 @@ package cpu6502
 const (
 	FLAG_NEGATIVE = 0b10000000
 	FLAG_OVERFLOW = 0b01000000
+	FLAG_UNUSED   = 0b00100000
 	FLAG_BRK      = 0b00010000
 	FLAG_DECIMAL  = 0b00001000
 	FLAG_IRQ      = 0b00000100
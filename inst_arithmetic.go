@@ -26,27 +26,79 @@ package cpu6502
 // IN THE SOFTWARE.
 // ----------------------------------------------------------------------------
 
+// ----------------------------------------------------------------------------
+// Decimal mode follows the NMOS part: the accumulator and carry are correct
+// for valid BCD, Z comes from the binary sum and N and V from the result
+// before the high digit is adjusted.
+// ----------------------------------------------------------------------------
+
 func (c *CPU) adc(i *InstructionTableEntry) {
 
-	operand := c.load_by_addressing_mode(i.addressingMode)
-	carry := c.get_carry()
-	trial := uint(operand) + uint(c.accumulator) + uint(carry)
-	c.accumulator = c.accumulator + operand + carry
-	c.set_carry(trial > 0xFFFF)
-	c.set_overflow(int8(c.accumulator) > 127 || int8(c.accumulator) < -128)
-	c.set_negative(c.accumulator)
-	c.set_zero(c.accumulator)
+	operand := int(c.load_by_addressing_mode(i.addressingMode))
+	a := int(c.accumulator)
+	carry := int(c.get_carry())
+	sum := a + operand + carry
+
+	if !c.is_set(FLAG_DECIMAL) {
+		c.set_carry(sum > 0xFF)
+		c.set_overflow((a^sum)&(operand^sum)&0x80 != 0)
+		c.accumulator = uint8(sum)
+		c.set_negative(c.accumulator)
+		c.set_zero(c.accumulator)
+		c.program_counter += uint16(i.bytes)
+		return
+	}
+
+	result := a&0x0F + operand&0x0F + carry
+	if result > 0x09 {
+		result += 0x06
+	}
+	if result > 0x0F {
+		result = result&0x0F + a&0xF0 + operand&0xF0 + 0x10
+	} else {
+		result = result&0x0F + a&0xF0 + operand&0xF0
+	}
+	c.set_zero(uint8(sum))
+	c.set_negative(uint8(result))
+	c.set_overflow((a^result)&0x80 != 0 && (a^operand)&0x80 == 0)
+	if result&0x1F0 > 0x90 {
+		result += 0x60
+	}
+	c.set_carry(result&0xFF0 > 0xF0)
+	c.accumulator = uint8(result)
+	c.program_counter += uint16(i.bytes)
 
 }
 
 func (c *CPU) sbc(i *InstructionTableEntry) {
 
-	operand := c.load_by_addressing_mode(i.addressingMode)
-	carry := c.get_carry()
-	trial := int(c.accumulator) - int(operand) - int(carry)
-	c.accumulator = c.accumulator - operand - carry
-	c.set_carry(trial < 0)
-	c.set_overflow(int8(c.accumulator) > 127 || int8(c.accumulator) < -128)
-	c.set_negative(c.accumulator)
-	c.set_zero(c.accumulator)
+	operand := int(c.load_by_addressing_mode(i.addressingMode))
+	a := int(c.accumulator)
+	borrow := 1 - int(c.get_carry())
+	difference := a - operand - borrow
+
+	// The flags are those of the binary subtraction in both modes
+	c.set_carry(difference >= 0)
+	c.set_overflow((a^difference)&0x80 != 0 && (a^operand)&0x80 != 0)
+	c.set_negative(uint8(difference))
+	c.set_zero(uint8(difference))
+
+	if !c.is_set(FLAG_DECIMAL) {
+		c.accumulator = uint8(difference)
+		c.program_counter += uint16(i.bytes)
+		return
+	}
+
+	result := a&0x0F - operand&0x0F - borrow
+	if result&0x10 != 0 {
+		result = (result-0x06)&0x0F | (a&0xF0 - operand&0xF0 - 0x10)
+	} else {
+		result = result&0x0F | (a&0xF0 - operand&0xF0)
+	}
+	if result&0x100 != 0 {
+		result -= 0x60
+	}
+	c.accumulator = uint8(result)
+	c.program_counter += uint16(i.bytes)
+
 }
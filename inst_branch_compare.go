@@ -31,7 +31,7 @@ import "fmt"
 func (c *CPU) jmp(i *InstructionTableEntry) {
 	switch i.addressingMode {
 	case ABSOLUTE:
-		c.program_counter = c.read_absolute_16()
+		c.program_counter = c.read_operand_address()
 	case INDIRECT:
 		c.program_counter = c.read_indirect()
 	default:
@@ -104,26 +104,24 @@ func (c *CPU) bvc(i *InstructionTableEntry) {
 }
 
 func (c *CPU) cmp(i *InstructionTableEntry) {
-	result := c.accumulator - c.load_by_addressing_mode(i.addressingMode)
-	c.set_negative(result)
-	c.set_zero(result)
-	c.set_carry(result > c.accumulator)
-	c.program_counter += uint16(i.bytes)
+	c.compare(c.accumulator, i)
 }
 
 func (c *CPU) cpx(i *InstructionTableEntry) {
-	result := c.x - c.load_by_addressing_mode(i.addressingMode)
-	c.set_negative(result)
-	c.set_zero(result)
-	c.set_carry(result > c.x)
-	c.program_counter += uint16(i.bytes)
+	c.compare(c.x, i)
 }
 
 func (c *CPU) cpy(i *InstructionTableEntry) {
-	result := c.y - c.load_by_addressing_mode(i.addressingMode)
+	c.compare(c.y, i)
+}
+
+// Carry is set when the register is at least the operand, with no borrow
+func (c *CPU) compare(register uint8, i *InstructionTableEntry) {
+	operand := c.load_by_addressing_mode(i.addressingMode)
+	result := register - operand
 	c.set_negative(result)
 	c.set_zero(result)
-	c.set_carry(result > c.y)
+	c.set_carry(register >= operand)
 	c.program_counter += uint16(i.bytes)
 }
 
@@ -27,11 +27,12 @@ package cpu6502
 // ----------------------------------------------------------------------------
 
 func (c *CPU) inc(i *InstructionTableEntry) {
-	value := c.load_by_addressing_mode(i.addressingMode)
-	c.store_by_addressing_mode(i.addressingMode, value+1)
+	value := c.modify_by_addressing_mode(i.addressingMode, func(data uint8) uint8 {
+		return data + 1
+	})
 	c.program_counter = c.program_counter + uint16(i.bytes)
-	c.set_negative(value + 1)
-	c.set_zero(value + 1)
+	c.set_negative(value)
+	c.set_zero(value)
 }
 
 func (c *CPU) inx(i *InstructionTableEntry) {
@@ -50,11 +51,12 @@ func (c *CPU) iny(i *InstructionTableEntry) {
 }
 
 func (c *CPU) dec(i *InstructionTableEntry) {
-	value := c.load_by_addressing_mode(i.addressingMode)
-	c.store_by_addressing_mode(i.addressingMode, value-1)
+	value := c.modify_by_addressing_mode(i.addressingMode, func(data uint8) uint8 {
+		return data - 1
+	})
 	c.program_counter = c.program_counter + uint16(i.bytes)
-	c.set_negative(value - 1)
-	c.set_zero(value - 1)
+	c.set_negative(value)
+	c.set_zero(value)
 
 }
 
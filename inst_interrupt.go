@@ -0,0 +1,53 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// inst_interrupt.go
+// Interrupt Instructions
+// BRK, RTI, SEI, CLI
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// BRK is two bytes long, the second ignored, so the return address pushed
+// skips a byte. B is set in the status pushed, which is how a handler on the
+// shared vector tells it from an IRQ.
+func (c *CPU) brk(i *InstructionTableEntry) {
+	c.push_16(c.program_counter + 2)
+	c.push(c.processor_status | FLAG_BRK | FLAG_UNUSED)
+	c.set(FLAG_IRQ, true)
+	c.program_counter = c.read_vector(VECTOR_IRQ)
+	c.breaks++
+}
+
+func (c *CPU) rti(i *InstructionTableEntry) {
+	c.processor_status = c.pull()&MASK_BRK | FLAG_UNUSED
+	c.program_counter = c.pull_16()
+}
+
+func (c *CPU) sei(i *InstructionTableEntry) {
+	c.set(FLAG_IRQ, true)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) cli(i *InstructionTableEntry) {
+	c.set(FLAG_IRQ, false)
+	c.program_counter += uint16(i.bytes)
+}
@@ -0,0 +1,101 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// inst_register.go
+// Register Transfer and Flag Instructions
+// TAX, TAY, TXA, TYA, TSX, TXS, CLC, SEC, CLV, CLD, SED, NOP
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Transfers
+// ----------------------------------------------------------------------------
+// All but TXS set N and Z from the value transferred.
+// ----------------------------------------------------------------------------
+
+func (c *CPU) transfer(to *uint8, from uint8, i *InstructionTableEntry) {
+	*to = from
+	c.set_negative(from)
+	c.set_zero(from)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) tax(i *InstructionTableEntry) {
+	c.transfer(&c.x, c.accumulator, i)
+}
+
+func (c *CPU) tay(i *InstructionTableEntry) {
+	c.transfer(&c.y, c.accumulator, i)
+}
+
+func (c *CPU) txa(i *InstructionTableEntry) {
+	c.transfer(&c.accumulator, c.x, i)
+}
+
+func (c *CPU) tya(i *InstructionTableEntry) {
+	c.transfer(&c.accumulator, c.y, i)
+}
+
+func (c *CPU) tsx(i *InstructionTableEntry) {
+	c.transfer(&c.x, c.stack_pointer, i)
+}
+
+func (c *CPU) txs(i *InstructionTableEntry) {
+	c.stack_pointer = c.x
+	c.program_counter += uint16(i.bytes)
+}
+
+// ----------------------------------------------------------------------------
+// Flags
+// ----------------------------------------------------------------------------
+
+func (c *CPU) clc(i *InstructionTableEntry) {
+	c.set(FLAG_CARRY, false)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) sec(i *InstructionTableEntry) {
+	c.set(FLAG_CARRY, true)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) clv(i *InstructionTableEntry) {
+	c.set(FLAG_OVERFLOW, false)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) cld(i *InstructionTableEntry) {
+	c.set(FLAG_DECIMAL, false)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) sed(i *InstructionTableEntry) {
+	c.set(FLAG_DECIMAL, true)
+	c.program_counter += uint16(i.bytes)
+}
+
+// ----------------------------------------------------------------------------
+// No Operation
+
+func (c *CPU) nop(i *InstructionTableEntry) {
+	c.program_counter += uint16(i.bytes)
+}
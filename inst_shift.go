@@ -0,0 +1,66 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// inst_shift.go
+// Shift and Rotate Instructions
+// ASL, LSR, ROL, ROR
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+func (c *CPU) asl(i *InstructionTableEntry) {
+	c.shift(i, func(data uint8) (uint8, bool) {
+		return data << 1, data&0x80 != 0
+	})
+}
+
+func (c *CPU) lsr(i *InstructionTableEntry) {
+	c.shift(i, func(data uint8) (uint8, bool) {
+		return data >> 1, data&0x01 != 0
+	})
+}
+
+func (c *CPU) rol(i *InstructionTableEntry) {
+	carry := c.get_carry()
+	c.shift(i, func(data uint8) (uint8, bool) {
+		return data<<1 | carry, data&0x80 != 0
+	})
+}
+
+func (c *CPU) ror(i *InstructionTableEntry) {
+	carry := c.get_carry()
+	c.shift(i, func(data uint8) (uint8, bool) {
+		return data>>1 | carry<<7, data&0x01 != 0
+	})
+}
+
+// Shifts the accumulator or memory, with the bit shifted out in carry
+func (c *CPU) shift(i *InstructionTableEntry, operation func(uint8) (uint8, bool)) {
+	var out bool
+	value := c.modify_by_addressing_mode(i.addressingMode, func(data uint8) uint8 {
+		data, out = operation(data)
+		return data
+	})
+	c.set_carry(out)
+	c.set_negative(value)
+	c.set_zero(value)
+	c.program_counter += uint16(i.bytes)
+}
@@ -0,0 +1,50 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// inst_stack.go
+// Stack Instructions
+// PHA, PLA, PHP, PLP
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+func (c *CPU) pha(i *InstructionTableEntry) {
+	c.push(c.accumulator)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) pla(i *InstructionTableEntry) {
+	c.accumulator = c.pull()
+	c.set_negative(c.accumulator)
+	c.set_zero(c.accumulator)
+	c.program_counter += uint16(i.bytes)
+}
+
+// B and the unused bit exist only in the copy pushed, where both are set
+func (c *CPU) php(i *InstructionTableEntry) {
+	c.push(c.processor_status | FLAG_BRK | FLAG_UNUSED)
+	c.program_counter += uint16(i.bytes)
+}
+
+func (c *CPU) plp(i *InstructionTableEntry) {
+	c.processor_status = c.pull()&MASK_BRK | FLAG_UNUSED
+	c.program_counter += uint16(i.bytes)
+}
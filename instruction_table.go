@@ -3,6 +3,7 @@ package cpu6502
 import (
 	_ "embed"
 	"encoding/csv"
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -66,6 +67,7 @@ const (
 	BVC
 	BVS
 	CLC
+	CLD
 	CLI
 	CLV
 	CMP
@@ -87,7 +89,7 @@ const (
 	NOP
 	ORA
 	PHA
-	PHB
+	PHP
 	PLA
 	PLP
 	ROL
@@ -105,6 +107,7 @@ const (
 	TAY
 	TSX
 	TXA
+	TXS
 	TYA
 	UNDEFINED
 )
@@ -124,6 +127,7 @@ var mnemonic_map = map[string]Instruction{
 	"BVC": BVC,
 	"BVS": BVS,
 	"CLC": CLC,
+	"CLD": CLD,
 	"CLI": CLI,
 	"CLV": CLV,
 	"CMP": CMP,
@@ -145,7 +149,7 @@ var mnemonic_map = map[string]Instruction{
 	"NOP": NOP,
 	"ORA": ORA,
 	"PHA": PHA,
-	"PHB": PHB,
+	"PHP": PHP,
 	"PLA": PLA,
 	"PLP": PLP,
 	"ROL": ROL,
@@ -163,6 +167,7 @@ var mnemonic_map = map[string]Instruction{
 	"TAY": TAY,
 	"TSX": TSX,
 	"TXA": TXA,
+	"TXS": TXS,
 	"TYA": TYA,
 }
 
@@ -183,6 +188,7 @@ const (
 	INDIRECT_X
 	INDIRECT_Y
 	RELATIVE
+	ACCUMULATOR
 )
 
 var addressing_mode_map = map[string]AddressingMode{
@@ -198,6 +204,7 @@ var addressing_mode_map = map[string]AddressingMode{
 	"INDX": INDIRECT_X,
 	"INDY": INDIRECT_Y,
 	"REL":  RELATIVE,
+	"ACC":  ACCUMULATOR,
 }
 
 // ----------------------------------------------------------------------------
@@ -260,11 +267,20 @@ func buildInstructionTable() *[256]InstructionTableEntry {
 			panic(err)
 		}
 
+		instruction, found := mnemonic_map[record[1]]
+		if !found {
+			panic(fmt.Errorf("unknown mnemonic in opcode table: %s", record[1]))
+		}
+		addressingMode, found := addressing_mode_map[record[2]]
+		if !found {
+			panic(fmt.Errorf("unknown addressing mode in opcode table: %s", record[2]))
+		}
+
 		entry := InstructionTableEntry{
 			opcode:         Opcode(opcode),
-			instruction:    mnemonic_map[record[1]],
+			instruction:    instruction,
 			mnemonic:       record[1],
-			addressingMode: addressing_mode_map[record[2]],
+			addressingMode: addressingMode,
 			bytes:          int(bytes),
 			cycles:         int(cycles),
 		}
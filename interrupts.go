@@ -0,0 +1,136 @@
+package cpu6502
+
+import "sort"
+
+// ----------------------------------------------------------------------------
+// interrupts.go
+// Wired-OR interrupt lines and interrupt servicing
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Vectors
+// ----------------------------------------------------------------------------
+
+const (
+	VECTOR_NMI   = 0xFFFA
+	VECTOR_RESET = 0xFFFC
+	VECTOR_IRQ   = 0xFFFE
+)
+
+// ----------------------------------------------------------------------------
+// Interrupt Line
+// ----------------------------------------------------------------------------
+// Models an open-collector line shared by any number of sources. The line is
+// asserted (pulled low) while at least one source holds it. Sources are
+// identified by name so a debugger can tell who is holding the line.
+//
+// Falling edges are counted so that an NMI pulse shorter than an instruction
+// is still seen by the CPU.
+// ----------------------------------------------------------------------------
+
+type InterruptLine struct {
+	sources map[string]struct{}
+	edges   uint64
+}
+
+func NewInterruptLine() *InterruptLine {
+	return &InterruptLine{sources: make(map[string]struct{})}
+}
+
+func (l *InterruptLine) Assert(source string) {
+	if len(l.sources) == 0 {
+		l.edges++
+	}
+	l.sources[source] = struct{}{}
+}
+
+func (l *InterruptLine) Release(source string) {
+	delete(l.sources, source)
+}
+
+func (l *InterruptLine) Set(source string, asserted bool) {
+	if asserted {
+		l.Assert(source)
+	} else {
+		l.Release(source)
+	}
+}
+
+func (l *InterruptLine) Asserted() bool {
+	return len(l.sources) > 0
+}
+
+// Names of the sources currently holding the line, in sorted order
+func (l *InterruptLine) Sources() []string {
+	sources := make([]string, 0, len(l.sources))
+	for source := range l.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// ----------------------------------------------------------------------------
+// CPU Inputs
+// ----------------------------------------------------------------------------
+
+func (cpu *CPU) IRQLine() *InterruptLine {
+	return cpu.irq
+}
+
+func (cpu *CPU) NMILine() *InterruptLine {
+	return cpu.nmi
+}
+
+// ----------------------------------------------------------------------------
+// Servicing
+// ----------------------------------------------------------------------------
+// Called at each instruction boundary. NMI is edge triggered and always
+// taken; IRQ is level triggered and masked by the interrupt disable flag.
+// Returns true if an interrupt sequence was started, and any bus fault it
+// raised.
+// ----------------------------------------------------------------------------
+
+func (cpu *CPU) service_interrupts() (bool, error) {
+	if cpu.nmi.edges != cpu.nmi_edges {
+		cpu.nmi_edges = cpu.nmi.edges
+		return true, cpu.interrupt(VECTOR_NMI)
+	}
+	if cpu.irq.Asserted() && !cpu.is_set(FLAG_IRQ) {
+		return true, cpu.interrupt(VECTOR_IRQ)
+	}
+	return false, nil
+}
+
+func (cpu *CPU) interrupt(vector uint16) error {
+	cpu.push_16(cpu.program_counter)
+	cpu.push((cpu.processor_status & MASK_BRK) | FLAG_UNUSED)
+	cpu.set(FLAG_IRQ, true)
+	cpu.program_counter = cpu.read_vector(vector)
+	cpu.remaining_cycles = 6
+	return cpu.collect_bus()
+}
+
+func (cpu *CPU) read_vector(vector uint16) uint16 {
+	return uint16(cpu.bus.Read(vector)) | uint16(cpu.bus.Read(vector+1))<<8
+}
@@ -0,0 +1,100 @@
+package cpu6502
+
+import (
+	"fmt"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// machine.go
+// A CPU, its memory map and a scheduler assembled into a computer
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+// The machine profiles build on Machine, mapping their memory and attaching
+// their devices before the first Reset.
+// ----------------------------------------------------------------------------
+
+type Machine struct {
+	CPU       *CPU
+	Memory    *MemoryMap
+	Scheduler *Scheduler
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewMachine() *Machine {
+	memory := NewMemoryMap()
+	cpu := NewCPU(memory)
+	return &Machine{
+		CPU:       cpu,
+		Memory:    memory,
+		Scheduler: NewScheduler(cpu),
+	}
+}
+
+// Attaches a device clocked with the CPU and maps it, brought up to date
+// before every access
+func (m *Machine) AttachDevice(name string, start uint16, end uint16, device BusDevice) {
+	m.Scheduler.Attach(name, device, 1, 1)
+	m.Memory.Map(start, end, m.Scheduler.Clocked(device))
+}
+
+// Reads a ROM image, which must be exactly size bytes
+func ReadROM(path string, size int) (ROM, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%s: invalid ROM size %d", path, size)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("%s: ROM image is %d bytes, expected %d", path, len(data), size)
+	}
+	return ROM(data), nil
+}
+
+// ----------------------------------------------------------------------------
+// Execution
+// ----------------------------------------------------------------------------
+
+// Resets every device and then the CPU, which fetches the reset vector
+func (m *Machine) Reset() {
+	m.Scheduler.Reset()
+}
+
+func (m *Machine) Run(cycles uint64) error {
+	return m.Scheduler.Run(cycles)
+}
+
+// Copies data into memory through the bus, so ROM is left untouched
+func (m *Machine) Load(addr uint16, data []uint8) {
+	for i, value := range data {
+		m.Memory.Write(addr+uint16(i), value)
+	}
+}
@@ -0,0 +1,260 @@
+package cpu6502
+
+import (
+	"fmt"
+	"io"
+)
+
+// ----------------------------------------------------------------------------
+// machine_apple1.go
+// Apple-1 with the Woz Monitor
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Memory Map
+// ----------------------------------------------------------------------------
+// $0000-$0FFF 4K RAM, and with 8K a second bank at $E000-$EFFF where Apple
+// BASIC loads. The PIA sits at $D010-$D013 and the 256 byte monitor ROM at
+// $FF00, which also supplies the vectors.
+// ----------------------------------------------------------------------------
+
+const (
+	APPLE1_KBD      = 0xD010
+	APPLE1_KBDCR    = 0xD011
+	APPLE1_DSP      = 0xD012
+	APPLE1_DSPCR    = 0xD013
+	APPLE1_ROM      = 0xFF00
+	APPLE1_ROM_SIZE = 0x100
+	APPLE1_COLUMNS  = 40
+	APPLE1_ROWS     = 24
+)
+
+// Cycles between checks for typed keys
+const apple1KeyboardPoll = 1000
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type Apple1 struct {
+	Machine
+	PIA      *PIA
+	Display  *Apple1Display
+	keyboard *apple1Keyboard
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+// Builds an Apple-1 with 4096 or 8192 bytes of RAM around a monitor ROM,
+// normally the Woz Monitor read with ReadROM(path, APPLE1_ROM_SIZE)
+func NewApple1(monitor ROM, ram int) (*Apple1, error) {
+	if len(monitor) != APPLE1_ROM_SIZE {
+		return nil, fmt.Errorf("monitor ROM is %d bytes, expected %d", len(monitor), APPLE1_ROM_SIZE)
+	}
+	if ram != 4096 && ram != 8192 {
+		return nil, fmt.Errorf("Apple-1 RAM must be 4096 or 8192 bytes, not %d", ram)
+	}
+
+	a := &Apple1{Machine: *NewMachine(), PIA: NewPIA()}
+	a.Memory.Map(0x0000, 0x0FFF, NewRAM(0x1000))
+	if ram == 8192 {
+		a.Memory.Map(0xE000, 0xEFFF, NewRAM(0x1000))
+	}
+	a.Memory.Map(APPLE1_ROM, 0xFFFF, monitor)
+
+	// The PIA's interrupt outputs are not connected
+	a.AttachDevice("pia", APPLE1_KBD, APPLE1_DSPCR, a.PIA)
+	a.Scheduler.Wire(a.PIA, nil)
+
+	a.Display = NewApple1Display()
+	NewPIAOutput(a.PIA, a.Display.Put)
+	a.keyboard = &apple1Keyboard{keyboard: NewPIAKeyboard(a.PIA), pia: a.PIA}
+	a.Scheduler.Attach("keyboard", a.keyboard, 1, 1)
+
+	a.Reset()
+	return a, nil
+}
+
+// Queues keystrokes, delivered one at a time as the monitor reads them
+func (a *Apple1) Type(text string) {
+	for i := range len(text) {
+		a.keyboard.queue = append(a.keyboard.queue, text[i])
+	}
+	a.keyboard.Tick(0)
+	a.Scheduler.Sync()
+}
+
+// Runs a terminal session: keys are read from in and the display is echoed
+// to out as it prints. Connecting again stops reading the last reader, as
+// ACIA.Connect.
+func (a *Apple1) Connect(in io.Reader, out io.Writer) {
+	a.keyboard.connect(in)
+	a.Display.out = out
+	a.Scheduler.Sync()
+}
+
+// ----------------------------------------------------------------------------
+// Keyboard
+// ----------------------------------------------------------------------------
+// The ASCII keyboard has upper case only and holds bit 7 high. Typed keys
+// wait until the last one has been read, which clears the CA1 flag.
+// ----------------------------------------------------------------------------
+
+type apple1Keyboard struct {
+	keyboard *PIAKeyboard
+	pia      *PIA
+	queue    []uint8
+	input    chan uint8
+	stop     chan struct{}
+}
+
+func apple1Key(key uint8) uint8 {
+	switch {
+	case key == '\n':
+		key = '\r'
+	case key == 0x08 || key == 0x7F:
+		key = '_' // the monitor's rubout
+	case key >= 'a' && key <= 'z':
+		key -= 'a' - 'A'
+	}
+	return key | 0x80
+}
+
+func (k *apple1Keyboard) connect(in io.Reader) {
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+	k.input = nil
+	if in != nil {
+		k.input = make(chan uint8, 256)
+		k.stop = make(chan struct{})
+		go pump(in, k.input, k.stop)
+	}
+}
+
+func (k *apple1Keyboard) Reset() {
+}
+
+func (k *apple1Keyboard) Tick(cycles uint64) {
+	if k.pia.a.cr&PIA_CR_C1_FLAG != 0 {
+		return
+	}
+	if len(k.queue) == 0 && k.input != nil {
+		select {
+		case key, ok := <-k.input:
+			if ok {
+				k.queue = append(k.queue, key)
+			} else {
+				k.input = nil
+			}
+		default:
+		}
+	}
+	if len(k.queue) > 0 {
+		k.keyboard.Press(apple1Key(k.queue[0]))
+		k.queue = k.queue[1:]
+	}
+}
+
+func (k *apple1Keyboard) NextEvent() uint64 {
+	if len(k.queue) == 0 && k.input == nil {
+		return NO_EVENT
+	}
+	return apple1KeyboardPoll
+}
+
+func (k *apple1Keyboard) IRQ() bool {
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Display
+// ----------------------------------------------------------------------------
+// A 40x24 terminal that only moves forward. Carriage return starts a new
+// line, other control codes are ignored, and printing past the bottom
+// scrolls the screen up. The character generator has 64 glyphs, so lower
+// case shows as upper case.
+// ----------------------------------------------------------------------------
+
+type Apple1Display struct {
+	screen *TextScreen
+	row    int
+	column int
+	out    io.Writer
+}
+
+func NewApple1Display() *Apple1Display {
+	return &Apple1Display{screen: NewTextScreen(APPLE1_COLUMNS, APPLE1_ROWS, false)}
+}
+
+// The underlying screen, for ANSI or PNG rendering
+func (d *Apple1Display) Screen() *TextScreen {
+	return d.screen
+}
+
+func (d *Apple1Display) Text() string {
+	return d.screen.Text()
+}
+
+func (d *Apple1Display) Put(data uint8) {
+	data &= 0x7F
+	switch {
+	case data == '\r':
+		d.newline()
+		d.echo("\r\n")
+		return
+	case data < 0x20:
+		return
+	case data >= 0x60:
+		data -= 0x20
+	}
+
+	if d.column == APPLE1_COLUMNS {
+		d.newline()
+	}
+	d.screen.characters[d.row*APPLE1_COLUMNS+d.column] = data
+	d.column++
+	d.echo(string(rune(data)))
+}
+
+func (d *Apple1Display) newline() {
+	d.column = 0
+	if d.row < APPLE1_ROWS-1 {
+		d.row++
+		return
+	}
+	characters := d.screen.characters
+	copy(characters, characters[APPLE1_COLUMNS:])
+	for i := len(characters) - APPLE1_COLUMNS; i < len(characters); i++ {
+		characters[i] = ' '
+	}
+}
+
+func (d *Apple1Display) echo(text string) {
+	if d.out != nil {
+		io.WriteString(d.out, text)
+	}
+}
@@ -0,0 +1,137 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// machine_apple1_test.go
+// Tests the Apple-1 running a monitor-style keyboard echo loop
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+// The Woz Monitor's reset and echo paths, less the command parser
+//
+//	FF00 RESET    CLD
+//	              CLI
+//	              LDX #$FF
+//	              TXS
+//	              LDY #$7F     DDR B
+//	              STY DSP
+//	              LDA #$A7     CA1 rising edge, CB2 pulse
+//	              STA KBDCR
+//	              STA DSPCR
+//	FF12 NEXTCHAR LDA KBDCR
+//	              BPL NEXTCHAR
+//	              LDA KBD
+//	              JSR ECHO
+//	              JMP NEXTCHAR
+//	FFEF ECHO     BIT DSP
+//	              BMI ECHO
+//	              STA DSP
+//	              RTS
+var apple1Echo = []uint8{
+	0xD8, 0x58, 0xA2, 0xFF, 0x9A, 0xA0, 0x7F, 0x8C, 0x12, 0xD0, 0xA9, 0xA7,
+	0x8D, 0x11, 0xD0, 0x8D, 0x13, 0xD0, 0xAD, 0x11, 0xD0, 0x10, 0xFB, 0xAD,
+	0x10, 0xD0, 0x20, 0xEF, 0xFF, 0x4C, 0x12, 0xFF,
+}
+
+var apple1EchoSubroutine = []uint8{
+	0x2C, 0x12, 0xD0, 0x30, 0xFB, 0x8D, 0x12, 0xD0, 0x60,
+}
+
+func TestApple1EchoesKeys(t *testing.T) {
+
+	rom := filledROM(APPLE1_ROM_SIZE, 0xEA)
+	copy(rom, apple1Echo)
+	copy(rom[0xEF:], apple1EchoSubroutine)
+	copy(rom[0xFC:], []uint8{0x00, 0xFF})
+
+	a, err := NewApple1(rom, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	a.Connect(nil, &out)
+
+	a.Type("hi\nok")
+	if err := a.Run(20 * apple1KeyboardPoll); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "HI\r\nOK" {
+		t.Errorf("terminal shows %q", out.String())
+	}
+	lines := strings.Split(a.Display.Text(), "\n")
+	if lines[0][:2] != "HI" || lines[1][:2] != "OK" {
+		t.Errorf("screen reads %q, %q", lines[0], lines[1])
+	}
+	if registers := a.CPU.Registers(); registers.SP != 0xFF || registers.P&FLAG_DECIMAL != 0 {
+		t.Errorf("reset left SP=$%02X P=$%02X", registers.SP, registers.P)
+	}
+
+}
+
+func TestApple1ReconnectStopsReader(t *testing.T) {
+
+	rom := filledROM(APPLE1_ROM_SIZE, 0xEA)
+	copy(rom, apple1Echo)
+	copy(rom[0xEF:], apple1EchoSubroutine)
+	copy(rom[0xFC:], []uint8{0x00, 0xFF})
+	a, err := NewApple1(rom, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make(chanReader)
+	a.Connect(first, nil)
+	first <- []uint8("a")
+	var out strings.Builder
+	a.Connect(nil, &out)
+	first.offer("b")
+	if first.offer("c") {
+		t.Errorf("reader still read after reconnecting")
+	}
+
+	// Only the new reader's keys arrive
+	second := make(chanReader)
+	a.Connect(second, &out)
+	second <- []uint8("d")
+	for range 1000 {
+		if err := a.Run(10 * apple1KeyboardPoll); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if out.String() != "D" {
+		t.Errorf("terminal shows %q", out.String())
+	}
+
+}
@@ -0,0 +1,455 @@
+package cpu6502
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ----------------------------------------------------------------------------
+// machine_apple2.go
+// Apple ][+ with a language card: soft switches and the text page
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Memory Map
+// ----------------------------------------------------------------------------
+// 48K of RAM below $C000, the soft switches at $C000-$C0FF and the 12K of
+// Applesoft and Autostart Monitor ROM at $D000, with a 16K language card in
+// slot 0 able to replace it. No other cards are fitted, so slot I/O and
+// slot ROM read as the floating bus. Nothing is drawn but the text page.
+//
+// $C000 keyboard, with bit 7 set while a key waits
+// $C010 clears the keyboard strobe
+// $C050-$C057 TEXT, MIXED, PAGE2 and HIRES, off and on
+// $C061-$C063 push buttons, in bit 7
+// $C064-$C067 paddles, always timed out
+// $C080-$C08F language card
+// ----------------------------------------------------------------------------
+
+const (
+	APPLE2_RAM_SIZE = 0xC000
+	APPLE2_KBD      = 0xC000
+	APPLE2_KBDSTRB  = 0xC010
+	APPLE2_TXTCLR   = 0xC050
+	APPLE2_TXTSET   = 0xC051
+	APPLE2_MIXCLR   = 0xC052
+	APPLE2_MIXSET   = 0xC053
+	APPLE2_LOWSCR   = 0xC054
+	APPLE2_HISCR    = 0xC055
+	APPLE2_LORES    = 0xC056
+	APPLE2_HIRES    = 0xC057
+	APPLE2_BUTTON0  = 0xC061
+	APPLE2_LC       = 0xC080
+	APPLE2_ROM      = 0xD000
+	APPLE2_ROM_SIZE = 0x3000
+	APPLE2_TEXT1    = 0x0400
+	APPLE2_TEXT2    = 0x0800
+	APPLE2_CLOCK    = 1022727
+	APPLE2_COLUMNS  = 40
+	APPLE2_ROWS     = 24
+)
+
+const (
+	apple2KeyboardPoll = 1000  // cycles between checks for typed keys
+	apple2Frame        = 17030 // cycles per video frame, between redraws
+	apple2MixedRows    = 20    // graphics rows above the text in mixed mode
+	apple2Inverse      = 0x70  // attribute for inverse and flashing text
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type Apple2 struct {
+	Machine
+	Buttons  [3]bool
+	ram      RAM
+	rom      ROM
+	card     apple2LanguageCard
+	text     bool
+	mixed    bool
+	page2    bool
+	hires    bool
+	keyboard *apple2Keyboard
+	screen   *TextScreen
+	terminal *apple2Terminal
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+// Builds an Apple ][+ around its ROM, normally read with
+// ReadROM(path, APPLE2_ROM_SIZE)
+func NewApple2(rom ROM) (*Apple2, error) {
+	if len(rom) != APPLE2_ROM_SIZE {
+		return nil, fmt.Errorf("Apple II ROM is %d bytes, expected %d", len(rom), APPLE2_ROM_SIZE)
+	}
+
+	a := &Apple2{
+		Machine:  *NewMachine(),
+		ram:      NewRAM(APPLE2_RAM_SIZE),
+		rom:      rom,
+		keyboard: &apple2Keyboard{},
+		screen:   NewTextScreen(APPLE2_COLUMNS, APPLE2_ROWS, true),
+	}
+	a.Memory.Map(0x0000, APPLE2_RAM_SIZE-1, a.ram)
+	a.Memory.Map(APPLE2_KBD, 0xC0FF, &apple2SoftSwitches{a})
+	a.Memory.Map(APPLE2_ROM, 0xFFFF, &apple2HighMemory{a})
+
+	a.Scheduler.Attach("keyboard", a.keyboard, 1, 1)
+	a.terminal = &apple2Terminal{a: a}
+	a.Scheduler.Attach("terminal", a.terminal, 1, 1)
+
+	a.Reset()
+	return a, nil
+}
+
+// Power-on: text page 1 is shown and the language card reads ROM
+func (a *Apple2) Reset() {
+	a.text = true
+	a.mixed = false
+	a.page2 = false
+	a.hires = false
+	a.card.reset()
+	a.keyboard.latch = 0
+	a.Machine.Reset()
+}
+
+// ----------------------------------------------------------------------------
+// Soft Switches
+// ----------------------------------------------------------------------------
+// Most switches act on any access, read or write. Reads return the floating
+// bus, here the last value on the data bus, apart from the keyboard and the
+// game port inputs.
+// ----------------------------------------------------------------------------
+
+type apple2SoftSwitches struct {
+	a *Apple2
+}
+
+func (s *apple2SoftSwitches) Read(addr uint16) uint8 {
+	a := s.a
+	data := a.Memory.DataBus()
+	switch {
+	case addr < 0x10:
+		data = a.keyboard.latch
+	case addr >= 0x61 && addr <= 0x63:
+		data &= 0x7F
+		if a.Buttons[addr-0x61] {
+			data |= 0x80
+		}
+	case addr >= 0x64 && addr <= 0x67:
+		data &= 0x7F
+	}
+	a.soft_switch(addr, false)
+	return data
+}
+
+func (s *apple2SoftSwitches) Write(addr uint16, data uint8) {
+	s.a.soft_switch(addr, true)
+}
+
+func (a *Apple2) soft_switch(addr uint16, write bool) {
+	switch {
+	case addr>>4 == 0x1:
+		a.keyboard.latch &^= 0x80
+	case addr >= 0x50 && addr <= 0x57:
+		on := addr&0x01 != 0
+		switch addr &^ 0x01 {
+		case 0x50:
+			a.text = on
+		case 0x52:
+			a.mixed = on
+		case 0x54:
+			a.page2 = on
+		case 0x56:
+			a.hires = on
+		}
+	case addr>>4 == 0x8:
+		a.card.access(addr, write)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Language Card
+// ----------------------------------------------------------------------------
+// 16K of RAM over the ROM: two 4K banks at $D000 and 8K at $E000. A0-A1 of
+// the switch select what is read - RAM for $C080 and $C083, ROM for $C081
+// and $C082 - and A3 selects bank 1 at $D000. RAM is write enabled only by
+// two reads in a row from an odd switch; a write there breaks the sequence
+// and any even switch protects the RAM again. The card comes up reading ROM
+// with bank 2 selected and writes enabled.
+// ----------------------------------------------------------------------------
+
+type apple2LanguageCard struct {
+	ram       [0x4000]uint8 // bank 1, bank 2, then $E000-$FFFF
+	read_ram  bool
+	write_ram bool
+	bank1     bool
+	prewrite  bool
+}
+
+func (c *apple2LanguageCard) reset() {
+	c.read_ram = false
+	c.write_ram = true
+	c.bank1 = false
+	c.prewrite = false
+}
+
+func (c *apple2LanguageCard) access(addr uint16, write bool) {
+	c.bank1 = addr&0x08 != 0
+	c.read_ram = addr&0x03 == 0x00 || addr&0x03 == 0x03
+	switch {
+	case addr&0x01 == 0:
+		c.write_ram = false
+		c.prewrite = false
+	case write:
+		c.prewrite = false
+	default:
+		c.write_ram = c.write_ram || c.prewrite
+		c.prewrite = true
+	}
+}
+
+// Offset into the card's RAM for an address from $D000
+func (c *apple2LanguageCard) offset(addr uint16) uint16 {
+	switch {
+	case addr >= 0x1000:
+		return addr + 0x1000
+	case c.bank1:
+		return addr
+	}
+	return addr + 0x1000
+}
+
+type apple2HighMemory struct {
+	a *Apple2
+}
+
+func (h *apple2HighMemory) Read(addr uint16) uint8 {
+	card := &h.a.card
+	if card.read_ram {
+		return card.ram[card.offset(addr)]
+	}
+	return h.a.rom[addr]
+}
+
+func (h *apple2HighMemory) Write(addr uint16, data uint8) {
+	card := &h.a.card
+	if card.write_ram {
+		card.ram[card.offset(addr)] = data
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Keyboard
+// ----------------------------------------------------------------------------
+// Upper case only, with bit 7 set as the strobe. Typed keys wait until the
+// last one has been taken by clearing the strobe.
+// ----------------------------------------------------------------------------
+
+type apple2Keyboard struct {
+	latch uint8
+	queue []uint8
+	input chan uint8
+	stop  chan struct{}
+}
+
+func apple2Key(key uint8) uint8 {
+	switch {
+	case key == '\n':
+		key = '\r'
+	case key == 0x7F:
+		key = 0x08 // left arrow
+	case key >= 'a' && key <= 'z':
+		key -= 'a' - 'A'
+	}
+	return key | 0x80
+}
+
+func (k *apple2Keyboard) connect(in io.Reader) {
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+	k.input = nil
+	if in != nil {
+		k.input = make(chan uint8, 256)
+		k.stop = make(chan struct{})
+		go pump(in, k.input, k.stop)
+	}
+}
+
+func (k *apple2Keyboard) Reset() {
+}
+
+func (k *apple2Keyboard) Tick(cycles uint64) {
+	if k.latch&0x80 != 0 {
+		return
+	}
+	if len(k.queue) == 0 && k.input != nil {
+		select {
+		case key, ok := <-k.input:
+			if ok {
+				k.queue = append(k.queue, key)
+			} else {
+				k.input = nil
+			}
+		default:
+		}
+	}
+	if len(k.queue) > 0 {
+		k.latch = apple2Key(k.queue[0])
+		k.queue = k.queue[1:]
+	}
+}
+
+func (k *apple2Keyboard) NextEvent() uint64 {
+	if len(k.queue) == 0 && k.input == nil {
+		return NO_EVENT
+	}
+	return apple2KeyboardPoll
+}
+
+func (k *apple2Keyboard) IRQ() bool {
+	return false
+}
+
+// Queues keystrokes, delivered one at a time as software clears the strobe
+func (a *Apple2) Type(text string) {
+	for i := range len(text) {
+		a.keyboard.queue = append(a.keyboard.queue, text[i])
+	}
+	a.keyboard.Tick(0)
+	a.Scheduler.Sync()
+}
+
+// ----------------------------------------------------------------------------
+// Text Page
+// ----------------------------------------------------------------------------
+// Rows are interleaved in memory: each 128 bytes holds rows n, n+8 and n+16
+// and 8 bytes the display never reads. Characters with bit 7 set are normal,
+// the rest inverse or flashing, shown as inverse. The character generator
+// has 64 glyphs, so control codes show as @ and the letters.
+// ----------------------------------------------------------------------------
+
+func apple2TextRow(page uint16, row int) uint16 {
+	return page + uint16(row%8)*0x80 + uint16(row/8)*APPLE2_COLUMNS
+}
+
+func apple2Char(code uint8) (uint8, uint8) {
+	attribute := uint8(SCREEN_ATTRIBUTE_DEFAULT)
+	if code < 0x80 {
+		attribute = apple2Inverse
+	}
+	code &= 0x3F
+	if code < 0x20 {
+		code += 0x40
+	}
+	return code, attribute
+}
+
+// The text page on display, page 1 or 2, with the rows showing graphics
+// left blank
+func (a *Apple2) Screen() *TextScreen {
+	page := uint16(APPLE2_TEXT1)
+	if a.page2 {
+		page = APPLE2_TEXT2
+	}
+	first := 0
+	if !a.text {
+		first = APPLE2_ROWS
+		if a.mixed {
+			first = apple2MixedRows
+		}
+	}
+	a.screen.Clear()
+	for row := first; row < APPLE2_ROWS; row++ {
+		base := apple2TextRow(page, row)
+		for column := range APPLE2_COLUMNS {
+			cell := row*APPLE2_COLUMNS + column
+			a.screen.characters[cell], a.screen.attributes[cell] = apple2Char(a.ram[base+uint16(column)])
+		}
+	}
+	return a.screen
+}
+
+func (a *Apple2) ScreenText() string {
+	return a.Screen().Text()
+}
+
+// ----------------------------------------------------------------------------
+// Terminal
+// ----------------------------------------------------------------------------
+// Once connected, the screen is redrawn on an ANSI terminal at the end of
+// any frame in which it changed.
+// ----------------------------------------------------------------------------
+
+type apple2Terminal struct {
+	a     *Apple2
+	out   io.Writer
+	shown []uint8 // characters and attributes last drawn
+}
+
+func (t *apple2Terminal) Reset() {
+	t.shown = nil
+}
+
+func (t *apple2Terminal) Tick(cycles uint64) {
+	if t.out == nil {
+		return
+	}
+	screen := t.a.Screen()
+	cells := append(append([]uint8{}, screen.characters...), screen.attributes...)
+	if bytes.Equal(cells, t.shown) {
+		return
+	}
+	t.shown = cells
+	screen.RenderANSI(t.out)
+}
+
+func (t *apple2Terminal) NextEvent() uint64 {
+	if t.out == nil {
+		return NO_EVENT
+	}
+	return apple2Frame
+}
+
+func (t *apple2Terminal) IRQ() bool {
+	return false
+}
+
+// Runs a terminal session: keys are read from in and the text page is
+// drawn on out. Connecting again stops reading the last reader, as
+// ACIA.Connect.
+func (a *Apple2) Connect(in io.Reader, out io.Writer) {
+	a.keyboard.connect(in)
+	if out != nil {
+		io.WriteString(out, "\x1b[2J")
+	}
+	a.terminal.out = out
+	a.terminal.shown = nil
+	a.Scheduler.Sync()
+}
@@ -0,0 +1,229 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// machine_apple2_test.go
+// Tests the Apple ][+: language card banking, keyboard and text page
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+// ROM of LDA #$A9 that resets to $D000
+func testApple2() (*Apple2, error) {
+	rom := filledROM(APPLE2_ROM_SIZE, 0xA9)
+	rom[len(rom)-4], rom[len(rom)-3] = 0x00, 0xD0
+	return NewApple2(rom)
+}
+
+func TestApple2LanguageCard(t *testing.T) {
+
+	a, err := testApple2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc := a.CPU.Registers().PC; pc != APPLE2_ROM {
+		t.Errorf("reset to $%04X", pc)
+	}
+	read := func(addr uint16) uint8 {
+		return a.Memory.Read(addr)
+	}
+
+	read(0xC080) // read RAM, write protected
+	a.Memory.Write(0xD000, 0x11)
+	if read(0xD000) != 0x00 {
+		t.Errorf("wrote to protected RAM")
+	}
+
+	// A write between the two reads breaks the sequence
+	read(0xC083)
+	a.Memory.Write(0xC083, 0)
+	read(0xC083)
+	a.Memory.Write(0xD000, 0x22)
+	if read(0xD000) != 0x00 {
+		t.Errorf("write enabled without two reads in a row")
+	}
+
+	read(0xC083)
+	a.Memory.Write(0xD000, 0x33) // bank 2
+	a.Memory.Write(0xE000, 0x44)
+	if read(0xD000) != 0x33 || read(0xE000) != 0x44 {
+		t.Errorf("bank 2 read %02X %02X", read(0xD000), read(0xE000))
+	}
+
+	read(0xC08B)
+	read(0xC08B)
+	if read(0xD000) != 0x00 || read(0xE000) != 0x44 {
+		t.Errorf("bank 1 read %02X %02X", read(0xD000), read(0xE000))
+	}
+	a.Memory.Write(0xD000, 0x55)
+
+	// ROM reads with the RAM still write enabled underneath
+	read(0xC089)
+	read(0xC089)
+	a.Memory.Write(0xD000, 0x66)
+	if read(0xD000) != 0xA9 {
+		t.Errorf("ROM not banked in")
+	}
+	read(0xC088)
+	if read(0xD000) != 0x66 {
+		t.Errorf("bank 1 read %02X after writing through ROM", read(0xD000))
+	}
+	read(0xC080)
+	if read(0xD000) != 0x33 {
+		t.Errorf("bank 2 lost")
+	}
+
+}
+
+func TestApple2KeyboardAndTextPage(t *testing.T) {
+
+	a, err := testApple2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Type("ab")
+	if key := a.Memory.Read(APPLE2_KBD); key != 0xC1 {
+		t.Errorf("keyboard read %02X", key)
+	}
+	a.Memory.Read(APPLE2_KBDSTRB)
+	if key := a.Memory.Read(APPLE2_KBD); key != 0x41 {
+		t.Errorf("keyboard read %02X after clearing the strobe", key)
+	}
+	if err := a.Run(apple2KeyboardPoll); err != nil {
+		t.Fatal(err)
+	}
+	if key := a.Memory.Read(APPLE2_KBD); key != 0xC2 {
+		t.Errorf("second key read %02X", key)
+	}
+
+	// HI on the top line, ] and an inverse cursor on row 8, which follows
+	// row 0 in memory, and an OK on the bottom line
+	a.Load(0x0400, []uint8{0xC8, 0xC9})
+	a.Load(0x0428, []uint8{0xDD, 0x20})
+	a.Load(0x07D0, []uint8{0xCF, 0xCB})
+	lines := strings.Split(a.ScreenText(), "\n")
+	if lines[0][:2] != "HI" || lines[8][:2] != "] " || lines[23][:2] != "OK" {
+		t.Errorf("screen reads %q, %q, %q", lines[0], lines[8], lines[23])
+	}
+	if a.Screen().attributes[8*APPLE2_COLUMNS+1] != apple2Inverse {
+		t.Errorf("cursor not inverse")
+	}
+
+	a.Memory.Read(APPLE2_TXTCLR)
+	a.Memory.Read(APPLE2_MIXSET)
+	lines = strings.Split(a.ScreenText(), "\n")
+	if lines[0][:2] != "  " || lines[23][:2] != "OK" {
+		t.Errorf("mixed mode reads %q, %q", lines[0], lines[23])
+	}
+
+}
+
+// An Autostart-style reset that copies typed keys to the top of the screen
+//
+//	FA62 RESET CLD
+//	           LDX #$FF
+//	           TXS
+//	           LDA TXTSET
+//	           LDY #$00
+//	FA6B KEY   LDA KBD
+//	           BPL KEY
+//	           PHA
+//	           BIT KBDSTRB
+//	           PLA
+//	           STA $0400,Y
+//	           INY
+//	           JMP KEY
+var apple2Echo = []uint8{
+	0xD8, 0xA2, 0xFF, 0x9A, 0xAD, 0x51, 0xC0, 0xA0, 0x00, 0xAD, 0x00, 0xC0,
+	0x10, 0xFB, 0x48, 0x2C, 0x10, 0xC0, 0x68, 0x99, 0x00, 0x04, 0xC8, 0x4C,
+	0x6B, 0xFA,
+}
+
+func TestApple2ROMEchoesKeysToTextPage(t *testing.T) {
+
+	rom := filledROM(APPLE2_ROM_SIZE, 0xEA)
+	copy(rom[0xFA62-APPLE2_ROM:], apple2Echo)
+	copy(rom[len(rom)-4:], []uint8{0x62, 0xFA})
+	a, err := NewApple2(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Type("hello")
+	if err := a.Run(10 * apple2KeyboardPoll); err != nil {
+		t.Fatal(err)
+	}
+	if line := strings.Split(a.ScreenText(), "\n")[0]; line[:5] != "HELLO" {
+		t.Errorf("top line reads %q", line)
+	}
+	if key := a.Memory.Read(APPLE2_KBD); key&0x80 != 0 {
+		t.Errorf("strobe still set, keyboard reads %02X", key)
+	}
+	if registers := a.CPU.Registers(); registers.SP != 0xFF && registers.SP != 0xFE {
+		t.Errorf("stack pointer $%02X", registers.SP)
+	}
+
+}
+
+func TestApple2ReconnectStopsReader(t *testing.T) {
+
+	a, err := testApple2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make(chanReader)
+	a.Connect(first, nil)
+	first <- []uint8("a")
+	a.Connect(nil, nil)
+	first.offer("b")
+	if first.offer("c") {
+		t.Errorf("reader still read after reconnecting")
+	}
+
+	// Only the new reader's keys arrive
+	second := make(chanReader)
+	a.Connect(second, nil)
+	second <- []uint8("d")
+	for range 1000 {
+		if err := a.Run(apple2KeyboardPoll); err != nil {
+			t.Fatal(err)
+		}
+		if a.Memory.Read(APPLE2_KBD)&0x80 != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if key := a.Memory.Read(APPLE2_KBD); key != 0xC4 {
+		t.Errorf("keyboard read %02X", key)
+	}
+
+}
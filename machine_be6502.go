@@ -0,0 +1,118 @@
+package cpu6502
+
+import (
+	"fmt"
+	"io"
+)
+
+// ----------------------------------------------------------------------------
+// machine_be6502.go
+// Breadboard 6502 computer with a VIA, an ACIA and a character LCD
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Memory Map
+// ----------------------------------------------------------------------------
+// $0000-$3FFF RAM       $5000-$5FFF 6551 ACIA    $6000-$7FFF 6522 VIA
+// $8000-$FFFF ROM
+//
+// The decoding is the usual three gate design: A15 selects the ROM, and with
+// A15 low A14 selects the I/O instead of the 32K RAM, so only its lower half
+// is reachable. A12 and A13 select the ACIA and VIA, which repeat through
+// their blocks. Nothing answers at $4000-$4FFF.
+//
+// The LCD is a 16x2 HD44780 with its eight data lines on port B of the VIA,
+// and RS, RW and E on PA5, PA6 and PA7.
+// ----------------------------------------------------------------------------
+
+const (
+	BE6502_RAM      = 0x0000
+	BE6502_ACIA     = 0x5000
+	BE6502_VIA      = 0x6000
+	BE6502_ROM      = 0x8000
+	BE6502_ROM_SIZE = 0x8000
+	BE6502_CLOCK    = 1000000
+	BE6502_LCD_RS   = 0b00100000
+	BE6502_LCD_RW   = 0b01000000
+	BE6502_LCD_E    = 0b10000000
+	BE6502_COLUMNS  = 16
+	BE6502_LCD_ROWS = 2
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type BE6502 struct {
+	Machine
+	VIA  *VIA
+	ACIA *ACIA
+	LCD  *HD44780
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+// Builds the machine around a 32K ROM image, as written to the EEPROM, read
+// with ReadROM(path, BE6502_ROM_SIZE). The ROM supplies the vectors.
+func NewBE6502(rom ROM) (*BE6502, error) {
+	if len(rom) != BE6502_ROM_SIZE {
+		return nil, fmt.Errorf("ROM is %d bytes, expected %d", len(rom), BE6502_ROM_SIZE)
+	}
+
+	b := &BE6502{
+		Machine: *NewMachine(),
+		VIA:     NewVIA(),
+		ACIA:    NewACIA(nil, nil),
+		LCD:     NewHD44780(BE6502_COLUMNS, BE6502_LCD_ROWS, BE6502_CLOCK),
+	}
+	b.Memory.Map(BE6502_RAM, 0x3FFF, NewRAM(0x4000))
+	b.Memory.Map(BE6502_ROM, 0xFFFF, rom)
+
+	// Both interrupt outputs are tied to IRQ. The VIA is synced so that the
+	// LCD has caught up before it sees a port change.
+	b.Scheduler.Attach("via", b.VIA, 1, 1)
+	b.Memory.Map(BE6502_VIA, 0x7FFF, b.Scheduler.Synced(b.VIA))
+	b.Scheduler.Attach("acia", b.ACIA, ACIA_CLOCK, BE6502_CLOCK)
+	b.Memory.Map(BE6502_ACIA, 0x5FFF, b.Scheduler.Clocked(b.ACIA))
+
+	b.Scheduler.Attach("lcd", b.LCD, 1, 1)
+	b.LCD.Connect(HD44780Wiring{
+		Data:    b.VIA.PortB(),
+		Control: b.VIA.PortA(),
+		RS:      BE6502_LCD_RS,
+		RW:      BE6502_LCD_RW,
+		E:       BE6502_LCD_E,
+	})
+
+	b.Reset()
+	return b, nil
+}
+
+// Connects the serial port to a terminal: received bytes are read from in
+// and transmitted bytes written to out. Either may be nil.
+func (b *BE6502) Connect(in io.Reader, out io.Writer) {
+	b.ACIA.Connect(in, out)
+	b.Scheduler.Sync()
+}
@@ -0,0 +1,94 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// machine_be6502_test.go
+// Tests the breadboard computer's serial port under ROM code
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+// Echoes each received byte, polling the ACIA
+//
+//	8000 RESET LDX #$FF
+//	           TXS
+//	           CLD
+//	           LDA #$1F     19200 baud, 8N1
+//	           STA ACIA_CTRL
+//	           LDA #$0B     DTR, no interrupts
+//	           STA ACIA_CMD
+//	800E RX    LDA ACIA_STATUS
+//	           AND #$08
+//	           BEQ RX
+//	           LDA ACIA_DATA
+//	           PHA
+//	8019 TX    LDA ACIA_STATUS
+//	           AND #$10
+//	           BEQ TX
+//	           PLA
+//	           STA ACIA_DATA
+//	           JMP RX
+var be6502Echo = []uint8{
+	0xA2, 0xFF, 0x9A, 0xD8, 0xA9, 0x1F, 0x8D, 0x03, 0x50, 0xA9, 0x0B, 0x8D,
+	0x02, 0x50, 0xAD, 0x01, 0x50, 0x29, 0x08, 0xF0, 0xF9, 0xAD, 0x00, 0x50,
+	0x48, 0xAD, 0x01, 0x50, 0x29, 0x10, 0xF0, 0xF9, 0x68, 0x8D, 0x00, 0x50,
+	0x4C, 0x0E, 0x80,
+}
+
+func TestBE6502SerialEcho(t *testing.T) {
+
+	rom := filledROM(BE6502_ROM_SIZE, 0xEA)
+	copy(rom, be6502Echo)
+	copy(rom[BE6502_ROM_SIZE-4:], []uint8{0x00, 0x80})
+	b, err := NewBE6502(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The terminal is read on another goroutine, so give it time to deliver
+	var out strings.Builder
+	b.Connect(strings.NewReader("hello\r"), &out)
+	for range 1000 {
+		if err := b.Run(10000); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() == 6 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if out.String() != "hello\r" {
+		t.Errorf("echoed %q", out.String())
+	}
+	if err := b.ACIA.Err(); err != nil {
+		t.Error(err)
+	}
+
+}
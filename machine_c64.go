@@ -0,0 +1,610 @@
+package cpu6502
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// machine_c64.go
+// Headless Commodore 64 for running PRG files
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Memory Map
+// ----------------------------------------------------------------------------
+// 64K of RAM, with BASIC at $A000, the KERNAL at $E000 and either the
+// character ROM or the I/O area at $D000 banked in over it by LORAM, HIRAM
+// and CHAREN on the 6510's port at $00/$01. Writes to a banked in ROM fall
+// through to the RAM underneath. The cartridge lines are not emulated.
+//
+// $D000-$D3FF VIC-II, registers and raster counter only; nothing is drawn
+// $D400-$D7FF SID, which takes writes and reads as zero
+// $D800-$DBFF colour RAM, four bits wide
+// $DC00-$DCFF CIA 1, keyboard and the system timer, on IRQ
+// $DD00-$DDFF CIA 2, serial bus and VIC bank, on NMI
+// ----------------------------------------------------------------------------
+
+const (
+	C64_BASIC        = 0xA000
+	C64_BASIC_SIZE   = 0x2000
+	C64_CHARGEN      = 0xD000
+	C64_CHARGEN_SIZE = 0x1000
+	C64_KERNAL       = 0xE000
+	C64_KERNAL_SIZE  = 0x2000
+	C64_CLOCK        = 985248 // PAL
+	C64_COLUMNS      = 40
+	C64_ROWS         = 25
+
+	C64_LORAM  = 0b001
+	C64_HIRAM  = 0b010
+	C64_CHAREN = 0b100
+
+	C64_BASIC_START = 0x0801
+	C64_KEYBUF      = 0x0277
+	C64_KEYBUF_SIZE = 10
+	C64_NDX         = 0x00C6 // characters in the keyboard buffer
+)
+
+const (
+	c64Lines        = 312
+	c64LineCycles   = 63
+	c64PortPullups  = 0b00010111 // banking lines and cassette sense
+	c64TypeInterval = 20000      // cycles between keyboard buffer checks
+	c64TokenSYS     = 0x9E
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type C64 struct {
+	Machine
+	CIA1     *CIA
+	CIA2     *CIA
+	Keyboard *C64Keyboard
+	ram      RAM
+	basic    ROM
+	kernal   ROM
+	chargen  ROM
+	port_ddr uint8
+	port     uint8
+	vic      *c64VIC
+	colour   [0x400]uint8
+	cia1     Bus // clocked
+	cia2     Bus
+	vic_bus  Bus
+	typist   *c64Typist
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+// Any of the ROMs may be nil, leaving RAM visible in its place, for programs
+// that never call into it.
+// ----------------------------------------------------------------------------
+
+func NewC64(basic ROM, kernal ROM, chargen ROM) (*C64, error) {
+	sizes := []struct {
+		name string
+		rom  ROM
+		size int
+	}{
+		{"BASIC", basic, C64_BASIC_SIZE},
+		{"KERNAL", kernal, C64_KERNAL_SIZE},
+		{"character", chargen, C64_CHARGEN_SIZE},
+	}
+	for _, s := range sizes {
+		if s.rom != nil && len(s.rom) != s.size {
+			return nil, fmt.Errorf("%s ROM is %d bytes, expected %d", s.name, len(s.rom), s.size)
+		}
+	}
+
+	c := &C64{
+		Machine:  *NewMachine(),
+		CIA1:     NewCIA(),
+		CIA2:     NewCIA(),
+		Keyboard: &C64Keyboard{},
+		ram:      NewRAM(0x10000),
+		basic:    basic,
+		kernal:   kernal,
+		chargen:  chargen,
+		vic:      &c64VIC{},
+	}
+	c.Memory.Map(0x0000, 0xFFFF, c.ram)
+	c.Memory.Map(0x0000, 0x0001, &c64ProcessorPort{c})
+	c.Memory.Map(C64_BASIC, 0xBFFF, &c64Banks{c: c, base: C64_BASIC})
+	c.Memory.Map(C64_CHARGEN, 0xFFFF, &c64Banks{c: c, base: C64_CHARGEN})
+
+	c.Scheduler.Attach("cia1", c.CIA1, 1, 1)
+	c.Scheduler.Attach("cia2", c.CIA2, 1, 1)
+	c.Scheduler.Wire(c.CIA2, c.CPU.NMILine())
+	c.Scheduler.Attach("vic", c.vic, 1, 1)
+	c.cia1 = c.Scheduler.Clocked(c.CIA1)
+	c.cia2 = c.Scheduler.Clocked(c.CIA2)
+	c.vic_bus = c.Scheduler.Clocked(c.vic)
+
+	c.CIA1.PortA().Connect(&c64KeyboardColumns{c.Keyboard})
+	c.CIA1.PortB().Connect(&c64KeyboardRows{c.Keyboard})
+	c.typist = &c64Typist{ram: c.ram}
+	c.Scheduler.Attach("keyboard buffer", c.typist, 1, 1)
+
+	c.Reset()
+	return c, nil
+}
+
+// Power-on: the port's pins float high, banking in BASIC, KERNAL and I/O
+func (c *C64) Reset() {
+	c.port_ddr = 0
+	c.port = 0
+	c.Machine.Reset()
+}
+
+// ----------------------------------------------------------------------------
+// Banking
+// ----------------------------------------------------------------------------
+
+// Levels on the 6510's port pins
+func (c *C64) port_pins() uint8 {
+	return c.port&c.port_ddr | c64PortPullups&^c.port_ddr
+}
+
+type c64ProcessorPort struct {
+	c *C64
+}
+
+func (p *c64ProcessorPort) Read(addr uint16) uint8 {
+	if addr == 0 {
+		return p.c.port_ddr
+	}
+	return p.c.port_pins()
+}
+
+// The VIC sees the RAM underneath the port, so writes land there too
+func (p *c64ProcessorPort) Write(addr uint16, data uint8) {
+	p.c.ram[addr] = data
+	if addr == 0 {
+		p.c.port_ddr = data
+	} else {
+		p.c.port = data
+	}
+}
+
+type c64Banks struct {
+	c    *C64
+	base uint16
+}
+
+func (b *c64Banks) Read(addr uint16) uint8 {
+	c := b.c
+	addr += b.base
+	lines := c.port_pins()
+	switch {
+	case addr >= C64_KERNAL:
+		if lines&C64_HIRAM != 0 && c.kernal != nil {
+			return c.kernal[addr-C64_KERNAL]
+		}
+	case addr >= C64_CHARGEN:
+		if lines&(C64_LORAM|C64_HIRAM) == 0 {
+			break
+		}
+		if lines&C64_CHAREN != 0 {
+			return c.io_read(addr)
+		}
+		if c.chargen != nil {
+			return c.chargen[addr-C64_CHARGEN]
+		}
+	default:
+		if lines&(C64_LORAM|C64_HIRAM) == C64_LORAM|C64_HIRAM && c.basic != nil {
+			return c.basic[addr-C64_BASIC]
+		}
+	}
+	return c.ram[addr]
+}
+
+func (b *c64Banks) Write(addr uint16, data uint8) {
+	c := b.c
+	addr += b.base
+	lines := c.port_pins()
+	if addr >= C64_CHARGEN && addr < C64_KERNAL &&
+		lines&(C64_LORAM|C64_HIRAM) != 0 && lines&C64_CHAREN != 0 {
+		c.io_write(addr, data)
+		return
+	}
+	c.ram[addr] = data
+}
+
+// ----------------------------------------------------------------------------
+// I/O Area
+// ----------------------------------------------------------------------------
+
+func (c *C64) io_read(addr uint16) uint8 {
+	switch {
+	case addr < 0xD400:
+		return c.vic_bus.Read(addr & 0x3F)
+	case addr < 0xD800:
+		return 0
+	case addr < 0xDC00:
+		return c.colour[addr&0x3FF]
+	case addr < 0xDD00:
+		return c.cia1.Read(addr & 0x0F)
+	case addr < 0xDE00:
+		return c.cia2.Read(addr & 0x0F)
+	}
+	return 0xFF // expansion port I/O, nothing fitted
+}
+
+func (c *C64) io_write(addr uint16, data uint8) {
+	switch {
+	case addr < 0xD400:
+		c.vic_bus.Write(addr&0x3F, data)
+	case addr < 0xD800:
+	case addr < 0xDC00:
+		c.colour[addr&0x3FF] = data & 0x0F
+	case addr < 0xDD00:
+		c.cia1.Write(addr&0x0F, data)
+	case addr < 0xDE00:
+		c.cia2.Write(addr&0x0F, data)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// VIC-II
+// ----------------------------------------------------------------------------
+// Just enough for software that configures the chip and waits on the raster:
+// the registers hold what is written and the raster counts PAL lines. There
+// is no display, no raster interrupt and no cycle stealing.
+// ----------------------------------------------------------------------------
+
+type c64VIC struct {
+	registers [0x40]uint8
+	cycle     uint64 // within the frame
+}
+
+func (v *c64VIC) raster() uint16 {
+	return uint16(v.cycle / c64LineCycles)
+}
+
+func (v *c64VIC) Reset() {
+	v.registers = [0x40]uint8{}
+	v.cycle = 0
+}
+
+func (v *c64VIC) Tick(cycles uint64) {
+	v.cycle = (v.cycle + cycles) % (c64Lines * c64LineCycles)
+}
+
+func (v *c64VIC) NextEvent() uint64 {
+	return NO_EVENT
+}
+
+func (v *c64VIC) IRQ() bool {
+	return false
+}
+
+func (v *c64VIC) Read(addr uint16) uint8 {
+	switch {
+	case addr == 0x11:
+		return v.registers[addr]&0x7F | uint8(v.raster()>>8)<<7
+	case addr == 0x12:
+		return uint8(v.raster())
+	case addr == 0x19:
+		return 0x70
+	case addr == 0x1A:
+		return 0xF0 | v.registers[addr]
+	case addr == 0x1E, addr == 0x1F:
+		return 0 // no collisions without a display
+	case addr >= 0x20 && addr <= 0x2E:
+		return 0xF0 | v.registers[addr]
+	case addr >= 0x2F:
+		return 0xFF
+	}
+	return v.registers[addr]
+}
+
+// Writing $D012 sets the raster compare, kept apart from the counter
+func (v *c64VIC) Write(addr uint16, data uint8) {
+	v.registers[addr] = data
+}
+
+// ----------------------------------------------------------------------------
+// Keyboard
+// ----------------------------------------------------------------------------
+// The 8x8 matrix between CIA 1's ports. The KERNAL drives a column low on
+// port A and reads the rows on port B; a pressed key connects the two, so
+// scanning works from either side.
+// ----------------------------------------------------------------------------
+
+type C64Keyboard struct {
+	matrix  [8]uint8 // rows pressed, by column
+	columns uint8    // levels driven on port A
+	rows    uint8    // levels driven on port B
+}
+
+// Holds down the key at a matrix position, as listed in the Programmer's
+// Reference Guide: RETURN is column 0 row 1, A is column 1 row 2
+func (k *C64Keyboard) Press(column int, row int) {
+	k.matrix[column&7] |= 1 << (row & 7)
+}
+
+func (k *C64Keyboard) Release(column int, row int) {
+	k.matrix[column&7] &^= 1 << (row & 7)
+}
+
+type c64KeyboardColumns struct {
+	k *C64Keyboard
+}
+
+func (s *c64KeyboardColumns) PortOutput(pins uint8) {
+	s.k.columns = pins
+}
+
+func (s *c64KeyboardColumns) PortInput() uint8 {
+	pins := uint8(0xFF)
+	for column, rows := range s.k.matrix {
+		if rows&^s.k.rows != 0 {
+			pins &^= 1 << column
+		}
+	}
+	return pins
+}
+
+type c64KeyboardRows struct {
+	k *C64Keyboard
+}
+
+func (s *c64KeyboardRows) PortOutput(pins uint8) {
+	s.k.rows = pins
+}
+
+func (s *c64KeyboardRows) PortInput() uint8 {
+	pins := uint8(0xFF)
+	for column, rows := range s.k.matrix {
+		if s.k.columns&(1<<column) == 0 {
+			pins &^= rows
+		}
+	}
+	return pins
+}
+
+// ----------------------------------------------------------------------------
+// Keyboard Buffer
+// ----------------------------------------------------------------------------
+// Typed text goes straight into the KERNAL's keyboard buffer, ten
+// characters at a time as it empties, rather than through the matrix.
+// ----------------------------------------------------------------------------
+
+type c64Typist struct {
+	ram   RAM
+	queue []uint8
+}
+
+// Letters of either case type as unshifted keys, which print in upper case
+func c64PETSCII(key uint8) uint8 {
+	switch {
+	case key == '\n':
+		return '\r'
+	case key >= 'a' && key <= 'z':
+		return key - 'a' + 'A'
+	}
+	return key
+}
+
+func (t *c64Typist) Reset() {
+	t.queue = nil
+}
+
+func (t *c64Typist) Tick(cycles uint64) {
+	if len(t.queue) == 0 || t.ram[C64_NDX] != 0 {
+		return
+	}
+	n := copy(t.ram[C64_KEYBUF:C64_KEYBUF+C64_KEYBUF_SIZE], t.queue)
+	t.ram[C64_NDX] = uint8(n)
+	t.queue = t.queue[n:]
+}
+
+func (t *c64Typist) NextEvent() uint64 {
+	if len(t.queue) == 0 {
+		return NO_EVENT
+	}
+	return c64TypeInterval
+}
+
+func (t *c64Typist) IRQ() bool {
+	return false
+}
+
+// Queues keystrokes for the KERNAL to read from its buffer
+func (c *C64) Type(text string) {
+	for i := range len(text) {
+		c.typist.queue = append(c.typist.queue, c64PETSCII(text[i]))
+	}
+	c.typist.Tick(0)
+	c.Scheduler.Sync()
+}
+
+// ----------------------------------------------------------------------------
+// Screen
+// ----------------------------------------------------------------------------
+
+// Address of the text screen, from the VIC bank on CIA 2 port A and the
+// video matrix base in $D018
+func (c *C64) screen_base() uint16 {
+	bank := uint16(^c.CIA2.PortA().Pins()&0x03) * 0x4000
+	return bank + uint16(c.vic.registers[0x18]>>4)*0x400
+}
+
+// Screen codes as text, reverse video ignored. Graphics characters have no
+// equivalent and show as '#'.
+func c64ScreenChar(code uint8, lower bool) rune {
+	code &= 0x7F
+	switch {
+	case code == 0x00:
+		return '@'
+	case code <= 0x1A && lower:
+		return rune('a' + code - 1)
+	case code <= 0x1A:
+		return rune('A' + code - 1)
+	case code < 0x20:
+		return []rune("[£]↑←")[code-0x1B]
+	case code < 0x40:
+		return rune(code)
+	case lower && code >= 0x41 && code <= 0x5A:
+		return rune(code)
+	case code == 0x60:
+		return ' '
+	}
+	return '#'
+}
+
+// The text screen as lines without trailing spaces, in the character set
+// selected by $D018
+func (c *C64) ScreenText() string {
+	base := c.screen_base()
+	lower := c.vic.registers[0x18]&0x02 != 0
+	lines := make([]string, C64_ROWS)
+	for row := range C64_ROWS {
+		var line strings.Builder
+		for column := range C64_COLUMNS {
+			code := c.ram[base+uint16(row*C64_COLUMNS+column)]
+			line.WriteRune(c64ScreenChar(code, lower))
+		}
+		lines[row] = strings.TrimRight(line.String(), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ----------------------------------------------------------------------------
+// Programs
+// ----------------------------------------------------------------------------
+
+// The address in a BASIC stub's SYS line, for a PRG that loads at $0801 and
+// starts with one
+func SYSAddress(prg []uint8) (uint16, bool) {
+	if len(prg) < 7 || uint16(prg[0])|uint16(prg[1])<<8 != C64_BASIC_START {
+		return 0, false
+	}
+	// Skip the load address, the link and the line number
+	line := prg[6:]
+	i := 0
+	for i < len(line) && line[i] != 0 && line[i] != c64TokenSYS {
+		i++
+	}
+	if i == len(line) || line[i] != c64TokenSYS {
+		return 0, false
+	}
+	i++
+	for i < len(line) && (line[i] == ' ' || line[i] == '(') {
+		i++
+	}
+	address, digits := 0, 0
+	for ; i < len(line) && line[i] >= '0' && line[i] <= '9'; i++ {
+		address = address*10 + int(line[i]-'0')
+		digits++
+		if address > 0xFFFF {
+			return 0, false
+		}
+	}
+	return uint16(address), digits > 0
+}
+
+// Copies a PRG into RAM at its load address and returns the address. As the
+// KERNAL's LOAD does, a BASIC program also sets the end of program pointers.
+func (c *C64) LoadPRG(prg []uint8) (uint16, error) {
+	if len(prg) < 2 {
+		return 0, fmt.Errorf("PRG is too short for a load address")
+	}
+	addr := uint16(prg[0]) | uint16(prg[1])<<8
+	end := int(addr) + len(prg) - 2
+	if end > 0x10000 {
+		return 0, fmt.Errorf("PRG at $%04X runs past $FFFF", addr)
+	}
+	copy(c.ram[addr:], prg[2:])
+
+	pointers := []uint16{0xAE} // end of load
+	if addr == C64_BASIC_START {
+		pointers = append(pointers, 0x2D, 0x2F, 0x31) // VARTAB, ARYTAB, STREND
+	}
+	for _, pointer := range pointers {
+		c.ram[pointer] = uint8(end)
+		c.ram[pointer+1] = uint8(end >> 8)
+	}
+	return addr, nil
+}
+
+// Loads a PRG and starts it: at the SYS address of a BASIC stub, or at the
+// load address of machine code loaded elsewhere
+func (c *C64) RunPRG(prg []uint8) error {
+	addr, err := c.LoadPRG(prg)
+	if err != nil {
+		return err
+	}
+	if start, ok := SYSAddress(prg); ok {
+		addr = start
+	} else if addr == C64_BASIC_START {
+		return fmt.Errorf("BASIC program has no SYS line to start")
+	}
+	c.Start(addr)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Start Without Booting
+// ----------------------------------------------------------------------------
+// Puts the machine in the state the KERNAL leaves it in when BASIC runs a
+// program - default banking, a clear screen at $0400 in light blue, the
+// keyboard scan set up - and jumps to addr. RAM loaded beforehand is kept.
+// The system timer is not started, so no interrupts arrive unless the
+// program sets them up.
+// ----------------------------------------------------------------------------
+
+func (c *C64) Start(addr uint16) {
+	c.Reset()
+
+	c.Memory.Write(0x0000, 0x2F)
+	c.Memory.Write(0x0001, 0x37)
+	vic := map[uint16]uint8{
+		0xD011: 0x1B, 0xD016: 0xC8, 0xD018: 0x14,
+		0xD020: 0x0E, 0xD021: 0x06,
+	}
+	for addr, data := range vic {
+		c.Memory.Write(addr, data)
+	}
+	c.Memory.Write(0xDC02, 0xFF) // CIA 1 DDRA, keyboard columns
+	c.Memory.Write(0xDC00, 0x7F)
+	c.Memory.Write(0xDD02, 0x3F) // CIA 2 DDRA, VIC bank 0
+	c.Memory.Write(0xDD00, 0x97)
+
+	for i := range C64_COLUMNS * C64_ROWS {
+		c.ram[0x0400+i] = ' '
+		c.colour[i] = 0x0E
+	}
+	c.ram[0x0288] = 0x04 // HIBASE, screen page for the KERNAL editor
+	c.ram[0x0286] = 0x0E // COLOR, cursor colour
+	c.ram[C64_NDX] = 0
+
+	c.Scheduler.Sync()
+	c.CPU.program_counter = addr
+	c.CPU.stack_pointer = 0xFF
+	c.CPU.remaining_cycles = 0
+}
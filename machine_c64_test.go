@@ -0,0 +1,317 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// machine_c64_test.go
+// Tests the headless C64: banking, PRG start, CIA timers and keyboard
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+func filledROM(size int, value uint8) ROM {
+	rom := make(ROM, size)
+	for i := range rom {
+		rom[i] = value
+	}
+	return rom
+}
+
+func TestC64Banking(t *testing.T) {
+
+	c, err := NewC64(filledROM(C64_BASIC_SIZE, 0xBA), filledROM(C64_KERNAL_SIZE, 0xEE),
+		filledROM(C64_CHARGEN_SIZE, 0xCC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Memory.Write(0xA000, 0x11)
+	c.Memory.Write(0xD000, 0x22) // VIC sprite 0 X
+	c.Memory.Write(0xE000, 0x33)
+
+	cases := []struct {
+		port   uint8
+		a000   uint8
+		d000   uint8
+		e000   uint8
+		banked string
+	}{
+		{0x37, 0xBA, 0x22, 0xEE, "BASIC, I/O and KERNAL"},
+		{0x36, 0x11, 0x22, 0xEE, "I/O and KERNAL"},
+		{0x33, 0xBA, 0xCC, 0xEE, "BASIC, character ROM and KERNAL"},
+		{0x34, 0x11, 0x00, 0x33, "all RAM"},
+	}
+	c.Memory.Write(0x0000, 0x2F)
+	for _, test := range cases {
+		c.Memory.Write(0x0001, test.port)
+		got := []uint8{c.Memory.Read(0xA000), c.Memory.Read(0xD000), c.Memory.Read(0xE000)}
+		if got[0] != test.a000 || got[1] != test.d000 || got[2] != test.e000 {
+			t.Errorf("%s: read %02X, expected %02X %02X %02X",
+				test.banked, got, test.a000, test.d000, test.e000)
+		}
+	}
+
+}
+
+func TestC64RunPRG(t *testing.T) {
+
+	c, err := NewC64(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 SYS 2062, then code that prints "HI" by storing screen codes
+	prg := []uint8{0x01, 0x08, 0x0B, 0x08, 0x0A, 0x00, 0x9E, ' ', '2', '0', '6', '2', 0x00, 0x00, 0x00}
+	prg = append(prg, 0xA9, 0x08, 0x8D, 0x00, 0x04, 0xA9, 0x09, 0x8D, 0x01, 0x04)
+	for range 10 {
+		prg = append(prg, 0xA9, 0x00)
+	}
+	if start, ok := SYSAddress(prg); !ok || start != 2062 {
+		t.Fatalf("SYS address %d, %v", start, ok)
+	}
+
+	if err := c.RunPRG(prg); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(30); err != nil {
+		t.Fatal(err)
+	}
+	screen := c.ScreenText()
+	if !strings.HasPrefix(screen, "HI\n") {
+		t.Errorf("screen starts %q", screen[:10])
+	}
+	if end := uint16(c.ram[0x2D]) | uint16(c.ram[0x2E])<<8; end != 0x0801+uint16(len(prg)-2) {
+		t.Errorf("VARTAB is $%04X", end)
+	}
+
+}
+
+func TestC64TimerAndKeyboard(t *testing.T) {
+
+	c, err := NewC64(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start(0x1000)
+	for i := range 0x100 {
+		c.ram[0x1000+i] = 0xA9
+	}
+
+	// Timer A on CIA 1 every 100 cycles, with its interrupt enabled
+	c.Memory.Write(0xDC04, 99)
+	c.Memory.Write(0xDC05, 0)
+	c.Memory.Write(0xDC0D, CIA_ICR_SET|CIA_ICR_TA)
+	c.Memory.Write(0xDC0E, CIA_CR_START|CIA_CR_LOAD)
+	if err := c.Run(99); err != nil {
+		t.Fatal(err)
+	}
+	if c.CPU.IRQLine().Asserted() {
+		t.Errorf("timer fired early")
+	}
+	if err := c.Run(1); err != nil {
+		t.Fatal(err)
+	}
+	if !c.CPU.IRQLine().Asserted() {
+		t.Errorf("timer did not interrupt")
+	}
+	if icr := c.Memory.Read(0xDC0D); icr != CIA_ICR_IRQ|CIA_ICR_TA {
+		t.Errorf("ICR read %02X", icr)
+	}
+	if c.CPU.IRQLine().Asserted() {
+		t.Errorf("reading ICR did not acknowledge")
+	}
+
+	// A in column 1, row 2
+	c.Keyboard.Press(1, 2)
+	c.Memory.Write(0xDC00, 0xFD)
+	if rows := c.Memory.Read(0xDC01); rows != 0xFB {
+		t.Errorf("rows read %02X with column 1 selected", rows)
+	}
+	c.Memory.Write(0xDC00, 0xFE)
+	if rows := c.Memory.Read(0xDC01); rows != 0xFF {
+		t.Errorf("rows read %02X with column 0 selected", rows)
+	}
+
+}
+
+// The KERNAL's reset path, cut down to starting the system timer, and an IRQ
+// handler that saves the registers and tells IRQ from BRK as it does
+//
+//	FCE2 RESET LDX #$FF
+//	           SEI
+//	           TXS
+//	           CLD
+//	           LDA #$7F     mask all CIA 1 interrupts
+//	           STA $DC0D
+//	           LDA #99      timer A every 100 cycles
+//	           STA $DC04
+//	           LDA #$00
+//	           STA $DC05
+//	           STA $02      count of interrupts
+//	           LDA #$81     enable timer A
+//	           STA $DC0D
+//	           LDA #$11     load and start
+//	           STA $DC0E
+//	           LDY #$55
+//	           CLI
+//	FD05 IDLE  JMP IDLE
+//
+//	FF48 IRQ   PHA
+//	           TXA
+//	           PHA
+//	           TYA
+//	           PHA
+//	           TSX
+//	           LDA $0104,X  status pushed by the interrupt
+//	           AND #$10
+//	           BNE DONE
+//	           INC $02
+//	           LDA $DC0D    acknowledge
+//	FF5A DONE  PLA
+//	           TAY
+//	           PLA
+//	           TAX
+//	           PLA
+//	           RTI
+var (
+	c64Reset = []uint8{
+		0xA2, 0xFF, 0x78, 0x9A, 0xD8, 0xA9, 0x7F, 0x8D, 0x0D, 0xDC, 0xA9, 0x63,
+		0x8D, 0x04, 0xDC, 0xA9, 0x00, 0x8D, 0x05, 0xDC, 0x85, 0x02, 0xA9, 0x81,
+		0x8D, 0x0D, 0xDC, 0xA9, 0x11, 0x8D, 0x0E, 0xDC, 0xA0, 0x55, 0x58, 0x4C,
+		0x05, 0xFD,
+	}
+	c64IRQ = []uint8{
+		0x48, 0x8A, 0x48, 0x98, 0x48, 0xBA, 0xBD, 0x04, 0x01, 0x29, 0x10, 0xD0,
+		0x05, 0xE6, 0x02, 0xAD, 0x0D, 0xDC, 0x68, 0xA8, 0x68, 0xAA, 0x68, 0x40,
+	}
+)
+
+func TestC64KernalResetReachesTimerIRQ(t *testing.T) {
+
+	kernal := filledROM(C64_KERNAL_SIZE, 0xEA)
+	copy(kernal[0xFCE2-0xE000:], c64Reset)
+	copy(kernal[0xFF48-0xE000:], c64IRQ)
+	copy(kernal[0xFFFC-0xE000:], []uint8{0xE2, 0xFC, 0x48, 0xFF})
+
+	c, err := NewC64(nil, kernal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc := c.CPU.Registers().PC; pc != 0xFCE2 {
+		t.Fatalf("reset to $%04X", pc)
+	}
+
+	// Run on until the CPU is back in the idle loop after the interrupts
+	if err := c.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	for c.CPU.Registers().PC != 0xFD05 {
+		if _, err := c.CPU.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := c.ram[0x02]; count < 8 || count > 10 {
+		t.Errorf("%d timer interrupts in 1000 cycles", count)
+	}
+	registers := c.CPU.Registers()
+	if registers.SP != 0xFF || registers.X != 0xFF || registers.Y != 0x55 {
+		t.Errorf("registers not restored: %+v", registers)
+	}
+	if registers.P&(FLAG_DECIMAL|FLAG_IRQ) != 0 {
+		t.Errorf("status $%02X in the idle loop", registers.P)
+	}
+
+}
+
+// The screen editor at its simplest: takes keys from the keyboard buffer and
+// prints them until RETURN
+//
+//	C000 START LDY #$00
+//	C002 WAIT  LDA NDX
+//	           BEQ WAIT
+//	           LDA KEYBUF
+//	           PHA
+//	           LDX #$00
+//	C00C SHIFT LDA KEYBUF+1,X
+//	           STA KEYBUF,X
+//	           INX
+//	           CPX #$09
+//	           BNE SHIFT
+//	           DEC NDX
+//	           PLA
+//	           CMP #$0D
+//	           BEQ DONE
+//	           AND #$3F     PETSCII to screen code
+//	           STA $0400,Y
+//	           INY
+//	           JMP WAIT
+//	C027 DONE  JMP DONE
+var c64Editor = []uint8{
+	0xA0, 0x00, 0xA5, 0xC6, 0xF0, 0xFC, 0xAD, 0x77, 0x02, 0x48, 0xA2, 0x00,
+	0xBD, 0x78, 0x02, 0x9D, 0x77, 0x02, 0xE8, 0xE0, 0x09, 0xD0, 0xF5, 0xC6,
+	0xC6, 0x68, 0xC9, 0x0D, 0xF0, 0x09, 0x29, 0x3F, 0x99, 0x00, 0x04, 0xC8,
+	0x4C, 0x02, 0xC0, 0x4C, 0x27, 0xC0,
+}
+
+func TestC64TypesBASICLine(t *testing.T) {
+
+	c, err := NewC64(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(c.ram[0xC000:], c64Editor)
+	c.Start(0xC000)
+
+	// Longer than the buffer, so it goes in as the editor empties it
+	c.Type("10 print \"hello\"\n")
+	if n := c.ram[C64_NDX]; n != C64_KEYBUF_SIZE {
+		t.Errorf("%d characters in the keyboard buffer", n)
+	}
+	if err := c.Run(3 * c64TypeInterval); err != nil {
+		t.Fatal(err)
+	}
+	if pc := c.CPU.Registers().PC; pc != 0xC027 {
+		t.Errorf("editor at $%04X, not finished", pc)
+	}
+	if line := strings.Split(c.ScreenText(), "\n")[0]; line != "10 PRINT \"HELLO\"" {
+		t.Errorf("screen reads %q", line)
+	}
+
+	// The raster counts 63 cycle lines from the reset, with bit 8 in $D011
+	c.Start(0xC027)
+	for _, line := range []uint16{100, 300, 0} {
+		previous := uint16(c.Memory.Read(0xD012)) | uint16(c.Memory.Read(0xD011)>>7)<<8
+		if err := c.Run(uint64((line+c64Lines-previous)%c64Lines) * c64LineCycles); err != nil {
+			t.Fatal(err)
+		}
+		raster := uint16(c.Memory.Read(0xD012)) | uint16(c.Memory.Read(0xD011)>>7)<<8
+		if raster != line {
+			t.Errorf("raster at %d, expected %d", raster, line)
+		}
+	}
+
+}
@@ -0,0 +1,514 @@
+package cpu6502
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// machine_description.go
+// Machines built from JSON board descriptions
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Description Format
+// ----------------------------------------------------------------------------
+// A board is described in JSON, for example:
+//
+//	{
+//	  "cpu": "6502",
+//	  "clock": 1000000,
+//	  "memory": [
+//	    {"type": "ram", "start": "$0000", "end": "$3FFF"},
+//	    {"type": "unmapped", "start": "$4000", "end": "$4FFF", "policy": "fixed", "value": 255},
+//	    {"type": "rom", "start": "$8000", "end": "$FFFF", "image": "rom.bin"}
+//	  ],
+//	  "devices": [
+//	    {"name": "via", "type": "via", "start": "$6000", "end": "$7FFF"},
+//	    {"name": "acia", "type": "acia", "start": "$5000", "end": "$5FFF", "irq": "nmi"},
+//	    {"name": "lcd", "type": "lcd", "columns": 16, "rows": 2, "wiring":
+//	      {"data": "via.b", "control": "via.a", "rs": 32, "rw": 64, "e": 128}}
+//	  ]
+//	}
+//
+// Addresses are numbers, or strings in $ or 0x hex. Image paths are relative
+// to the description file. Regions are mapped in order, memory first, and
+// later ones take precedence where they overlap. The clock defaults to 1MHz
+// and the only CPU is the NMOS "6502".
+//
+// Devices drive IRQ unless "irq" is "nmi" or "none", and are clocked with the
+// CPU unless "clock" gives their own rate; the ACIA defaults to its crystal.
+// A device without "start" is not mapped, for one reached only through port
+// pins, and "end" defaults to the device's own register span. Ports are
+// named by device and letter, as "via.a" or "via.b".
+//
+//	via, pia   6522 VIA, 6821 PIA
+//	riot       6532 RIOT, with its 128 bytes of RAM mapped at "ram" if given
+//	acia       6551 ACIA, connected to a terminal with ACIA.Connect
+//	lcd        HD44780 of "columns" by "rows", default 16x2, with an optional
+//	           "wiring" of data, control, data_shift, four_bit, rs, rw and e
+//	screen     text screen of "columns" by "rows", in "colour" if set
+//	eeprom     28C256 style EEPROM of "size" bytes, default 32K, loaded from
+//	           "image" if given
+//	block      ATA style block device on the disk "image", "copy_on_write"
+//	           to leave the file untouched
+//	ay         AY-3-8910 at "clock" with samples at "rate", default 44.1kHz,
+//	           and an optional "wiring" of data, control, bdir and bc1
+// ----------------------------------------------------------------------------
+
+const (
+	DESCRIPTION_CLOCK = 1000000
+	DESCRIPTION_RATE  = 44100
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type MachineDescription struct {
+	CPU     string              `json:"cpu"`
+	Clock   uint64              `json:"clock"`
+	Memory  []MemoryDescription `json:"memory"`
+	Devices []DeviceDescription `json:"devices"`
+}
+
+type MemoryDescription struct {
+	Type   string  `json:"type"` // ram, rom or unmapped
+	Start  Address `json:"start"`
+	End    Address `json:"end"`
+	Image  string  `json:"image"`
+	Wait   int     `json:"wait"`   // wait states on every access
+	Policy string  `json:"policy"` // open_bus, fixed or error when unmapped
+	Value  uint8   `json:"value"`
+}
+
+type DeviceDescription struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Start       *Address        `json:"start"`
+	End         *Address        `json:"end"`
+	IRQ         string          `json:"irq"`
+	Clock       uint64          `json:"clock"`
+	RAM         *Address        `json:"ram"`
+	Image       string          `json:"image"`
+	CopyOnWrite bool            `json:"copy_on_write"`
+	Size        int             `json:"size"`
+	Columns     int             `json:"columns"`
+	Rows        int             `json:"rows"`
+	Colour      bool            `json:"colour"`
+	Rate        uint64          `json:"rate"`
+	Wiring      *PinDescription `json:"wiring"`
+}
+
+// Port pins, for the devices that are wired rather than mapped
+type PinDescription struct {
+	Data      string `json:"data"`
+	Control   string `json:"control"`
+	DataShift uint   `json:"data_shift"`
+	FourBit   bool   `json:"four_bit"`
+	RS        uint8  `json:"rs"`
+	RW        uint8  `json:"rw"`
+	E         uint8  `json:"e"`
+	BDIR      uint8  `json:"bdir"`
+	BC1       uint8  `json:"bc1"`
+}
+
+// A bus address, written in JSON as a number or a "$8000" or "0x8000" string
+type Address uint16
+
+func (a *Address) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	base := 10
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(text, "$"):
+			text, base = text[1:], 16
+		case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+			text, base = text[2:], 16
+		}
+	}
+	value, err := strconv.ParseUint(text, base, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address %s", data)
+	}
+	*a = Address(value)
+	return nil
+}
+
+type DescribedMachine struct {
+	Machine
+	Clock   uint64
+	Devices map[string]Bus // by name, for type assertion to the device
+	images  []*DiskImage
+	wired   map[string]bool // devices whose ports drive others
+}
+
+// ----------------------------------------------------------------------------
+// Loading
+// ----------------------------------------------------------------------------
+
+// Reads a description and builds the machine, with image paths taken
+// relative to the file. Unknown fields are rejected to catch typing errors.
+func LoadMachine(path string) (*DescribedMachine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var description MachineDescription
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&description); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m, err := description.Build(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Builds the machine, reading images relative to dir, and resets it
+func (d *MachineDescription) Build(dir string) (*DescribedMachine, error) {
+	if d.CPU != "" && d.CPU != "6502" {
+		return nil, fmt.Errorf("unsupported CPU %q", d.CPU)
+	}
+	m := &DescribedMachine{
+		Machine: *NewMachine(),
+		Clock:   d.Clock,
+		Devices: map[string]Bus{},
+		wired:   map[string]bool{},
+	}
+	if m.Clock == 0 {
+		m.Clock = DESCRIPTION_CLOCK
+	}
+
+	for i, region := range d.Memory {
+		if err := m.map_memory(region, dir); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("memory region %d: %w", i, err)
+		}
+	}
+
+	// Every device is created before any is wired, so wiring can refer
+	// forwards, and wired before any is mapped, as that decides how
+	steps := []func(DeviceDescription, string) error{m.create, m.wire, m.map_device}
+	for _, step := range steps {
+		for _, device := range d.Devices {
+			if err := step(device, dir); err != nil {
+				m.Close()
+				return nil, fmt.Errorf("device %s: %w", device.Name, err)
+			}
+		}
+	}
+
+	m.Reset()
+	return m, nil
+}
+
+// Closes the disk images behind any block devices
+func (m *DescribedMachine) Close() error {
+	var first error
+	for _, image := range m.images {
+		if err := image.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	m.images = nil
+	return first
+}
+
+func image_path(dir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// ----------------------------------------------------------------------------
+// Memory
+// ----------------------------------------------------------------------------
+
+func (m *DescribedMachine) map_memory(region MemoryDescription, dir string) error {
+	start, end := uint16(region.Start), uint16(region.End)
+	if end < start {
+		return fmt.Errorf("end $%04X is below start $%04X", end, start)
+	}
+	size := int(end) - int(start) + 1 // at least one byte
+
+	var bus Bus
+	switch region.Type {
+	case "ram":
+		bus = NewRAM(size)
+	case "rom":
+		if region.Image == "" {
+			return fmt.Errorf("ROM has no image")
+		}
+		rom, err := ReadROM(image_path(dir, region.Image), size)
+		if err != nil {
+			return err
+		}
+		bus = rom
+	case "unmapped":
+		policies := map[string]UnmappedPolicy{
+			"":         UNMAPPED_OPEN_BUS,
+			"open_bus": UNMAPPED_OPEN_BUS,
+			"fixed":    UNMAPPED_FIXED,
+			"error":    UNMAPPED_ERROR,
+		}
+		policy, ok := policies[region.Policy]
+		if !ok {
+			return fmt.Errorf("unknown unmapped policy %q", region.Policy)
+		}
+		m.Memory.Unmap(start, end, policy, region.Value)
+		return nil
+	default:
+		return fmt.Errorf("unknown memory type %q", region.Type)
+	}
+	m.Memory.MapWithWaitStates(start, end, bus, region.Wait)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Devices
+// ----------------------------------------------------------------------------
+
+func (m *DescribedMachine) create(d DeviceDescription, dir string) error {
+	if d.Name == "" {
+		return fmt.Errorf("%s device has no name", d.Type)
+	}
+	if _, ok := m.Devices[d.Name]; ok {
+		return fmt.Errorf("name is used twice")
+	}
+	clock := d.Clock
+	if clock == 0 {
+		clock = m.Clock
+	}
+
+	var bus Bus
+	switch d.Type {
+	case "via":
+		bus = NewVIA()
+	case "pia":
+		bus = NewPIA()
+	case "riot":
+		riot := NewRIOT()
+		if d.RAM != nil {
+			if *d.RAM > 0xFFFF-127 {
+				return fmt.Errorf("RAM at $%04X runs past $FFFF", *d.RAM)
+			}
+			m.Memory.Map(uint16(*d.RAM), uint16(*d.RAM)+127, riot.RAM())
+		}
+		bus = riot
+	case "acia":
+		if d.Clock == 0 {
+			clock = ACIA_CLOCK
+		}
+		bus = NewACIA(nil, nil)
+	case "lcd":
+		columns, rows := d.Columns, d.Rows
+		if columns == 0 && rows == 0 {
+			columns, rows = 16, 2
+		}
+		if columns <= 0 || rows <= 0 {
+			return fmt.Errorf("LCD needs columns and rows")
+		}
+		bus = NewHD44780(columns, rows, clock)
+	case "screen":
+		if d.Columns <= 0 || d.Rows <= 0 {
+			return fmt.Errorf("screen needs columns and rows")
+		}
+		bus = NewTextScreen(d.Columns, d.Rows, d.Colour)
+	case "eeprom":
+		size := d.Size
+		if size == 0 {
+			size = 0x8000
+		}
+		if size <= 0 || size&(size-1) != 0 || size > 0x10000 {
+			return fmt.Errorf("EEPROM size %d is not a power of two", size)
+		}
+		// 10ms to write, 150us between page loads
+		eeprom := NewEEPROM(size, clock/100, clock*150/1000000)
+		if d.Image != "" {
+			image, err := ReadROM(image_path(dir, d.Image), size)
+			if err != nil {
+				return err
+			}
+			copy(eeprom.Data(), image)
+		}
+		bus = eeprom
+	case "block":
+		if d.Image == "" {
+			return fmt.Errorf("block device has no image")
+		}
+		image, err := OpenDiskImage(image_path(dir, d.Image), d.CopyOnWrite)
+		if err != nil {
+			return err
+		}
+		m.images = append(m.images, image)
+		bus = NewBlockDevice(image)
+	case "ay":
+		rate := d.Rate
+		if rate == 0 {
+			rate = DESCRIPTION_RATE
+		}
+		bus = NewAY38910(clock, rate)
+	default:
+		return fmt.Errorf("unknown device type %q", d.Type)
+	}
+	m.Devices[d.Name] = bus
+
+	device, ok := bus.(Device)
+	if !ok {
+		return nil
+	}
+	divisor := gcd(clock, m.Clock)
+	m.Scheduler.Attach(d.Name, device, clock/divisor, m.Clock/divisor)
+	switch d.IRQ {
+	case "", "irq":
+	case "nmi":
+		m.Scheduler.Wire(device, m.CPU.NMILine())
+	case "none":
+		m.Scheduler.Wire(device, nil)
+	default:
+		return fmt.Errorf("unknown interrupt line %q", d.IRQ)
+	}
+	return nil
+}
+
+func gcd(a uint64, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Finds a port by "device.a" or "device.b"
+func (m *DescribedMachine) port(name string) (*Port, error) {
+	device, letter, _ := strings.Cut(name, ".")
+	owner, ok := m.Devices[device].(interface {
+		PortA() *Port
+		PortB() *Port
+	})
+	if !ok {
+		return nil, fmt.Errorf("no ports on %q", device)
+	}
+	m.wired[device] = true
+	switch letter {
+	case "a":
+		return owner.PortA(), nil
+	case "b":
+		return owner.PortB(), nil
+	}
+	return nil, fmt.Errorf("no port %q", name)
+}
+
+func (m *DescribedMachine) wire(d DeviceDescription, dir string) error {
+	if d.Wiring == nil {
+		return nil
+	}
+	data, err := m.port(d.Wiring.Data)
+	if err != nil {
+		return err
+	}
+	control, err := m.port(d.Wiring.Control)
+	if err != nil {
+		return err
+	}
+	switch device := m.Devices[d.Name].(type) {
+	case *HD44780:
+		device.Connect(HD44780Wiring{
+			Data:      data,
+			DataShift: d.Wiring.DataShift,
+			FourBit:   d.Wiring.FourBit,
+			Control:   control,
+			RS:        d.Wiring.RS,
+			RW:        d.Wiring.RW,
+			E:         d.Wiring.E,
+		})
+	case *AY38910:
+		device.Connect(AY38910Wiring{
+			Data:    data,
+			Control: control,
+			BDIR:    d.Wiring.BDIR,
+			BC1:     d.Wiring.BC1,
+		})
+	default:
+		return fmt.Errorf("%s cannot be wired to ports", d.Type)
+	}
+	return nil
+}
+
+// Bytes of address space a device decodes, the default size of its region
+func register_span(bus Bus) int {
+	switch device := bus.(type) {
+	case *VIA:
+		return 16
+	case *PIA, *ACIA:
+		return 4
+	case *RIOT:
+		return 32
+	case *HD44780, *AY38910:
+		return 2
+	case *TextScreen:
+		return device.Size()
+	case *EEPROM:
+		return len(device.Data())
+	case *BlockDevice:
+		return 8
+	}
+	return 1
+}
+
+func (m *DescribedMachine) map_device(d DeviceDescription, dir string) error {
+	if d.Start == nil {
+		return nil
+	}
+	bus := m.Devices[d.Name]
+	start := int(*d.Start)
+	end := start + register_span(bus) - 1
+	if d.End != nil {
+		end = int(*d.End)
+	}
+	if end < start || end > 0xFFFF {
+		return fmt.Errorf("invalid region $%04X-$%04X", start, end)
+	}
+
+	// Devices driving others through their ports bring them up to date too
+	if device, ok := bus.(BusDevice); ok {
+		if m.wired[d.Name] {
+			bus = m.Scheduler.Synced(device)
+		} else {
+			bus = m.Scheduler.Clocked(device)
+		}
+	}
+	m.Memory.Map(uint16(start), uint16(end), bus)
+	return nil
+}
@@ -0,0 +1,146 @@
+package cpu6502
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// machine_description_test.go
+// Tests machines built from board descriptions
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+const testBoard = `{
+	"cpu": "6502",
+	"clock": 1000000,
+	"memory": [
+		{"type": "ram", "start": "$0000", "end": "$3FFF"},
+		{"type": "rom", "start": "0x8000", "end": 65535, "image": "rom.bin"}
+	],
+	"devices": [
+		{"name": "via", "type": "via", "start": "$6000", "end": "$7FFF"},
+		{"name": "acia", "type": "acia", "start": "$5000", "irq": "none"},
+		{"name": "lcd", "type": "lcd", "wiring":
+			{"data": "via.b", "control": "via.a", "rs": 32, "rw": 64, "e": 128}}
+	]
+}`
+
+func TestDescribedMachineRuns(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// Writes "Hi" to the LCD with a wait after each transfer, then sends a
+	// byte through the ACIA
+	var program []uint8
+	store := func(data uint8, addr uint16) {
+		program = append(program, 0xA9, data, 0x8D, uint8(addr), uint8(addr>>8))
+	}
+	lcd := func(data uint8, rs uint8) {
+		store(data, 0x6000)
+		store(rs, 0x6001)
+		store(rs|0x80, 0x6001)
+		store(rs, 0x6001)
+		for range 20 {
+			program = append(program, 0xA9, 0x00)
+		}
+	}
+	store(0xFF, 0x6002)
+	store(0xE0, 0x6003)
+	lcd(0x38, 0)
+	lcd(0x0C, 0)
+	lcd(0x06, 0)
+	lcd('H', 0x20)
+	lcd('i', 0x20)
+	store(0x1F, 0x5003)
+	store(0x0B, 0x5002)
+	store('A', 0x5000)
+
+	rom := make([]uint8, 0x8000)
+	for i := range rom {
+		rom[i] = 0xA9
+	}
+	copy(rom, program)
+	copy(rom[0x7FFC:], []uint8{0x00, 0x80})
+	path := filepath.Join(dir, "board.json")
+	if err := os.WriteFile(filepath.Join(dir, "rom.bin"), rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []uint8(testBoard), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadMachine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var out strings.Builder
+	m.Devices["acia"].(*ACIA).Connect(nil, &out)
+
+	if err := m.Run(uint64(len(program)) + 2000); err != nil {
+		t.Fatal(err)
+	}
+	lcdText := m.Devices["lcd"].(*HD44780).Text()
+	if !strings.HasPrefix(lcdText, "Hi ") {
+		t.Errorf("LCD shows %q", lcdText)
+	}
+	if out.String() != "A" {
+		t.Errorf("ACIA sent %q", out.String())
+	}
+	m.Memory.Write(0x3FFF, 0x42)
+	if m.Memory.Read(0x3FFF) != 0x42 {
+		t.Errorf("RAM not mapped")
+	}
+
+}
+
+func TestDescriptionErrors(t *testing.T) {
+
+	dir := t.TempDir()
+	cases := map[string]string{
+		"unsupported CPU":   `{"cpu": "65816"}`,
+		"unknown device":    `{"devices": [{"name": "x", "type": "tape"}]}`,
+		"unknown field":     `{"memory": [{"type": "ram", "start": 0, "end": 255, "sise": 4}]}`,
+		"invalid address":   `{"memory": [{"type": "ram", "start": "$10000", "end": 0}]}`,
+		"missing port":      `{"devices": [{"name": "lcd", "type": "lcd", "wiring": {"data": "via.b"}}]}`,
+		"missing ROM image": `{"memory": [{"type": "rom", "start": 0, "end": 255, "image": "none.bin"}]}`,
+		"negative LCD rows": `{"devices": [{"name": "lcd", "type": "lcd", "columns": 16, "rows": -1}]}`,
+	}
+	for name, board := range cases {
+		path := filepath.Join(dir, "board.json")
+		if err := os.WriteFile(path, []uint8(board), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := LoadMachine(path); err == nil {
+			m.Close()
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+}
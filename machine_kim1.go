@@ -0,0 +1,442 @@
+package cpu6502
+
+import (
+	"fmt"
+	"io"
+)
+
+// ----------------------------------------------------------------------------
+// machine_kim1.go
+// MOS KIM-1 single board computer
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Memory Map
+// ----------------------------------------------------------------------------
+// $0000-$03FF 1K RAM
+// $1700-$173F 6530-003 I/O and timer    $1740-$177F 6530-002 I/O and timer
+// $1780-$17BF 6530-003 RAM              $17C0-$17FF 6530-002 RAM
+// $1800-$1BFF 6530-003 ROM              $1C00-$1FFF 6530-002 ROM
+//
+// A13-A15 are not decoded, so the 6530-002 ROM also answers at $FC00 and
+// supplies the vectors. The monitor ROM image is the 2K from $1800.
+// ----------------------------------------------------------------------------
+
+const (
+	KIM1_ROM_SIZE = 2 * RRIOT_ROM_SIZE
+	KIM1_CLOCK    = 1000000
+
+	KIM1_KEY_AD   = 0x10
+	KIM1_KEY_DA   = 0x11
+	KIM1_KEY_PLUS = 0x12
+	KIM1_KEY_GO   = 0x13
+	KIM1_KEY_PC   = 0x14
+)
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type KIM1 struct {
+	Machine
+	RRIOT002 *RRIOT // keypad, display and TTY
+	RRIOT003 *RRIOT // application ports
+	Panel    *KIM1Panel
+	Console  *KIM1Console
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewKIM1(rom ROM) (*KIM1, error) {
+	if len(rom) != KIM1_ROM_SIZE {
+		return nil, fmt.Errorf("KIM-1 ROM is %d bytes, expected %d", len(rom), KIM1_ROM_SIZE)
+	}
+
+	k := &KIM1{
+		Machine:  *NewMachine(),
+		RRIOT003: NewRRIOT(rom[:RRIOT_ROM_SIZE]),
+		RRIOT002: NewRRIOT(rom[RRIOT_ROM_SIZE:]),
+	}
+	k.Memory.Map(0x0000, 0x03FF, NewRAM(0x400))
+	k.AttachDevice("6530-003", 0x1700, 0x173F, k.RRIOT003)
+	k.AttachDevice("6530-002", 0x1740, 0x177F, k.RRIOT002)
+	k.Memory.Map(0x1780, 0x17BF, k.RRIOT003.RAM())
+	k.Memory.Map(0x17C0, 0x17FF, k.RRIOT002.RAM())
+	k.Memory.Map(0x1800, 0x1BFF, k.RRIOT003.ROM())
+	k.Memory.Map(0x1C00, 0x1FFF, k.RRIOT002.ROM())
+	k.Memory.Map(0xFC00, 0xFFFF, k.RRIOT002.ROM())
+
+	// The timer interrupts need jumpers, which are left out
+	k.Scheduler.Wire(k.RRIOT003, nil)
+	k.Scheduler.Wire(k.RRIOT002, nil)
+
+	k.Panel = &KIM1Panel{pressed: -1}
+	k.RRIOT002.PortA().Connect(&kimPanelA{k.Panel})
+	k.RRIOT002.PortB().Connect(&kimPanelB{k.Panel})
+
+	k.Console = &KIM1Console{scheduler: k.Scheduler, rx_line: true, tx_line: true}
+	k.RRIOT002.PortA().Connect(&kimConsoleA{k.Console})
+	k.RRIOT002.PortB().Connect(&kimConsoleB{k.Console})
+	k.Scheduler.Attach("console", k.Console, 1, 1)
+
+	k.Reset()
+	return k, nil
+}
+
+// The ST key, which interrupts the running program through NMI
+func (k *KIM1) Stop() {
+	k.CPU.NMILine().Assert("ST")
+	k.CPU.NMILine().Release("ST")
+}
+
+// ----------------------------------------------------------------------------
+// Display and Keypad
+// ----------------------------------------------------------------------------
+// Both are scanned through the 6530-002. PB1-PB4 drive a 74145 decoder whose
+// outputs 0-2 select a row of keys and 4-9 a digit. Digit segments a-g come
+// from PA0-PA6; a pressed key pulls a column low on PA6 (first key in the
+// row) down to PA0. The monitor blanks the segments between digits, so
+// writes of zero are not latched.
+// ----------------------------------------------------------------------------
+
+type KIM1Panel struct {
+	select_line int
+	segments    [6]uint8
+	pressed     int
+}
+
+// Segment patterns for 0-F
+var kimSegments = [16]uint8{
+	0x3F, 0x06, 0x5B, 0x4F, 0x66, 0x6D, 0x7D, 0x07,
+	0x7F, 0x6F, 0x77, 0x7C, 0x39, 0x5E, 0x79, 0x71,
+}
+
+// Holds a key down: 0-F or one of the KIM1_KEY constants
+func (p *KIM1Panel) Press(key int) {
+	p.pressed = key
+}
+
+func (p *KIM1Panel) Release() {
+	p.pressed = -1
+}
+
+// Segments last lit on each digit, leftmost first
+func (p *KIM1Panel) Segments() [6]uint8 {
+	return p.segments
+}
+
+// The display as text, with '?' for patterns that are not hex digits
+func (p *KIM1Panel) Digits() string {
+	digits := make([]uint8, len(p.segments))
+	for i, segments := range p.segments {
+		digits[i] = '?'
+		if segments == 0 {
+			digits[i] = ' '
+		}
+		for value, pattern := range kimSegments {
+			if segments == pattern {
+				digits[i] = "0123456789ABCDEF"[value]
+			}
+		}
+	}
+	return string(digits)
+}
+
+type kimPanelA struct{ panel *KIM1Panel }
+type kimPanelB struct{ panel *KIM1Panel }
+
+func (a *kimPanelA) PortOutput(pins uint8) {
+	p := a.panel
+	digit := p.select_line - 4
+	if digit >= 0 && digit < len(p.segments) && pins&0x7F != 0 {
+		p.segments[digit] = pins & 0x7F
+	}
+}
+
+func (a *kimPanelA) PortInput() uint8 {
+	p := a.panel
+	if p.pressed < 0 || p.pressed/7 != p.select_line {
+		return 0xFF
+	}
+	return ^uint8(0x40 >> (p.pressed % 7))
+}
+
+func (b *kimPanelB) PortOutput(pins uint8) {
+	b.panel.select_line = int(pins>>1) & 0x0F
+}
+
+func (b *kimPanelB) PortInput() uint8 {
+	return 0xFF
+}
+
+// ----------------------------------------------------------------------------
+// TTY Console
+// ----------------------------------------------------------------------------
+// The monitor bit-bangs a serial terminal: it sends on PB0 and receives on
+// PA7, and takes TTY mode when PA0 reads low with decoder output 3 selected,
+// which the console pulls down once connected. Send a RUBOUT first after a
+// reset, as the monitor times it to find the baud rate. Frames are 8N1,
+// decoded by sampling the middle of each bit.
+// ----------------------------------------------------------------------------
+
+type KIM1Console struct {
+	scheduler   *Scheduler
+	connected   bool
+	select_line int
+	bit         uint64 // cycles per bit
+	out         io.Writer
+	err         error
+
+	// Transmit, from the KIM
+	tx_line  bool
+	tx_busy  bool
+	tx_start uint64
+	tx_edges []kimEdge
+
+	// Receive, to the KIM
+	rx_line  bool
+	rx_queue []uint8
+	rx_input chan uint8
+	rx_stop  chan struct{}
+	rx_busy  bool
+	rx_start uint64
+	rx_data  uint8
+	rx_next  uint64 // earliest start of the next character
+}
+
+type kimEdge struct {
+	time  uint64
+	level bool
+}
+
+// Puts the KIM in TTY mode talking to a terminal at baud, which must leave
+// at least one cycle per bit. Connecting again stops reading the last
+// reader, as ACIA.Connect.
+func (k *KIM1) Connect(in io.Reader, out io.Writer, baud uint64) error {
+	if baud == 0 || baud > KIM1_CLOCK {
+		return fmt.Errorf("baud rate %d is out of range", baud)
+	}
+	c := k.Console
+	c.connected = true
+	c.bit = KIM1_CLOCK / baud
+	c.out = out
+	c.disconnect()
+	if in != nil {
+		c.rx_input = make(chan uint8, 256)
+		c.rx_stop = make(chan struct{})
+		go pump(in, c.rx_input, c.rx_stop)
+	}
+	c.rx_next = c.scheduler.Now() + 20*c.bit
+	k.Scheduler.Sync()
+	return nil
+}
+
+func (c *KIM1Console) disconnect() {
+	if c.rx_stop != nil {
+		close(c.rx_stop)
+		c.rx_stop = nil
+	}
+	c.rx_input = nil
+}
+
+// Queues characters for the KIM to receive
+func (c *KIM1Console) Type(text string) {
+	for i := range len(text) {
+		c.rx_queue = append(c.rx_queue, text[i])
+	}
+	c.scheduler.Sync()
+}
+
+// The first error returned by the output writer
+func (c *KIM1Console) Err() error {
+	return c.err
+}
+
+func (c *KIM1Console) Reset() {
+	c.tx_busy = false
+	c.tx_edges = nil
+	c.rx_busy = false
+}
+
+func (c *KIM1Console) Tick(cycles uint64) {
+	if !c.connected {
+		return
+	}
+	now := c.scheduler.Now()
+
+	if c.tx_busy && now >= c.tx_start+10*c.bit {
+		c.decode()
+	}
+
+	if c.rx_busy && now >= c.rx_start+10*c.bit {
+		c.rx_busy = false
+		c.rx_next = now + 2*c.bit
+	}
+	if !c.rx_busy && now >= c.rx_next {
+		if len(c.rx_queue) == 0 && c.rx_input != nil {
+			select {
+			case data, ok := <-c.rx_input:
+				if ok {
+					c.rx_queue = append(c.rx_queue, data)
+				} else {
+					c.rx_input = nil
+				}
+			default:
+			}
+		}
+		if len(c.rx_queue) > 0 {
+			c.rx_data = c.rx_queue[0]
+			c.rx_queue = c.rx_queue[1:]
+			c.rx_busy = true
+			c.rx_start = now
+		}
+	}
+}
+
+func (c *KIM1Console) NextEvent() uint64 {
+	if !c.connected {
+		return NO_EVENT
+	}
+	now := c.scheduler.Now()
+	next := NO_EVENT
+	if c.tx_busy {
+		next = c.tx_start + 10*c.bit
+	}
+	switch {
+	case c.rx_busy:
+		next = min(next, c.rx_start+10*c.bit)
+	case len(c.rx_queue) > 0:
+		next = min(next, max(c.rx_next, now+1))
+	case c.rx_input != nil:
+		next = min(next, max(c.rx_next, now+10*c.bit))
+	}
+	if next == NO_EVENT {
+		return NO_EVENT
+	}
+	return max(next-now, 1)
+}
+
+func (c *KIM1Console) IRQ() bool {
+	return false
+}
+
+// Level on the receive line, which follows the character being sent
+func (c *KIM1Console) rx_level() bool {
+	if !c.rx_busy {
+		return true
+	}
+	bit := (c.scheduler.Now() - c.rx_start) / c.bit
+	switch {
+	case bit == 0:
+		return false
+	case bit <= 8:
+		return c.rx_data>>(bit-1)&1 != 0
+	}
+	return true
+}
+
+func (c *KIM1Console) transmit(level bool) {
+	if level == c.tx_line {
+		return
+	}
+	c.tx_line = level
+	now := c.scheduler.Now()
+	if !c.tx_busy {
+		if !level {
+			c.tx_busy = true
+			c.tx_start = now
+			c.tx_edges = c.tx_edges[:0]
+			c.scheduler.Sync()
+		}
+		return
+	}
+	c.tx_edges = append(c.tx_edges, kimEdge{now, level})
+}
+
+func (c *KIM1Console) decode() {
+	var data uint8
+	for i := range 8 {
+		sample := c.tx_start + c.bit*uint64(2*i+3)/2
+		level := false
+		for _, edge := range c.tx_edges {
+			if edge.time <= sample {
+				level = edge.level
+			}
+		}
+		if level {
+			data |= 1 << i
+		}
+	}
+	c.tx_busy = false
+
+	// A stop bit sent late still frames a new character correctly
+	if !c.tx_line {
+		c.tx_busy = true
+		c.tx_start = c.scheduler.Now()
+		if n := len(c.tx_edges); n > 0 {
+			c.tx_start = c.tx_edges[n-1].time
+		}
+	}
+	c.tx_edges = c.tx_edges[:0]
+
+	if c.out != nil && c.err == nil {
+		_, c.err = c.out.Write([]uint8{data & 0x7F})
+	}
+}
+
+type kimConsoleA struct{ console *KIM1Console }
+type kimConsoleB struct{ console *KIM1Console }
+
+func (a *kimConsoleA) PortOutput(uint8) {
+}
+
+func (a *kimConsoleA) PortInput() uint8 {
+	c := a.console
+	pins := uint8(0xFF)
+	if !c.connected {
+		return pins
+	}
+	if !c.rx_level() {
+		pins &^= 0x80
+	}
+	if c.select_line == 3 {
+		pins &^= 0x01
+	}
+	return pins
+}
+
+func (b *kimConsoleB) PortOutput(pins uint8) {
+	c := b.console
+	c.select_line = int(pins>>1) & 0x0F
+	if c.connected {
+		c.transmit(pins&0x01 != 0)
+	} else {
+		c.tx_line = pins&0x01 != 0
+	}
+}
+
+func (b *kimConsoleB) PortInput() uint8 {
+	return 0xFF
+}
@@ -0,0 +1,227 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// machine_kim1_test.go
+// Tests the KIM-1 keypad and display scanned by code in the monitor ROM
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+// A monitor-style reset, then a loop that reads the first row of keys and
+// lights the leftmost digit with the key held
+//
+//	1C00 RESET LDX #$FF
+//	           TXS
+//	           CLD
+//	           LDA #$1E     PB1-PB4 drive the decoder
+//	           STA PBDD
+//	1C09 SCAN  LDA #$00     select the first row of keys
+//	           STA SBD
+//	           STA PADD
+//	           LDA SAD
+//	           ORA #$80
+//	           LDY #$00
+//	           ASL A        drop PA7
+//	1C19 BIT   ASL A
+//	           BCC FOUND
+//	           INY
+//	           CPY #$07
+//	           BNE BIT
+//	1C21 FOUND LDA SEGS,Y
+//	           PHA
+//	           LDA #$7F     segments out
+//	           STA PADD
+//	           LDA #$08     select the leftmost digit
+//	           STA SBD
+//	           PLA
+//	           STA SAD
+//	           JMP SCAN
+//	1C40 SEGS  0-6 and a blank for no key
+var kim1Scan = []uint8{
+	0xA2, 0xFF, 0x9A, 0xD8, 0xA9, 0x1E, 0x8D, 0x43, 0x17, 0xA9, 0x00, 0x8D,
+	0x42, 0x17, 0x8D, 0x41, 0x17, 0xAD, 0x40, 0x17, 0x09, 0x80, 0xA0, 0x00,
+	0x0A, 0x0A, 0x90, 0x05, 0xC8, 0xC0, 0x07, 0xD0, 0xF8, 0xB9, 0x40, 0x1C,
+	0x48, 0xA9, 0x7F, 0x8D, 0x41, 0x17, 0xA9, 0x08, 0x8D, 0x42, 0x17, 0x68,
+	0x8D, 0x40, 0x17, 0x4C, 0x09, 0x1C,
+}
+
+func TestKIM1KeypadAndDisplay(t *testing.T) {
+
+	rom := filledROM(KIM1_ROM_SIZE, 0xEA)
+	copy(rom[RRIOT_ROM_SIZE:], kim1Scan)
+	copy(rom[RRIOT_ROM_SIZE+0x40:], append(kimSegments[:7:7], 0x00))
+	copy(rom[KIM1_ROM_SIZE-4:], []uint8{0x00, 0x1C})
+
+	k, err := NewKIM1(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	if digits := k.Panel.Digits(); digits != "      " {
+		t.Errorf("display lit with no key held: %q", digits)
+	}
+
+	k.Panel.Press(5)
+	if err := k.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	if segments := k.Panel.Segments(); segments[0] != kimSegments[5] {
+		t.Errorf("first digit segments $%02X, expected $%02X", segments[0], kimSegments[5])
+	}
+
+	// The blank written with no key held is not latched
+	k.Panel.Press(2)
+	if err := k.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	k.Panel.Release()
+	if err := k.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	if digits := k.Panel.Digits(); digits != "2     " {
+		t.Errorf("display reads %q", digits)
+	}
+
+}
+
+func TestKIM1ReconnectStopsReader(t *testing.T) {
+
+	rom := filledROM(KIM1_ROM_SIZE, 0xEA)
+	copy(rom[KIM1_ROM_SIZE-4:], []uint8{0x00, 0x1C})
+	k, err := NewKIM1(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make(chanReader)
+	if err := k.Connect(first, nil, 2400); err != nil {
+		t.Fatal(err)
+	}
+	first <- []uint8("a")
+	if err := k.Connect(nil, nil, 2400); err != nil {
+		t.Fatal(err)
+	}
+	first.offer("b")
+	if first.offer("c") {
+		t.Errorf("reader still read after reconnecting")
+	}
+	if k.Console.rx_input != nil {
+		t.Errorf("still taking input from the old reader")
+	}
+
+}
+
+// Echoes each character on the TTY, timing 2400 baud, 416 cycles a bit, with
+// delay loops of 5X+13 cycles, call included
+//
+//	1C00 RESET LDX #$FF
+//	           TXS
+//	           CLD
+//	           LDA #$01     PB0 idles high
+//	           STA SBD
+//	           STA PBDD
+//	1C0C WAIT  LDA SAD      start bit on PA7
+//	           BMI WAIT
+//	           LDX #120     to the middle of the first data bit
+//	           JSR DELAY
+//	           LDY #$08
+//	1C18 RX    LDA SAD
+//	           ASL A
+//	           ROR $00
+//	           LDX #77
+//	           JSR DELAY
+//	           DEY
+//	           BNE RX
+//	           LDA #$00     start bit
+//	           STA SBD
+//	           LDX #78
+//	           JSR DELAY
+//	           LDY #$08
+//	1C32 TX    LSR $00
+//	           LDA #$00
+//	           ROL A
+//	           STA SBD
+//	           LDX #77
+//	           JSR DELAY
+//	           DEY
+//	           BNE TX
+//	           LDA #$01     stop bit
+//	           STA SBD
+//	           LDX #79
+//	           JSR DELAY
+//	           JMP WAIT
+//	1C50 DELAY DEX
+//	           BNE DELAY
+//	           RTS
+var kim1Echo = []uint8{
+	0xA2, 0xFF, 0x9A, 0xD8, 0xA9, 0x01, 0x8D, 0x42, 0x17, 0x8D, 0x43, 0x17,
+	0xAD, 0x40, 0x17, 0x30, 0xFB, 0xA2, 0x78, 0x20, 0x50, 0x1C, 0xA0, 0x08,
+	0xAD, 0x40, 0x17, 0x0A, 0x66, 0x00, 0xA2, 0x4D, 0x20, 0x50, 0x1C, 0x88,
+	0xD0, 0xF2, 0xA9, 0x00, 0x8D, 0x42, 0x17, 0xA2, 0x4E, 0x20, 0x50, 0x1C,
+	0xA0, 0x08, 0x46, 0x00, 0xA9, 0x00, 0x2A, 0x8D, 0x42, 0x17, 0xA2, 0x4D,
+	0x20, 0x50, 0x1C, 0x88, 0xD0, 0xF0, 0xA9, 0x01, 0x8D, 0x42, 0x17, 0xA2,
+	0x4F, 0x20, 0x50, 0x1C, 0x4C, 0x0C, 0x1C, 0xEA, 0xCA, 0xD0, 0xFD, 0x60,
+}
+
+func TestKIM1TTYEcho(t *testing.T) {
+
+	rom := filledROM(KIM1_ROM_SIZE, 0xEA)
+	copy(rom[RRIOT_ROM_SIZE:], kim1Echo)
+	copy(rom[KIM1_ROM_SIZE-4:], []uint8{0x00, 0x1C})
+	k, err := NewKIM1(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Connect(nil, nil, 0); err == nil {
+		t.Errorf("connected at 0 baud")
+	}
+	var out strings.Builder
+	if err := k.Connect(nil, &out, 2400); err != nil {
+		t.Fatal(err)
+	}
+
+	// One character at a time, as the loop cannot receive while it sends
+	for _, key := range []string{"K", "i", "M"} {
+		k.Console.Type(key)
+		if err := k.Run(40 * 416); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.String() != "KiM" {
+		t.Errorf("terminal shows %q", out.String())
+	}
+	if err := k.Console.Err(); err != nil {
+		t.Error(err)
+	}
+
+}
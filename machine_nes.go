@@ -0,0 +1,740 @@
+package cpu6502
+
+import (
+	"fmt"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// machine_nes.go
+// Headless NES for game logic: iNES cartridges and PPU/APU register stubs
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Memory Map
+// ----------------------------------------------------------------------------
+// Only the CPU's side of the console. Nothing is drawn and no sound is made;
+// the PPU and APU registers answer just well enough for game logic to run,
+// be traced and be fuzzed.
+//
+// $0000-$1FFF 2K of RAM, mirrored
+// $2000-$3FFF PPU registers, mirrored every 8 bytes
+// $4000-$401F APU registers, OAM DMA and the controllers
+// $6000-$7FFF work RAM on the cartridge, when it has any
+// $8000-$FFFF PRG-ROM, banked by the cartridge's mapper
+// ----------------------------------------------------------------------------
+
+const (
+	NES_CLOCK        = 1789773 // NTSC
+	NES_RAM_SIZE     = 0x0800
+	NES_PPU          = 0x2000
+	NES_IO           = 0x4000
+	NES_PRG_RAM      = 0x6000
+	NES_PRG_ROM      = 0x8000
+	NES_HEADER_SIZE  = 16
+	NES_TRAINER_SIZE = 512
+	NES_PRG_BANK     = 0x4000 // units of PRG-ROM size in the header
+	NES_CHR_BANK     = 0x2000 // units of CHR-ROM size
+
+	NES_MAPPER_NROM  = 0
+	NES_MAPPER_MMC1  = 1
+	NES_MAPPER_UXROM = 2
+	NES_MAPPER_CNROM = 3
+
+	NES_BUTTON_A      = 0x01
+	NES_BUTTON_B      = 0x02
+	NES_BUTTON_SELECT = 0x04
+	NES_BUTTON_START  = 0x08
+	NES_BUTTON_UP     = 0x10
+	NES_BUTTON_DOWN   = 0x20
+	NES_BUTTON_LEFT   = 0x40
+	NES_BUTTON_RIGHT  = 0x80
+)
+
+const (
+	nesMagic       = "NES\x1A"
+	nesTrainer     = 0x1000 // offset of the trainer in work RAM, $7000
+	nesOAMDMA      = 0x14
+	nesAPUStatus   = 0x15
+	nesController1 = 0x16
+	nesController2 = 0x17
+	nesDMACycles   = 513
+)
+
+// ----------------------------------------------------------------------------
+// Cartridges
+// ----------------------------------------------------------------------------
+// Read from iNES and NES 2.0 images. Sizes in NES 2.0's exponent notation,
+// used only by odd homebrew, are not supported. Without a NES 2.0 header,
+// 8K of work RAM is assumed, as most emulators do, and a header with junk
+// in its padding has the high nibble of its mapper number ignored.
+// ----------------------------------------------------------------------------
+
+type NESMirroring int
+
+const (
+	NES_MIRROR_HORIZONTAL NESMirroring = iota
+	NES_MIRROR_VERTICAL
+	NES_MIRROR_FOUR_SCREEN
+)
+
+type NESCartridge struct {
+	Mapper    int
+	Submapper int
+	PRG       []uint8
+	CHR       []uint8 // nil when the cartridge has CHR-RAM
+	CHRRAM    int     // bytes of CHR-RAM
+	PRGRAM    int     // bytes of work RAM at $6000, battery backed or not
+	Trainer   []uint8 // loaded at $7000
+	Mirroring NESMirroring
+	Battery   bool
+	NES2      bool
+}
+
+func ReadINES(path string) (*NESCartridge, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cartridge, err := ParseINES(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cartridge, nil
+}
+
+func ParseINES(data []uint8) (*NESCartridge, error) {
+	if len(data) < NES_HEADER_SIZE || string(data[:4]) != nesMagic {
+		return nil, fmt.Errorf("not an iNES image")
+	}
+	header := data[:NES_HEADER_SIZE]
+	c := &NESCartridge{
+		Mapper:  int(header[6] >> 4),
+		Battery: header[6]&0x02 != 0,
+		NES2:    header[7]&0x0C == 0x08,
+	}
+	switch {
+	case header[6]&0x08 != 0:
+		c.Mirroring = NES_MIRROR_FOUR_SCREEN
+	case header[6]&0x01 != 0:
+		c.Mirroring = NES_MIRROR_VERTICAL
+	}
+
+	prg, chr := int(header[4]), int(header[5])
+	if c.NES2 {
+		if header[9]&0x0F == 0x0F || header[9]&0xF0 == 0xF0 {
+			return nil, fmt.Errorf("exponent ROM sizes are not supported")
+		}
+		prg |= int(header[9]&0x0F) << 8
+		chr |= int(header[9]&0xF0) << 4
+		c.Mapper |= int(header[7]&0xF0) | int(header[8]&0x0F)<<8
+		c.Submapper = int(header[8] >> 4)
+		c.PRGRAM = nesShiftSize(header[10]) + nesShiftSize(header[10]>>4)
+		c.CHRRAM = nesShiftSize(header[11])
+	} else {
+		if header[12]|header[13]|header[14]|header[15] == 0 {
+			c.Mapper |= int(header[7] & 0xF0)
+		}
+		c.PRGRAM = 0x2000
+		c.CHRRAM = 0x2000
+	}
+	if prg == 0 {
+		return nil, fmt.Errorf("image has no PRG-ROM")
+	}
+
+	rest := data[NES_HEADER_SIZE:]
+	if header[6]&0x04 != 0 {
+		if len(rest) < NES_TRAINER_SIZE {
+			return nil, fmt.Errorf("image is truncated in the trainer")
+		}
+		c.Trainer = rest[:NES_TRAINER_SIZE]
+		c.PRGRAM = max(c.PRGRAM, 0x2000)
+		rest = rest[NES_TRAINER_SIZE:]
+	}
+	prg *= NES_PRG_BANK
+	chr *= NES_CHR_BANK
+	if len(rest) < prg+chr {
+		return nil, fmt.Errorf("image is %d bytes short of its %dK PRG-ROM and %dK CHR-ROM",
+			prg+chr-len(rest), prg/1024, chr/1024)
+	}
+	c.PRG = rest[:prg]
+	if chr > 0 {
+		c.CHR = rest[prg : prg+chr]
+		c.CHRRAM = 0
+	} else if c.CHRRAM == 0 {
+		c.CHRRAM = 0x2000
+	}
+	return c, nil
+}
+
+// RAM sizes in a NES 2.0 header are 64 bytes shifted left by a nibble
+func nesShiftSize(nibble uint8) int {
+	if nibble&0x0F == 0 {
+		return 0
+	}
+	return 64 << (nibble & 0x0F)
+}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type NES struct {
+	Machine
+	Cartridge   *NESCartridge
+	PPU         *NESPPU
+	Controllers [2]uint8 // buttons held, NES_BUTTON_*
+	APUStatus   uint8    // read from $4015
+	mapper      nesMapper
+	ram         RAM
+	prg_ram     RAM
+	apu         [0x18]uint8 // APU registers as last written
+	strobe      bool
+	shifts      [2]uint8 // controller shift registers
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewNES(cartridge *NESCartridge) (*NES, error) {
+	mapper, err := nesMapperFor(cartridge)
+	if err != nil {
+		return nil, err
+	}
+	n := &NES{
+		Machine:   *NewMachine(),
+		Cartridge: cartridge,
+		mapper:    mapper,
+		ram:       NewRAM(NES_RAM_SIZE),
+	}
+	n.PPU = &NESPPU{mapper: mapper}
+
+	n.Memory.Map(0x0000, NES_PPU-1, n.ram)
+	n.Scheduler.Attach("ppu", n.PPU, 3, 1)
+	n.Scheduler.Wire(n.PPU, n.CPU.NMILine())
+	n.Memory.Map(NES_PPU, NES_IO-1, n.Scheduler.Clocked(n.PPU))
+	n.Memory.Map(NES_IO, NES_IO+0x1F, &nesIO{n})
+	if cartridge.PRGRAM > 0 {
+		// Larger work RAM is banked, which none of the mappers here do
+		n.prg_ram = NewRAM(min(cartridge.PRGRAM, 0x2000))
+		n.Memory.Map(NES_PRG_RAM, NES_PRG_ROM-1, n.prg_ram)
+	}
+	n.Memory.Map(NES_PRG_ROM, 0xFFFF, mapper)
+
+	n.Reset()
+	return n, nil
+}
+
+// Power-on: the mapper's registers are cleared, so that the reset vector
+// is read from the bank it powers up with, and any trainer is reloaded
+func (n *NES) Reset() {
+	n.mapper.reset()
+	if n.Cartridge.Trainer != nil {
+		copy(n.prg_ram[nesTrainer:], n.Cartridge.Trainer)
+	}
+	n.apu = [0x18]uint8{}
+	n.strobe = false
+	n.shifts = [2]uint8{}
+	n.Machine.Reset()
+}
+
+// ----------------------------------------------------------------------------
+// APU and Controllers
+// ----------------------------------------------------------------------------
+// The APU takes writes and reports APUStatus from $4015, with no frame
+// interrupt. Each controller shifts out its buttons, A first, after the
+// strobe on $4016 is released, then reads 1. OAM DMA copies a page to the
+// PPU's sprite memory and stalls the CPU as on the console.
+// ----------------------------------------------------------------------------
+
+type nesIO struct {
+	n *NES
+}
+
+func (io *nesIO) Read(addr uint16) uint8 {
+	n := io.n
+	switch addr {
+	case nesAPUStatus:
+		return n.APUStatus
+	case nesController1, nesController2:
+		// The upper bits are left on the bus by the operand's high byte
+		return n.Memory.DataBus()&0xE0 | n.read_controller(int(addr-nesController1))
+	}
+	return n.Memory.DataBus() // write only
+}
+
+func (io *nesIO) Write(addr uint16, data uint8) {
+	n := io.n
+	switch {
+	case addr == nesOAMDMA:
+		page := uint16(data) << 8
+		for i := range uint16(256) {
+			n.PPU.oam[n.PPU.oam_addr+uint8(i)] = n.Memory.Read(page + i)
+		}
+	case addr == nesController1:
+		n.strobe = data&0x01 != 0
+		n.shifts = n.Controllers
+	case addr < uint16(len(n.apu)):
+		n.apu[addr] = data
+	}
+}
+
+func (io *nesIO) WaitStates(addr uint16, write bool) int {
+	if write && addr == nesOAMDMA {
+		return nesDMACycles
+	}
+	return 0
+}
+
+func (n *NES) read_controller(i int) uint8 {
+	if n.strobe {
+		return n.Controllers[i] & 0x01
+	}
+	bit := n.shifts[i] & 0x01
+	n.shifts[i] = n.shifts[i]>>1 | 0x80
+	return bit
+}
+
+// ----------------------------------------------------------------------------
+// PPU
+// ----------------------------------------------------------------------------
+// Keeps NTSC frame timing and the registers, with no rendering. Vertical
+// blank starts on line 241, raising the status flag and NMI if enabled, and
+// the flags clear on the pre-render line. Sprite 0 hit cannot be worked out
+// without drawing, so it is reported on a chosen line instead. PPUADDR and
+// PPUDATA reach the cartridge's pattern tables, 4K of nametables with no
+// mirroring, and the palette.
+// ----------------------------------------------------------------------------
+
+const (
+	NES_PPUCTRL   = 0x00
+	NES_PPUMASK   = 0x01
+	NES_PPUSTATUS = 0x02
+	NES_OAMADDR   = 0x03
+	NES_OAMDATA   = 0x04
+	NES_PPUSCROLL = 0x05
+	NES_PPUADDR   = 0x06
+	NES_PPUDATA   = 0x07
+
+	NES_PPUCTRL_INCREMENT = 0x04
+	NES_PPUCTRL_NMI       = 0x80
+
+	NES_PPUSTATUS_OVERFLOW = 0x20
+	NES_PPUSTATUS_SPRITE0  = 0x40
+	NES_PPUSTATUS_VBLANK   = 0x80
+)
+
+const (
+	nesLineDots     = 341
+	nesFrameDots    = 262 * nesLineDots
+	nesVBlankDot    = 241*nesLineDots + 1
+	nesPreRenderDot = 261*nesLineDots + 1
+)
+
+type NESVBlankMode int
+
+const (
+	NES_VBLANK_TIMED  NESVBlankMode = iota // flag and NMI on frame timing
+	NES_VBLANK_ALWAYS                      // status always reads in vertical blank; NMI as timed
+	NES_VBLANK_NEVER                       // no flag and no NMI
+)
+
+type NESPPU struct {
+	VBlank      NESVBlankMode
+	Sprite0Line int // line on which sprite 0 hit is reported, 0 for never
+	ctrl        uint8
+	mask        uint8
+	status      uint8
+	oam_addr    uint8
+	oam         [256]uint8
+	addr        uint16
+	latch       bool // second write to PPUSCROLL or PPUADDR
+	buffer      uint8
+	bus         uint8 // last value written, read back from write only registers
+	dot         uint64
+	nametables  [0x1000]uint8
+	palette     [0x20]uint8
+	mapper      nesMapper
+}
+
+// Sprite memory, as loaded through OAMDATA or DMA
+func (p *NESPPU) OAM() []uint8 {
+	return p.oam[:]
+}
+
+// ----------------------------------------------------------------------------
+// Device Implementation
+
+func (p *NESPPU) Reset() {
+	p.ctrl = 0
+	p.mask = 0
+	p.status = 0
+	p.oam_addr = 0
+	p.addr = 0
+	p.latch = false
+	p.buffer = 0
+	p.dot = 0
+}
+
+func (p *NESPPU) Tick(dots uint64) {
+	for dots > 0 {
+		step := min(dots, p.NextEvent())
+		p.dot = (p.dot + step) % nesFrameDots
+		dots -= step
+		switch p.dot {
+		case nesVBlankDot:
+			if p.VBlank != NES_VBLANK_NEVER {
+				p.status |= NES_PPUSTATUS_VBLANK
+			}
+		case nesPreRenderDot:
+			p.status &^= NES_PPUSTATUS_VBLANK | NES_PPUSTATUS_SPRITE0 | NES_PPUSTATUS_OVERFLOW
+		case p.sprite0_dot():
+			p.status |= NES_PPUSTATUS_SPRITE0
+		}
+	}
+}
+
+// The end of the frame counts as an event, which keeps the arithmetic simple
+func (p *NESPPU) NextEvent() uint64 {
+	next := nesFrameDots - p.dot
+	for _, at := range []uint64{p.sprite0_dot(), nesVBlankDot, nesPreRenderDot} {
+		if at > p.dot {
+			next = min(next, at-p.dot)
+		}
+	}
+	return next
+}
+
+func (p *NESPPU) IRQ() bool {
+	return p.ctrl&NES_PPUCTRL_NMI != 0 && p.status&NES_PPUSTATUS_VBLANK != 0
+}
+
+func (p *NESPPU) sprite0_dot() uint64 {
+	if p.Sprite0Line <= 0 || p.Sprite0Line >= 241 {
+		return NO_EVENT
+	}
+	return uint64(p.Sprite0Line)*nesLineDots + 1
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+
+func (p *NESPPU) Read(addr uint16) uint8 {
+	switch addr & 0x07 {
+	case NES_PPUSTATUS:
+		data := p.status | p.bus&0x1F
+		if p.VBlank == NES_VBLANK_ALWAYS {
+			data |= NES_PPUSTATUS_VBLANK
+		}
+		p.status &^= NES_PPUSTATUS_VBLANK
+		p.latch = false
+		return data
+	case NES_OAMDATA:
+		return p.oam[p.oam_addr]
+	case NES_PPUDATA:
+		// Reads are delayed through a buffer, except from the palette
+		data := p.buffer
+		p.buffer = p.vram_read(p.addr)
+		if p.addr&0x3FFF >= 0x3F00 {
+			data = p.buffer
+			p.buffer = p.nametables[p.addr&0x0FFF]
+		}
+		p.increment()
+		return data
+	}
+	return p.bus
+}
+
+func (p *NESPPU) Write(addr uint16, data uint8) {
+	p.bus = data
+	switch addr & 0x07 {
+	case NES_PPUCTRL:
+		p.ctrl = data
+	case NES_PPUMASK:
+		p.mask = data
+	case NES_OAMADDR:
+		p.oam_addr = data
+	case NES_OAMDATA:
+		p.oam[p.oam_addr] = data
+		p.oam_addr++
+	case NES_PPUSCROLL:
+		p.latch = !p.latch
+	case NES_PPUADDR:
+		if p.latch {
+			p.addr = p.addr&0xFF00 | uint16(data)
+		} else {
+			p.addr = uint16(data&0x3F)<<8 | p.addr&0x00FF
+		}
+		p.latch = !p.latch
+	case NES_PPUDATA:
+		p.vram_write(p.addr, data)
+		p.increment()
+	}
+}
+
+func (p *NESPPU) increment() {
+	if p.ctrl&NES_PPUCTRL_INCREMENT != 0 {
+		p.addr += 32
+	} else {
+		p.addr++
+	}
+}
+
+// $3F10, $3F14, $3F18 and $3F1C are the same entries as $3F00-$3F0C
+func nesPaletteIndex(addr uint16) uint16 {
+	if addr&0x13 == 0x10 {
+		addr &^= 0x10
+	}
+	return addr & 0x1F
+}
+
+func (p *NESPPU) vram_read(addr uint16) uint8 {
+	addr &= 0x3FFF
+	switch {
+	case addr < 0x2000:
+		return p.mapper.chr_read(addr)
+	case addr < 0x3F00:
+		return p.nametables[addr&0x0FFF]
+	}
+	return p.palette[nesPaletteIndex(addr)]
+}
+
+func (p *NESPPU) vram_write(addr uint16, data uint8) {
+	addr &= 0x3FFF
+	switch {
+	case addr < 0x2000:
+		p.mapper.chr_write(addr, data)
+	case addr < 0x3F00:
+		p.nametables[addr&0x0FFF] = data
+	default:
+		p.palette[nesPaletteIndex(addr)] = data
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Mappers
+// ----------------------------------------------------------------------------
+// Each is mapped at $8000 and also serves the PPU's pattern tables. Banks
+// are taken modulo the size of the ROM, as the unconnected high bank lines
+// would be on a smaller board. Bus conflicts on the discrete logic boards
+// are not emulated; nor is MMC1 ignoring the second of two writes on
+// consecutive cycles, or its switch to disable work RAM.
+// ----------------------------------------------------------------------------
+
+type nesMapper interface {
+	Bus
+	reset()
+	chr_read(addr uint16) uint8
+	chr_write(addr uint16, data uint8)
+}
+
+func nesMapperFor(c *NESCartridge) (nesMapper, error) {
+	board := nesBoard{prg: c.PRG, chr: c.CHR}
+	if c.CHR == nil {
+		board.chr = make([]uint8, c.CHRRAM)
+		board.chr_ram = true
+	}
+	switch c.Mapper {
+	case NES_MAPPER_NROM:
+		return &nesNROM{board}, nil
+	case NES_MAPPER_MMC1:
+		return &nesMMC1{nesBoard: board}, nil
+	case NES_MAPPER_UXROM:
+		return &nesUxROM{nesBoard: board}, nil
+	case NES_MAPPER_CNROM:
+		return &nesCNROM{nesBoard: board}, nil
+	}
+	return nil, fmt.Errorf("mapper %d is not supported", c.Mapper)
+}
+
+type nesBoard struct {
+	prg     []uint8
+	chr     []uint8
+	chr_ram bool
+}
+
+func (b *nesBoard) prg_read(bank int, size int, addr uint16) uint8 {
+	return b.prg[(bank*size+int(addr)%size)%len(b.prg)]
+}
+
+func (b *nesBoard) chr_bank(bank int, size int, addr uint16) int {
+	return (bank*size + int(addr)%size) % len(b.chr)
+}
+
+func (b *nesBoard) chr_write(addr uint16, data uint8) {
+	if b.chr_ram {
+		b.chr[int(addr)%len(b.chr)] = data
+	}
+}
+
+// ----------------------------------------------------------------------------
+// NROM, no banking: 16K mirrored or 32K of PRG-ROM
+
+type nesNROM struct {
+	nesBoard
+}
+
+func (m *nesNROM) reset() {
+}
+
+func (m *nesNROM) Read(addr uint16) uint8 {
+	return m.prg_read(0, 0x8000, addr)
+}
+
+func (m *nesNROM) Write(uint16, uint8) {
+}
+
+func (m *nesNROM) chr_read(addr uint16) uint8 {
+	return m.chr[m.chr_bank(0, 0x2000, addr)]
+}
+
+// ----------------------------------------------------------------------------
+// UxROM, a 16K bank switched at $8000 and the last fixed at $C000
+
+type nesUxROM struct {
+	nesBoard
+	bank int
+}
+
+func (m *nesUxROM) reset() {
+	m.bank = 0
+}
+
+func (m *nesUxROM) Read(addr uint16) uint8 {
+	if addr < 0x4000 {
+		return m.prg_read(m.bank, 0x4000, addr)
+	}
+	return m.prg_read(len(m.prg)/0x4000-1, 0x4000, addr)
+}
+
+func (m *nesUxROM) Write(addr uint16, data uint8) {
+	m.bank = int(data)
+}
+
+func (m *nesUxROM) chr_read(addr uint16) uint8 {
+	return m.chr[m.chr_bank(0, 0x2000, addr)]
+}
+
+// ----------------------------------------------------------------------------
+// CNROM, fixed PRG-ROM as NROM and an 8K CHR-ROM bank switched by writes
+
+type nesCNROM struct {
+	nesBoard
+	bank int
+}
+
+func (m *nesCNROM) reset() {
+	m.bank = 0
+}
+
+func (m *nesCNROM) Read(addr uint16) uint8 {
+	return m.prg_read(0, 0x8000, addr)
+}
+
+func (m *nesCNROM) Write(addr uint16, data uint8) {
+	m.bank = int(data)
+}
+
+func (m *nesCNROM) chr_read(addr uint16) uint8 {
+	return m.chr[m.chr_bank(m.bank, 0x2000, addr)]
+}
+
+// ----------------------------------------------------------------------------
+// MMC1
+// ----------------------------------------------------------------------------
+// Registers are loaded a bit at a time, LSB first, through a shift register:
+// the fifth write goes to the register selected by A13-A14. Writing a value
+// with bit 7 set clears the shift register and fixes the last PRG bank at
+// $C000, the state the chip powers up in. On boards with 512K of PRG-ROM
+// bit 4 of the first CHR register selects the 256K half.
+// ----------------------------------------------------------------------------
+
+const (
+	nesMMC1Control = 0
+	nesMMC1CHR0    = 1
+	nesMMC1CHR1    = 2
+	nesMMC1PRG     = 3
+
+	nesMMC1FixLast = 0x0C // PRG mode 3
+	nesMMC1CHR4K   = 0x10
+)
+
+type nesMMC1 struct {
+	nesBoard
+	shift     uint8
+	count     int
+	registers [4]uint8
+}
+
+func (m *nesMMC1) reset() {
+	m.shift = 0
+	m.count = 0
+	m.registers = [4]uint8{nesMMC1FixLast, 0, 0, 0}
+}
+
+func (m *nesMMC1) Read(addr uint16) uint8 {
+	bank := int(m.registers[nesMMC1PRG] & 0x0F)
+	switch m.registers[nesMMC1Control] >> 2 & 0x03 {
+	case 0, 1: // 32K
+		bank = bank&^1 + int(addr>>14)
+	case 2: // first bank fixed at $8000
+		if addr < 0x4000 {
+			bank = 0
+		}
+	case 3: // last bank fixed at $C000
+		if addr >= 0x4000 {
+			bank = 0x0F
+		}
+	}
+	if len(m.prg) > 0x40000 && m.registers[nesMMC1CHR0]&0x10 != 0 {
+		bank |= 0x10
+	}
+	return m.prg_read(bank, 0x4000, addr)
+}
+
+func (m *nesMMC1) Write(addr uint16, data uint8) {
+	if data&0x80 != 0 {
+		m.shift = 0
+		m.count = 0
+		m.registers[nesMMC1Control] |= nesMMC1FixLast
+		return
+	}
+	m.shift |= (data & 0x01) << m.count
+	m.count++
+	if m.count == 5 {
+		m.registers[addr>>13&0x03] = m.shift
+		m.shift = 0
+		m.count = 0
+	}
+}
+
+func (m *nesMMC1) chr_read(addr uint16) uint8 {
+	if m.registers[nesMMC1Control]&nesMMC1CHR4K == 0 {
+		return m.chr[m.chr_bank(int(m.registers[nesMMC1CHR0]>>1), 0x2000, addr)]
+	}
+	if addr < 0x1000 {
+		return m.chr[m.chr_bank(int(m.registers[nesMMC1CHR0]), 0x1000, addr)]
+	}
+	return m.chr[m.chr_bank(int(m.registers[nesMMC1CHR1]), 0x1000, addr)]
+}
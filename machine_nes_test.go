@@ -0,0 +1,520 @@
+package cpu6502
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// machine_nes_test.go
+// Tests the headless NES: iNES loading, mapper banking, PPU memory, vblank
+// and controllers
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+// An MMC1 image with four 16K banks, each tagged with its number at offset
+// $3FF0. The last, fixed at $C000 on power-up, holds a reset stub and an NMI
+// handler that returns at once.
+//
+//	C000 RESET SEI
+//	           CLD
+//	           LDX #$FF
+//	           TXS
+//	C005 IDLE  JMP IDLE
+//	C100 NMI   RTI
+func testINES() []uint8 {
+	image := []uint8{'N', 'E', 'S', 0x1A, 4, 0, 0x12, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]uint8, 4*NES_PRG_BANK)
+	for bank := range 4 {
+		prg[bank*NES_PRG_BANK+0x3FF0] = uint8(bank)
+	}
+	last := prg[3*NES_PRG_BANK:]
+	copy(last, []uint8{0x78, 0xD8, 0xA2, 0xFF, 0x9A, 0x4C, 0x05, 0xC0})
+	last[0x100] = 0x40
+	copy(prg[len(prg)-6:], []uint8{0x00, 0xC1, 0x00, 0xC0, 0x00, 0xC0})
+	return append(image, prg...)
+}
+
+func TestNESMMC1Banking(t *testing.T) {
+
+	cartridge, err := ParseINES(testINES())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cartridge.Mapper != NES_MAPPER_MMC1 || len(cartridge.PRG) != 0x10000 ||
+		cartridge.CHRRAM != 0x2000 || !cartridge.Battery {
+		t.Fatalf("parsed %+v", cartridge)
+	}
+	n, err := NewNES(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc := n.CPU.Registers().PC; pc != 0xC000 {
+		t.Errorf("reset to $%04X", pc)
+	}
+	if err := n.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	if registers := n.CPU.Registers(); registers.PC != 0xC005 || registers.SP != 0xFF ||
+		registers.P&FLAG_IRQ == 0 {
+		t.Errorf("reset stub left %+v", registers)
+	}
+
+	serial := func(addr uint16, value uint8) {
+		for i := range 5 {
+			n.Memory.Write(addr, value>>i&0x01)
+		}
+	}
+	banks := func() (uint8, uint8) {
+		return n.Memory.Read(0xBFF0), n.Memory.Read(0xFFF0)
+	}
+	if low, high := banks(); low != 0 || high != 3 {
+		t.Errorf("powered up with banks %d and %d", low, high)
+	}
+	serial(0xE000, 2)
+	if low, high := banks(); low != 2 || high != 3 {
+		t.Errorf("banks %d and %d after selecting 2", low, high)
+	}
+	serial(0x8000, 0x08) // first bank fixed at $8000
+	if low, high := banks(); low != 0 || high != 2 {
+		t.Errorf("banks %d and %d with the first fixed", low, high)
+	}
+	n.Memory.Write(0x8000, 0x80)
+	if low, high := banks(); low != 2 || high != 3 {
+		t.Errorf("banks %d and %d after reset", low, high)
+	}
+
+	n.Memory.Write(0x6000, 0x5A)
+	if n.Memory.Read(0x6000) != 0x5A {
+		t.Errorf("no work RAM")
+	}
+
+}
+
+func TestNESVBlankAndControllers(t *testing.T) {
+
+	cartridge, err := ParseINES(testINES())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewNES(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Vertical blank starts on dot 1 of line 241, CPU cycle 27394
+	n.Memory.Write(0x2000, NES_PPUCTRL_NMI)
+	if err := n.Run(27393); err != nil {
+		t.Fatal(err)
+	}
+	if n.CPU.NMILine().Asserted() {
+		t.Errorf("NMI before vertical blank")
+	}
+	if err := n.Run(1); err != nil {
+		t.Fatal(err)
+	}
+	if !n.CPU.NMILine().Asserted() {
+		t.Errorf("no NMI in vertical blank")
+	}
+	if status := n.Memory.Read(0x2002); status&NES_PPUSTATUS_VBLANK == 0 {
+		t.Errorf("status read %02X", status)
+	}
+	if n.Memory.Read(0x2002)&NES_PPUSTATUS_VBLANK != 0 || n.CPU.NMILine().Asserted() {
+		t.Errorf("reading status did not clear vertical blank")
+	}
+	n.PPU.VBlank = NES_VBLANK_ALWAYS
+	if n.Memory.Read(0x3FFA)&NES_PPUSTATUS_VBLANK == 0 {
+		t.Errorf("status not forced into vertical blank")
+	}
+
+	n.Controllers[0] = NES_BUTTON_A | NES_BUTTON_START
+	n.Memory.Write(0x4016, 1)
+	n.Memory.Write(0x4016, 0)
+	var bits []uint8
+	for range 9 {
+		bits = append(bits, n.Memory.Read(0x4016)&0x01)
+	}
+	if string(bits) != "\x01\x00\x00\x01\x00\x00\x00\x00\x01" {
+		t.Errorf("controller shifted out %v", bits)
+	}
+
+	n.Memory.Write(0x0203, 0x77)
+	n.Memory.TakeWaitStates()
+	n.Memory.Write(0x4014, 0x02)
+	if n.PPU.OAM()[3] != 0x77 {
+		t.Errorf("OAM DMA did not copy")
+	}
+	if waits := n.Memory.TakeWaitStates(); waits != 513 {
+		t.Errorf("OAM DMA stalled for %d cycles", waits)
+	}
+
+}
+
+// An image for a mapper with each 16K PRG bank tagged with its number at
+// offset $3FF0 and each 4K of CHR-ROM with its number in its first byte
+func nesImage(mapper uint8, prg int, chr int) []uint8 {
+	image := []uint8{'N', 'E', 'S', 0x1A, uint8(prg), uint8(chr), mapper << 4, mapper & 0xF0,
+		0, 0, 0, 0, 0, 0, 0, 0}
+	banks := make([]uint8, prg*NES_PRG_BANK+chr*NES_CHR_BANK)
+	for bank := range prg {
+		banks[bank*NES_PRG_BANK+0x3FF0] = uint8(bank)
+	}
+	for bank := range chr * 2 {
+		banks[prg*NES_PRG_BANK+bank*0x1000] = uint8(bank)
+	}
+	return append(image, banks...)
+}
+
+func newTestNES(t *testing.T, image []uint8) *NES {
+	t.Helper()
+	cartridge, err := ParseINES(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewNES(cartridge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Reads the pattern tables through PPUADDR and PPUDATA, discarding the
+// stale byte left in the read buffer
+func nesCHR(n *NES, addr uint16) uint8 {
+	n.Memory.Read(0x2002)
+	n.Memory.Write(0x2006, uint8(addr>>8))
+	n.Memory.Write(0x2006, uint8(addr))
+	n.Memory.Read(0x2007)
+	return n.Memory.Read(0x2007)
+}
+
+func TestParseINESErrors(t *testing.T) {
+
+	exponent := nesImage(NES_MAPPER_NROM, 1, 0)
+	exponent[7], exponent[9] = 0x08, 0x0F
+	trainer := nesImage(NES_MAPPER_NROM, 0, 0)
+	trainer[4], trainer[6] = 1, 0x04
+	cases := map[string][]uint8{
+		"short header":   []uint8("NES\x1A"),
+		"bad magic":      append([]uint8("UNIF"), nesImage(NES_MAPPER_NROM, 1, 0)[4:]...),
+		"no PRG-ROM":     nesImage(NES_MAPPER_NROM, 0, 1),
+		"exponent sizes": exponent,
+		"short trainer":  trainer,
+		"short PRG-ROM":  nesImage(NES_MAPPER_NROM, 2, 0)[:NES_HEADER_SIZE+NES_PRG_BANK],
+		"short CHR-ROM":  nesImage(NES_MAPPER_NROM, 1, 1)[:NES_HEADER_SIZE+NES_PRG_BANK+1],
+	}
+	for name, image := range cases {
+		if c, err := ParseINES(image); err == nil {
+			t.Errorf("%s: parsed %+v", name, c)
+		}
+	}
+
+	cartridge, err := ParseINES(nesImage(4, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewNES(cartridge); err == nil {
+		t.Errorf("built a NES with mapper 4")
+	}
+
+}
+
+func TestParseINESHeaders(t *testing.T) {
+
+	// Trainer, vertical mirroring and junk in the padding
+	image := nesImage(NES_MAPPER_CNROM, 1, 1)
+	image[6] |= 0x05
+	image[7] = 0x40
+	copy(image[12:], "DiskDude!")
+	image = append(image[:NES_HEADER_SIZE], append(make([]uint8, NES_TRAINER_SIZE), image[NES_HEADER_SIZE:]...)...)
+	image[NES_HEADER_SIZE] = 0xEE
+	c, err := ParseINES(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mapper != NES_MAPPER_CNROM || c.Mirroring != NES_MIRROR_VERTICAL || c.NES2 {
+		t.Errorf("parsed mapper %d, mirroring %d, NES 2.0 %v", c.Mapper, c.Mirroring, c.NES2)
+	}
+	if len(c.Trainer) != NES_TRAINER_SIZE || c.Trainer[0] != 0xEE {
+		t.Errorf("trainer of %d bytes", len(c.Trainer))
+	}
+	if len(c.PRG) != NES_PRG_BANK || len(c.CHR) != NES_CHR_BANK || c.CHRRAM != 0 ||
+		c.PRGRAM != 0x2000 {
+		t.Errorf("parsed %dK PRG-ROM, %dK CHR-ROM, %d CHR-RAM, %d work RAM",
+			len(c.PRG)/1024, len(c.CHR)/1024, c.CHRRAM, c.PRGRAM)
+	}
+	if c.CHR[0x1000] != 1 {
+		t.Errorf("CHR-ROM read from the wrong offset")
+	}
+
+	// NES 2.0: mapper 257 submapper 2, four screens, 8K+2K of work RAM
+	image = nesImage(NES_MAPPER_MMC1, 1, 0)
+	image[6] |= 0x08
+	image[7] = 0x08
+	image[8] = 0x21
+	image[10] = 0x57
+	c, err = ParseINES(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.NES2 || c.Mapper != 257 || c.Submapper != 2 || c.Mirroring != NES_MIRROR_FOUR_SCREEN {
+		t.Errorf("parsed mapper %d.%d, mirroring %d, NES 2.0 %v",
+			c.Mapper, c.Submapper, c.Mirroring, c.NES2)
+	}
+	if c.PRGRAM != 0x2000+0x800 || c.CHRRAM != 0x2000 || c.CHR != nil {
+		t.Errorf("parsed %d work RAM, %d CHR-RAM", c.PRGRAM, c.CHRRAM)
+	}
+
+}
+
+func TestReadINES(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.nes")
+	if err := os.WriteFile(path, testINES(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadINES(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mapper != NES_MAPPER_MMC1 || len(c.PRG) != 4*NES_PRG_BANK {
+		t.Errorf("read %+v", c)
+	}
+
+	if _, err := ReadINES(filepath.Join(dir, "none.nes")); err == nil {
+		t.Errorf("read a missing file")
+	}
+	if err := os.WriteFile(path, []uint8("not a ROM"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadINES(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("error %v does not name the file", err)
+	}
+
+}
+
+func TestNESUxROMBanking(t *testing.T) {
+
+	n := newTestNES(t, nesImage(NES_MAPPER_UXROM, 8, 0))
+	banks := func() (uint8, uint8) {
+		return n.Memory.Read(0xBFF0), n.Memory.Read(0xFFF0)
+	}
+	if low, high := banks(); low != 0 || high != 7 {
+		t.Errorf("powered up with banks %d and %d", low, high)
+	}
+	n.Memory.Write(0xC123, 5)
+	if low, high := banks(); low != 5 || high != 7 {
+		t.Errorf("banks %d and %d after selecting 5", low, high)
+	}
+	n.Memory.Write(0x8000, 9) // no bank 9 on a 128K board
+	if low, high := banks(); low != 1 || high != 7 {
+		t.Errorf("banks %d and %d after selecting 9", low, high)
+	}
+
+	// CHR-RAM takes writes
+	n.Memory.Write(0x2006, 0x12)
+	n.Memory.Write(0x2006, 0x34)
+	n.Memory.Write(0x2007, 0xA5)
+	if data := nesCHR(n, 0x1234); data != 0xA5 {
+		t.Errorf("CHR-RAM reads $%02X", data)
+	}
+
+}
+
+func TestNESCNROMBanking(t *testing.T) {
+
+	n := newTestNES(t, nesImage(NES_MAPPER_CNROM, 2, 4))
+	if low, high := n.Memory.Read(0xBFF0), n.Memory.Read(0xFFF0); low != 0 || high != 1 {
+		t.Errorf("PRG-ROM banks %d and %d", low, high)
+	}
+	if low, high := nesCHR(n, 0x0000), nesCHR(n, 0x1000); low != 0 || high != 1 {
+		t.Errorf("powered up with CHR %d and %d", low, high)
+	}
+	n.Memory.Write(0x8000, 2)
+	if low, high := nesCHR(n, 0x0000), nesCHR(n, 0x1000); low != 4 || high != 5 {
+		t.Errorf("CHR %d and %d after selecting 2", low, high)
+	}
+	if n.Memory.Read(0xBFF0) != 0 {
+		t.Errorf("PRG-ROM switched with CHR-ROM")
+	}
+
+	// CHR-ROM ignores writes
+	n.Memory.Write(0x2006, 0x00)
+	n.Memory.Write(0x2006, 0x00)
+	n.Memory.Write(0x2007, 0xA5)
+	if data := nesCHR(n, 0x0000); data != 4 {
+		t.Errorf("CHR-ROM written to $%02X", data)
+	}
+
+}
+
+// Loads MMC1 registers a bit at a time, LSB first
+func mmc1Load(n *NES, addr uint16, value uint8) {
+	for i := range 5 {
+		n.Memory.Write(addr, value>>i&0x01)
+	}
+}
+
+func TestNESMMC1ShiftRegister(t *testing.T) {
+
+	n := newTestNES(t, nesImage(NES_MAPPER_MMC1, 8, 0))
+	for i := range 4 {
+		n.Memory.Write(0xE000, 1)
+		if bank := n.Memory.Read(0xBFF0); bank != 0 {
+			t.Errorf("bank %d after %d writes", bank, i+1)
+		}
+	}
+	n.Memory.Write(0xE000, 0)
+	if bank := n.Memory.Read(0xBFF0); bank != 0x0F%8 {
+		t.Errorf("bank %d after 5 writes", bank)
+	}
+
+	// Bit 7 drops a part loaded value and restores the fixed last bank
+	mmc1Load(n, 0x8000, 0x08)
+	n.Memory.Write(0xE000, 1)
+	n.Memory.Write(0xE000, 1)
+	n.Memory.Write(0xA000, 0x80)
+	mmc1Load(n, 0xE000, 4)
+	if low, high := n.Memory.Read(0xBFF0), n.Memory.Read(0xFFF0); low != 4 || high != 7 {
+		t.Errorf("banks %d and %d after reset", low, high)
+	}
+
+}
+
+func TestNESMMC1PRGModes(t *testing.T) {
+
+	n := newTestNES(t, nesImage(NES_MAPPER_MMC1, 32, 0))
+	banks := func() (uint8, uint8) {
+		return n.Memory.Read(0xBFF0), n.Memory.Read(0xFFF0)
+	}
+	mmc1Load(n, 0xE000, 5)
+	modes := []struct {
+		control   uint8
+		low, high uint8
+	}{
+		{0x00, 4, 5}, // 32K, the low bit ignored
+		{0x04, 4, 5},
+		{0x08, 0, 5}, // first bank fixed at $8000
+		{0x0C, 5, 15},
+	}
+	for _, mode := range modes {
+		mmc1Load(n, 0x8000, mode.control)
+		if low, high := banks(); low != mode.low || high != mode.high {
+			t.Errorf("mode $%02X: banks %d and %d", mode.control, low, high)
+		}
+	}
+
+	// The second 256K, selected through the first CHR register
+	mmc1Load(n, 0xA000, 0x10)
+	if low, high := banks(); low != 21 || high != 31 {
+		t.Errorf("banks %d and %d in the upper 256K", low, high)
+	}
+	mmc1Load(n, 0x8000, 0x08)
+	if low, high := banks(); low != 16 || high != 21 {
+		t.Errorf("banks %d and %d with the first fixed in the upper 256K", low, high)
+	}
+
+}
+
+func TestNESMMC1CHRModes(t *testing.T) {
+
+	n := newTestNES(t, nesImage(NES_MAPPER_MMC1, 2, 4))
+	chr := func() (uint8, uint8) {
+		return nesCHR(n, 0x0000), nesCHR(n, 0x1000)
+	}
+	mmc1Load(n, 0xA000, 5) // the low bit ignored in 8K mode
+	mmc1Load(n, 0xC000, 7)
+	if low, high := chr(); low != 4 || high != 5 {
+		t.Errorf("8K mode: CHR %d and %d", low, high)
+	}
+	mmc1Load(n, 0x8000, 0x1C)
+	if low, high := chr(); low != 5 || high != 7 {
+		t.Errorf("4K mode: CHR %d and %d", low, high)
+	}
+
+}
+
+func TestNESPPUAddressAndData(t *testing.T) {
+
+	n := newTestNES(t, nesImage(NES_MAPPER_NROM, 1, 0))
+	address := func(addr uint16) {
+		n.Memory.Read(0x2002)
+		n.Memory.Write(0x2006, uint8(addr>>8))
+		n.Memory.Write(0x2006, uint8(addr))
+	}
+
+	// Writes step across, or down a column of the nametable
+	address(0x2000)
+	n.Memory.Write(0x2007, 0x11)
+	n.Memory.Write(0x2007, 0x22)
+	n.Memory.Write(0x2000, NES_PPUCTRL_INCREMENT)
+	n.Memory.Write(0x2007, 0x33)
+	n.Memory.Write(0x2007, 0x44)
+	n.Memory.Write(0x2000, 0)
+
+	// Reads come a byte late through the buffer
+	address(0x6000) // the top two bits are dropped
+	if stale := n.Memory.Read(0x2007); stale != 0x00 {
+		t.Errorf("first read $%02X, expected the empty buffer", stale)
+	}
+	var data []uint8
+	for range 3 {
+		data = append(data, n.Memory.Read(0x2007))
+	}
+	if string(data) != "\x11\x22\x33" {
+		t.Errorf("read back % X", data)
+	}
+	address(0x2022)
+	n.Memory.Read(0x2007)
+	if data := n.Memory.Read(0x2007); data != 0x44 {
+		t.Errorf("read $%02X a row below", data)
+	}
+
+	// Palette reads are immediate and leave the nametable beneath buffered;
+	// sprite entry 0 is the background entry
+	address(0x3F10)
+	n.Memory.Write(0x2007, 0x2A)
+	address(0x3F00)
+	if colour := n.Memory.Read(0x2007); colour != 0x2A {
+		t.Errorf("background colour $%02X", colour)
+	}
+	address(0x3F01)
+	n.Memory.Write(0x2007, 0x15)
+	address(0x2F01)
+	n.Memory.Write(0x2007, 0x66)
+	address(0x3F01)
+	if colour := n.Memory.Read(0x2007); colour != 0x15 {
+		t.Errorf("palette entry 1 $%02X", colour)
+	}
+	address(0x2000)
+	if data := n.Memory.Read(0x2007); data != 0x66 {
+		t.Errorf("buffered $%02X from beneath the palette", data)
+	}
+
+}
@@ -0,0 +1,274 @@
+package cpu6502
+
+import "fmt"
+
+// ----------------------------------------------------------------------------
+// memory_map.go
+// Address decoding, unmapped regions and data bus latch
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Unmapped Policies
+// ----------------------------------------------------------------------------
+// On real hardware nothing drives the data bus when an unmapped address is
+// read, so the CPU sees whatever value was last on it - usually the high byte
+// of the operand it just fetched. Each unmapped region can instead return a
+// fixed value (pull-ups, for example) or fail the access.
+// ----------------------------------------------------------------------------
+
+type UnmappedPolicy int
+
+const (
+	UNMAPPED_OPEN_BUS UnmappedPolicy = iota
+	UNMAPPED_FIXED
+	UNMAPPED_ERROR
+)
+
+// ----------------------------------------------------------------------------
+// Wait States
+// ----------------------------------------------------------------------------
+// Slow parts behind a wait-state generator hold the CPU for extra cycles on
+// each access. A region can carry a fixed number of wait states, and a device
+// that knows better can report its own per access.
+// ----------------------------------------------------------------------------
+
+type WaitStater interface {
+	WaitStates(addr uint16, write bool) int
+}
+
+// ----------------------------------------------------------------------------
+// Bus Errors
+// ----------------------------------------------------------------------------
+
+type BusError struct {
+	Address uint16
+	Write   bool
+}
+
+func (e *BusError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("write to unmapped address: %04X", e.Address)
+	}
+	return fmt.Sprintf("read from unmapped address: %04X", e.Address)
+}
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type memoryRegion struct {
+	start  uint16
+	end    uint16
+	device Bus // nil for unmapped regions
+	policy UnmappedPolicy
+	value  uint8
+	wait   int
+}
+
+type MemoryMap struct {
+	regions  []memoryRegion
+	fallback memoryRegion // used when no region covers an address
+	data_bus uint8
+	fault    error
+	waits    int
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewMemoryMap() *MemoryMap {
+	return &MemoryMap{
+		fallback: memoryRegion{
+			start:  0,
+			end:    0xFFFF,
+			policy: UNMAPPED_OPEN_BUS,
+		},
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Configuration
+// ----------------------------------------------------------------------------
+// Regions added later take precedence over earlier ones, so a device can be
+// mapped over the top of a larger RAM or ROM region. Devices are addressed
+// relative to the start of their region.
+// ----------------------------------------------------------------------------
+
+func (m *MemoryMap) Map(start uint16, end uint16, device Bus) {
+	if end < start {
+		panic(fmt.Errorf("invalid region: %04X-%04X", start, end))
+	}
+	switch memory := device.(type) {
+	case RAM:
+		if len(memory) == 0 {
+			panic(fmt.Errorf("empty RAM mapped at %04X", start))
+		}
+	case ROM:
+		if len(memory) == 0 {
+			panic(fmt.Errorf("empty ROM mapped at %04X", start))
+		}
+	}
+	m.regions = append(m.regions, memoryRegion{
+		start:  start,
+		end:    end,
+		device: device,
+	})
+}
+
+func (m *MemoryMap) MapWithWaitStates(start uint16, end uint16, device Bus, wait int) {
+	m.Map(start, end, device)
+	m.regions[len(m.regions)-1].wait = wait
+}
+
+func (m *MemoryMap) Unmap(start uint16, end uint16, policy UnmappedPolicy, value uint8) {
+	if end < start {
+		panic(fmt.Errorf("invalid region: %04X-%04X", start, end))
+	}
+	m.regions = append(m.regions, memoryRegion{
+		start:  start,
+		end:    end,
+		policy: policy,
+		value:  value,
+	})
+}
+
+func (m *MemoryMap) SetDefaultPolicy(policy UnmappedPolicy, value uint8) {
+	m.fallback.policy = policy
+	m.fallback.value = value
+}
+
+// ----------------------------------------------------------------------------
+// Data Bus Latch
+
+func (m *MemoryMap) DataBus() uint8 {
+	return m.data_bus
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+// ----------------------------------------------------------------------------
+
+func (m *MemoryMap) lookup(addr uint16) *memoryRegion {
+	for i := len(m.regions) - 1; i >= 0; i-- {
+		if addr >= m.regions[i].start && addr <= m.regions[i].end {
+			return &m.regions[i]
+		}
+	}
+	return &m.fallback
+}
+
+func (m *MemoryMap) Read(addr uint16) uint8 {
+	region := m.lookup(addr)
+	m.stretch(region, addr, false)
+	if region.device != nil {
+		m.data_bus = region.device.Read(addr - region.start)
+		return m.data_bus
+	}
+
+	switch region.policy {
+	case UNMAPPED_FIXED:
+		m.data_bus = region.value
+	case UNMAPPED_ERROR:
+		m.raise(&BusError{Address: addr})
+	}
+	return m.data_bus
+}
+
+func (m *MemoryMap) Write(addr uint16, data uint8) {
+	m.data_bus = data
+	region := m.lookup(addr)
+	m.stretch(region, addr, true)
+	if region.device != nil {
+		region.device.Write(addr-region.start, data)
+		return
+	}
+
+	if region.policy == UNMAPPED_ERROR {
+		m.raise(&BusError{Address: addr, Write: true})
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Wait States
+
+func (m *MemoryMap) stretch(region *memoryRegion, addr uint16, write bool) {
+	m.waits += region.wait
+	if slow, ok := region.device.(WaitStater); ok {
+		m.waits += slow.WaitStates(addr-region.start, write)
+	}
+}
+
+func (m *MemoryMap) TakeWaitStates() int {
+	waits := m.waits
+	m.waits = 0
+	return waits
+}
+
+// ----------------------------------------------------------------------------
+// Faults
+
+func (m *MemoryMap) raise(err error) {
+	if m.fault == nil {
+		m.fault = err
+	}
+}
+
+func (m *MemoryMap) TakeFault() error {
+	err := m.fault
+	m.fault = nil
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// Memory Devices
+// ----------------------------------------------------------------------------
+// Both wrap around when addressed past their size, which mirrors them across
+// a region larger than the part itself.
+// ----------------------------------------------------------------------------
+
+type RAM []uint8
+
+func NewRAM(size int) RAM {
+	if size <= 0 {
+		panic(fmt.Errorf("invalid RAM size: %d", size))
+	}
+	return make(RAM, size)
+}
+
+func (r RAM) Read(addr uint16) uint8 {
+	return r[int(addr)%len(r)]
+}
+
+func (r RAM) Write(addr uint16, data uint8) {
+	r[int(addr)%len(r)] = data
+}
+
+type ROM []uint8
+
+func (r ROM) Read(addr uint16) uint8 {
+	return r[int(addr)%len(r)]
+}
+
+func (r ROM) Write(uint16, uint8) {
+}
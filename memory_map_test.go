@@ -0,0 +1,212 @@
+package cpu6502
+
+import (
+	"errors"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// memory_map_test.go
+// Tests the memory map and unmapped region policies
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Open Bus
+// ----------------------------------------------------------------------------
+
+func TestOpenBusReturnsOperandHighByte(t *testing.T) {
+
+	ram := NewRAM(0x10000)
+	copy(ram, []uint8{0xAD, 0x12, 0xC0}) // LDA $C012
+
+	memory := NewMemoryMap()
+	memory.Map(0x0000, 0xFFFF, ram)
+	memory.Unmap(0xC000, 0xCFFF, UNMAPPED_OPEN_BUS, 0)
+
+	cpu := NewCPU(memory)
+	cpu.program_counter = 0
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.accumulator != 0xC0 {
+		t.Errorf("expected open bus value C0, got %02X", cpu.accumulator)
+	}
+
+}
+
+func TestFixedUnmappedValue(t *testing.T) {
+
+	memory := NewMemoryMap()
+	memory.Map(0x0000, 0x00FF, NewRAM(0x100))
+	memory.Unmap(0x1000, 0x1FFF, UNMAPPED_FIXED, 0xFF)
+
+	memory.Write(0x0010, 0x42)
+	if value := memory.Read(0x1234); value != 0xFF {
+		t.Errorf("expected fixed value FF, got %02X", value)
+	}
+	if value := memory.Read(0x2000); value != 0xFF {
+		t.Errorf("expected open bus value FF, got %02X", value)
+	}
+	memory.Read(0x0010)
+	if value := memory.Read(0x2000); value != 0x42 {
+		t.Errorf("expected open bus value 42, got %02X", value)
+	}
+
+}
+
+func TestUnmappedErrorFaultsCPU(t *testing.T) {
+
+	ram := NewRAM(0x10000)
+	copy(ram, []uint8{0x8D, 0x00, 0xD0}) // STA $D000
+
+	memory := NewMemoryMap()
+	memory.Map(0x0000, 0xFFFF, ram)
+	memory.Unmap(0xD000, 0xDFFF, UNMAPPED_ERROR, 0)
+
+	cpu := NewCPU(memory)
+	cpu.program_counter = 0
+	err := cpu.ExecuteCycle()
+
+	var busError *BusError
+	if !errors.As(err, &busError) {
+		t.Fatalf("expected bus error, got %v", err)
+	}
+	if busError.Address != 0xD000 || !busError.Write {
+		t.Errorf("unexpected bus error: %v", busError)
+	}
+
+}
+
+// A fetch that faults is reported as the fault, and an undefined opcode
+// fetched from slow memory still stalls for it
+func TestBusCollectedOnDecodeErrors(t *testing.T) {
+
+	rom := ROM(make([]uint8, 0x4000))
+	rom[0] = 0x02 // undefined
+	memory := NewMemoryMap()
+	memory.Map(0x0000, 0x3FFF, NewRAM(0x4000))
+	memory.Unmap(0x4000, 0x7FFF, UNMAPPED_ERROR, 0)
+	memory.MapWithWaitStates(0x8000, 0xBFFF, rom, 3)
+	memory.Map(0xC000, 0xFFFF, NewRAM(0x4000))
+
+	cpu := NewCPU(memory)
+	cpu.program_counter = 0x4000
+	cpu.remaining_cycles = 0
+	var busError *BusError
+	if err := cpu.ExecuteCycle(); !errors.As(err, &busError) || busError.Address != 0x4000 {
+		t.Errorf("expected bus error fetching from $4000, got %v", err)
+	}
+	if err := memory.TakeFault(); err != nil {
+		t.Errorf("fault left on the bus: %v", err)
+	}
+
+	cpu.program_counter = 0x8000
+	cpu.remaining_cycles = 0
+	if err := cpu.ExecuteCycle(); err == nil {
+		t.Errorf("undefined opcode executed")
+	}
+	if waits := memory.TakeWaitStates(); waits != 0 || cpu.remaining_cycles != 3 {
+		t.Errorf("%d wait states left on the bus, %d taken", waits, cpu.remaining_cycles)
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Wait States
+// ----------------------------------------------------------------------------
+
+func TestWaitStatesStretchInstruction(t *testing.T) {
+
+	ram := NewRAM(0x8000)
+	copy(ram, []uint8{0xAD, 0x00, 0x80}) // LDA $8000
+
+	memory := NewMemoryMap()
+	memory.Map(0x0000, 0x7FFF, ram)
+	memory.MapWithWaitStates(0x8000, 0xFFFF, ROM(make([]uint8, 0x8000)), 2)
+
+	// Reset reads the two bytes of its vector from the slow ROM
+	cpu := NewCPU(memory)
+	if cpu.remaining_cycles != 4 || memory.TakeWaitStates() != 0 {
+		t.Errorf("reset left %d cycles remaining", cpu.remaining_cycles)
+	}
+	cpu.program_counter = 0
+	cpu.remaining_cycles = 0
+
+	// LDA absolute takes four cycles, plus two for the slow read
+	cycles := 0
+	for {
+		if err := cpu.ExecuteCycle(); err != nil {
+			t.Fatal(err)
+		}
+		cycles++
+		if cpu.remaining_cycles == 0 {
+			break
+		}
+	}
+	if cycles != 6 {
+		t.Errorf("expected 6 cycles, got %d", cycles)
+	}
+
+}
+
+func TestWaitStatesStretchInterrupt(t *testing.T) {
+
+	memory := NewMemoryMap()
+	memory.Map(0x0000, 0x7FFF, NewRAM(0x8000))
+	memory.MapWithWaitStates(0x8000, 0xFFFF, ROM(make([]uint8, 0x8000)), 1)
+
+	cpu := NewCPU(memory)
+	cpu.remaining_cycles = 0
+
+	// Seven cycles, plus one for each byte of the vector
+	cpu.NMILine().Assert("test")
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if waits := memory.TakeWaitStates(); waits != 0 || cpu.remaining_cycles != 8 {
+		t.Errorf("%d wait states left on the bus, %d cycles remaining", waits, cpu.remaining_cycles)
+	}
+
+}
+
+func TestEmptyMemoryRejected(t *testing.T) {
+
+	for _, device := range []Bus{RAM{}, ROM{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("mapped empty %T", device)
+				}
+			}()
+			NewMemoryMap().Map(0x0000, 0x00FF, device)
+		}()
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewRAM(0) did not panic")
+		}
+	}()
+	NewRAM(0)
+
+}
@@ -0,0 +1,126 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// port.go
+// Parallel I/O ports shared by the VIA, RIOT and PIA
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Interfaces
+// ----------------------------------------------------------------------------
+// Anything wired to port pins - an LCD, an SD card, a keyboard - implements
+// PortPeripheral. Pins nobody drives are pulled high, and several peripherals
+// on one port combine as open-collector outputs, so a peripheral returns 1 for
+// every pin it leaves alone.
+// ----------------------------------------------------------------------------
+
+type PortPeripheral interface {
+	PortOutput(pins uint8) // Called whenever the output pins change
+	PortInput() uint8      // Levels driven onto the pins by the peripheral
+}
+
+// ----------------------------------------------------------------------------
+// Port
+// ----------------------------------------------------------------------------
+
+type Port struct {
+	output      uint8 // output register
+	direction   uint8 // data direction register, 1 = output
+	override    uint8 // pins driven by the chip itself (PB7 from a timer)
+	forced      uint8 // levels on the overridden pins
+	peripherals []PortPeripheral
+	on_input    func() // chip hook for edge detection on inputs
+}
+
+func (p *Port) Connect(peripheral PortPeripheral) {
+	p.peripherals = append(p.peripherals, peripheral)
+	peripheral.PortOutput(p.driven())
+}
+
+// Peripherals call this when the levels they drive change, for chips that
+// detect edges on their port inputs
+func (p *Port) InputChanged() {
+	if p.on_input != nil {
+		p.on_input()
+	}
+}
+
+// Levels on the pins, combining outputs with whatever drives the inputs
+func (p *Port) Pins() uint8 {
+	outputs := p.direction | p.override
+	return p.driven()&outputs | p.input()&^outputs
+}
+
+// ----------------------------------------------------------------------------
+// Chip Side
+
+// Levels on the output pins, with inputs floating high
+func (p *Port) driven() uint8 {
+	pins := p.output&p.direction | ^p.direction
+	return pins&^p.override | p.forced&p.override
+}
+
+func (p *Port) input() uint8 {
+	pins := uint8(0xFF)
+	for _, peripheral := range p.peripherals {
+		pins &= peripheral.PortInput()
+	}
+	return pins
+}
+
+func (p *Port) notify() {
+	pins := p.driven()
+	for _, peripheral := range p.peripherals {
+		peripheral.PortOutput(pins)
+	}
+}
+
+func (p *Port) set_output(data uint8) {
+	if p.output != data {
+		p.output = data
+		p.notify()
+	}
+}
+
+func (p *Port) set_direction(data uint8) {
+	if p.direction != data {
+		p.direction = data
+		p.notify()
+	}
+}
+
+func (p *Port) force(mask uint8, levels uint8) {
+	if p.override != mask || p.forced&mask != levels&mask {
+		p.override = mask
+		p.forced = levels & mask
+		p.notify()
+	}
+}
+
+func (p *Port) reset() {
+	p.output = 0
+	p.direction = 0
+	p.override = 0
+	p.forced = 0
+	p.notify()
+}
@@ -0,0 +1,205 @@
+package cpu6502
+
+import "fmt"
+
+// ----------------------------------------------------------------------------
+// protection.go
+// Memory access permissions and violation reporting
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Permissions
+// ----------------------------------------------------------------------------
+
+type Permission uint8
+
+const (
+	PERMIT_READ Permission = 1 << iota
+	PERMIT_WRITE
+	PERMIT_EXECUTE
+)
+
+const (
+	PERMIT_NONE       Permission = 0
+	PERMIT_ALL                   = PERMIT_READ | PERMIT_WRITE | PERMIT_EXECUTE
+	PERMIT_READ_ONLY             = PERMIT_READ | PERMIT_EXECUTE
+	PERMIT_NO_EXECUTE            = PERMIT_READ | PERMIT_WRITE
+)
+
+// ----------------------------------------------------------------------------
+// Access Kinds
+// ----------------------------------------------------------------------------
+
+type AccessKind int
+
+const (
+	ACCESS_READ AccessKind = iota
+	ACCESS_WRITE
+	ACCESS_EXECUTE
+)
+
+func (k AccessKind) String() string {
+	switch k {
+	case ACCESS_READ:
+		return "read"
+	case ACCESS_WRITE:
+		return "write"
+	case ACCESS_EXECUTE:
+		return "execute"
+	default:
+		return fmt.Sprintf("access(%d)", int(k))
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Violations
+// ----------------------------------------------------------------------------
+
+type AccessViolation struct {
+	PC      uint16 // Address of the instruction making the access
+	Address uint16
+	Kind    AccessKind
+}
+
+func (v *AccessViolation) Error() string {
+	return fmt.Sprintf("%s violation at %04X (PC=%04X)", v.Kind, v.Address, v.PC)
+}
+
+// ----------------------------------------------------------------------------
+// Protected Bus
+// ----------------------------------------------------------------------------
+// Wraps any Bus and checks each access against the permissions of the region
+// it falls in. Addresses outside every protected region are unrestricted.
+//
+// A violation is passed to OnViolation when it is set; otherwise it is raised
+// as a fault and returned from the CPU's ExecuteCycle. Denied writes never
+// reach the wrapped bus. Denied reads and fetches still do, since the CPU
+// needs a value to carry on with. The opcode read that follows a fetch is
+// checked as an execute only, so it is never reported as a read as well.
+// ----------------------------------------------------------------------------
+
+type protectedRegion struct {
+	start      uint16
+	end        uint16
+	permission Permission
+}
+
+type ProtectedBus struct {
+	bus         Bus
+	regions     []protectedRegion
+	pc          uint16
+	fetching    bool // the next read is the opcode at pc
+	fault       error
+	OnViolation func(*AccessViolation)
+}
+
+func NewProtectedBus(bus Bus) *ProtectedBus {
+	return &ProtectedBus{bus: bus}
+}
+
+// Regions added later take precedence over earlier ones.
+func (p *ProtectedBus) Protect(start uint16, end uint16, permission Permission) {
+	if end < start {
+		panic(fmt.Errorf("invalid region: %04X-%04X", start, end))
+	}
+	p.regions = append(p.regions, protectedRegion{
+		start:      start,
+		end:        end,
+		permission: permission,
+	})
+}
+
+func (p *ProtectedBus) permission(addr uint16) Permission {
+	for i := len(p.regions) - 1; i >= 0; i-- {
+		if addr >= p.regions[i].start && addr <= p.regions[i].end {
+			return p.regions[i].permission
+		}
+	}
+	return PERMIT_ALL
+}
+
+// Returns false if the access was denied
+func (p *ProtectedBus) check(addr uint16, kind AccessKind, required Permission) bool {
+	if p.permission(addr)&required != 0 {
+		return true
+	}
+
+	violation := &AccessViolation{PC: p.pc, Address: addr, Kind: kind}
+	if p.OnViolation != nil {
+		p.OnViolation(violation)
+	} else if p.fault == nil {
+		p.fault = violation
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Bus Implementation
+
+func (p *ProtectedBus) Read(addr uint16) uint8 {
+	if !p.fetching || addr != p.pc {
+		p.check(addr, ACCESS_READ, PERMIT_READ)
+	}
+	p.fetching = false
+	return p.bus.Read(addr)
+}
+
+func (p *ProtectedBus) Write(addr uint16, data uint8) {
+	if p.check(addr, ACCESS_WRITE, PERMIT_WRITE) {
+		p.bus.Write(addr, data)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Optional Bus Interfaces
+// ----------------------------------------------------------------------------
+// These are passed through to the wrapped bus so wrapping never hides its
+// faults, wait states or fetch tracking.
+// ----------------------------------------------------------------------------
+
+func (p *ProtectedBus) Fetch(pc uint16) {
+	p.pc = pc
+	p.fetching = true
+	p.check(pc, ACCESS_EXECUTE, PERMIT_EXECUTE)
+	if observer, ok := p.bus.(FetchObserver); ok {
+		observer.Fetch(pc)
+	}
+}
+
+func (p *ProtectedBus) TakeFault() error {
+	err := p.fault
+	p.fault = nil
+	if faulter, ok := p.bus.(BusFaulter); ok {
+		if inner := faulter.TakeFault(); err == nil {
+			err = inner
+		}
+	}
+	return err
+}
+
+func (p *ProtectedBus) TakeWaitStates() int {
+	if stretcher, ok := p.bus.(ClockStretcher); ok {
+		return stretcher.TakeWaitStates()
+	}
+	return 0
+}
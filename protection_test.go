@@ -0,0 +1,135 @@
+package cpu6502
+
+import (
+	"errors"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// protection_test.go
+// Tests memory access permissions
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Violations
+// ----------------------------------------------------------------------------
+
+func TestWriteToROMIsReported(t *testing.T) {
+
+	ram := NewRAM(0x10000)
+	copy(ram[0x0200:], []uint8{0x8D, 0x00, 0xE0}) // STA $E000
+
+	bus := NewProtectedBus(ram)
+	bus.Protect(0xE000, 0xFFFF, PERMIT_READ_ONLY)
+
+	cpu := NewCPU(bus)
+	cpu.program_counter = 0x0200
+	cpu.accumulator = 0x55
+	err := cpu.ExecuteCycle()
+
+	var violation *AccessViolation
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected access violation, got %v", err)
+	}
+	if violation.PC != 0x0200 || violation.Address != 0xE000 ||
+		violation.Kind != ACCESS_WRITE {
+		t.Errorf("unexpected violation: %v", violation)
+	}
+	if ram[0xE000] != 0 {
+		t.Errorf("denied write reached memory")
+	}
+
+}
+
+func TestExecuteFromIOIsReported(t *testing.T) {
+
+	ram := NewRAM(0x10000)
+	copy(ram[0xD000:], []uint8{0xA9, 0x01}) // LDA #$01
+
+	bus := NewProtectedBus(ram)
+	bus.Protect(0xD000, 0xDFFF, PERMIT_NO_EXECUTE)
+
+	var violations []*AccessViolation
+	bus.OnViolation = func(v *AccessViolation) {
+		violations = append(violations, v)
+	}
+
+	cpu := NewCPU(bus)
+	cpu.program_counter = 0xD000
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Kind != ACCESS_EXECUTE ||
+		violations[0].Address != 0xD000 {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+
+}
+
+func TestFetchIsNotAlsoARead(t *testing.T) {
+
+	ram := NewRAM(0x10000)
+	copy(ram[0x0200:], []uint8{0xAD, 0x00, 0xC0}) // LDA $C000
+	ram[0xC000] = 0xEA                            // NOP
+	ram[0xD000] = 0xEA
+
+	bus := NewProtectedBus(ram)
+	bus.Protect(0xC000, 0xCFFF, PERMIT_EXECUTE)
+	bus.Protect(0xD000, 0xDFFF, PERMIT_NONE)
+
+	var violations []*AccessViolation
+	bus.OnViolation = func(v *AccessViolation) {
+		violations = append(violations, v)
+	}
+
+	// Execute only: running it passes, reading it as data does not
+	cpu := NewCPU(bus)
+	cpu.program_counter = 0xC000
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("fetch reported: %v", violations)
+	}
+	cpu.program_counter = 0x0200
+	cpu.remaining_cycles = 0
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Kind != ACCESS_READ ||
+		violations[0].Address != 0xC000 || violations[0].PC != 0x0200 {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+
+	// No access: one violation per fetch
+	violations = nil
+	cpu.program_counter = 0xD000
+	cpu.remaining_cycles = 0
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Kind != ACCESS_EXECUTE {
+		t.Errorf("unexpected violations: %v", violations)
+	}
+
+}
@@ -0,0 +1,250 @@
+package cpu6502
+
+import "fmt"
+
+// ----------------------------------------------------------------------------
+// scheduler.go
+// Event driven device scheduling in lockstep with the CPU
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+// Each device runs at a fixed ratio to the CPU clock, given as a number of
+// device cycles per number of CPU cycles (3:1 for a NES PPU). Device time is
+// always derived from CPU time with integer arithmetic, so fractional ratios
+// never drift.
+// ----------------------------------------------------------------------------
+
+type scheduledDevice struct {
+	name          string
+	device        Device
+	device_cycles uint64
+	cpu_cycles    uint64
+	elapsed       uint64 // device cycles ticked so far
+	due           uint64 // CPU cycle of the next event
+	line          *InterruptLine
+}
+
+type Scheduler struct {
+	cpu      *CPU
+	devices  []*scheduledDevice
+	now      uint64
+	next_due uint64
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+func NewScheduler(cpu *CPU) *Scheduler {
+	return &Scheduler{
+		cpu:      cpu,
+		next_due: NO_EVENT,
+	}
+}
+
+func (s *Scheduler) Attach(name string, device Device, device_cycles uint64, cpu_cycles uint64) {
+	if device_cycles == 0 || cpu_cycles == 0 {
+		panic(fmt.Errorf("invalid clock ratio for %s: %d:%d", name, device_cycles, cpu_cycles))
+	}
+	entry := &scheduledDevice{
+		name:          name,
+		device:        device,
+		device_cycles: device_cycles,
+		cpu_cycles:    cpu_cycles,
+		elapsed:       s.now * device_cycles / cpu_cycles,
+		line:          s.cpu.IRQLine(),
+	}
+	s.devices = append(s.devices, entry)
+	s.reschedule(entry)
+}
+
+// Devices drive the CPU's IRQ line by default. Wire a device to the NMI line,
+// to a line of its own, or to nil to leave its output unconnected.
+func (s *Scheduler) Wire(device Device, line *InterruptLine) {
+	entry := s.find(device)
+	if entry.line != nil {
+		entry.line.Release(entry.name)
+	}
+	entry.line = line
+	s.signal(entry)
+}
+
+// Wraps a device so that it is brought up to date before every register
+// access and rescheduled afterwards, since an access may start or stop a
+// timer. Map the returned Bus in place of the device itself.
+func (s *Scheduler) Clocked(device BusDevice) Bus {
+	return &clockedBus{scheduler: s, entry: s.find(device), bus: device}
+}
+
+// Like Clocked, but brings every device up to date around each access. Use
+// it for a device whose port pins drive other attached devices, such as a
+// VIA with an LCD on its ports, so they see the access at the right time.
+func (s *Scheduler) Synced(device BusDevice) Bus {
+	return &clockedBus{scheduler: s, entry: s.find(device), bus: device, all: true}
+}
+
+func (s *Scheduler) find(device Device) *scheduledDevice {
+	for _, entry := range s.devices {
+		if entry.device == device {
+			return entry
+		}
+	}
+	panic(fmt.Errorf("device is not attached to the scheduler"))
+}
+
+// ----------------------------------------------------------------------------
+// State
+// ----------------------------------------------------------------------------
+
+func (s *Scheduler) Now() uint64 {
+	return s.now
+}
+
+// ----------------------------------------------------------------------------
+// Execution
+// ----------------------------------------------------------------------------
+
+func (s *Scheduler) Reset() {
+	for _, entry := range s.devices {
+		entry.device.Reset()
+		s.reschedule(entry)
+	}
+	s.cpu.Reset()
+}
+
+func (s *Scheduler) Run(cycles uint64) error {
+	for range cycles {
+		if err := s.cpu.ExecuteCycle(); err != nil {
+			return err
+		}
+		s.now++
+		if s.now >= s.next_due {
+			s.service()
+		}
+	}
+	return nil
+}
+
+// Brings every device up to the current cycle
+func (s *Scheduler) Sync() {
+	for _, entry := range s.devices {
+		s.catch_up(entry)
+		s.reschedule(entry)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Event Handling
+
+func (s *Scheduler) service() {
+	for _, entry := range s.devices {
+		if entry.due <= s.now {
+			s.catch_up(entry)
+			s.reschedule(entry)
+		}
+	}
+}
+
+func (s *Scheduler) catch_up(entry *scheduledDevice) {
+	target := s.now * entry.device_cycles / entry.cpu_cycles
+	if target > entry.elapsed {
+		entry.device.Tick(target - entry.elapsed)
+		entry.elapsed = target
+	}
+}
+
+// Called after every tick or access, either of which may change the
+// device's next event and its IRQ output
+func (s *Scheduler) reschedule(entry *scheduledDevice) {
+	s.signal(entry)
+
+	next := entry.device.NextEvent()
+	if next == NO_EVENT {
+		entry.due = NO_EVENT
+	} else {
+		// Round up to the first CPU cycle at or after the event
+		event := entry.elapsed + max(next, 1)
+		entry.due = (event*entry.cpu_cycles + entry.device_cycles - 1) / entry.device_cycles
+	}
+
+	s.next_due = NO_EVENT
+	for _, e := range s.devices {
+		s.next_due = min(s.next_due, e.due)
+	}
+}
+
+func (s *Scheduler) signal(entry *scheduledDevice) {
+	if entry.line != nil {
+		entry.line.Set(entry.name, entry.device.IRQ())
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Clocked Bus
+// ----------------------------------------------------------------------------
+
+type clockedBus struct {
+	scheduler *Scheduler
+	entry     *scheduledDevice
+	bus       Bus
+	all       bool // sync every device, not just this one
+}
+
+func (c *clockedBus) before() {
+	if c.all {
+		c.scheduler.Sync()
+		return
+	}
+	c.scheduler.catch_up(c.entry)
+}
+
+func (c *clockedBus) after() {
+	if c.all {
+		c.scheduler.Sync()
+		return
+	}
+	c.scheduler.reschedule(c.entry)
+}
+
+func (c *clockedBus) Read(addr uint16) uint8 {
+	c.before()
+	data := c.bus.Read(addr)
+	c.after()
+	return data
+}
+
+func (c *clockedBus) Write(addr uint16, data uint8) {
+	c.before()
+	c.bus.Write(addr, data)
+	c.after()
+}
+
+// Wait states reported by the device are passed through to the memory map
+func (c *clockedBus) WaitStates(addr uint16, write bool) int {
+	if slow, ok := c.bus.(WaitStater); ok {
+		return slow.WaitStates(addr, write)
+	}
+	return 0
+}
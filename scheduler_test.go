@@ -0,0 +1,259 @@
+package cpu6502
+
+import "testing"
+
+// ----------------------------------------------------------------------------
+// scheduler_test.go
+// Tests device scheduling
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Test Timer
+// ----------------------------------------------------------------------------
+// Counts down from a value written to its register and holds IRQ once it
+// reaches zero. Reading the register acknowledges the interrupt.
+// ----------------------------------------------------------------------------
+
+type testTimer struct {
+	counter uint64
+	ticks   uint64
+	calls   int
+	irq     bool
+}
+
+func (d *testTimer) Reset() {
+	*d = testTimer{}
+}
+
+func (d *testTimer) Tick(cycles uint64) {
+	d.calls++
+	d.ticks += cycles
+	if d.counter > 0 {
+		if cycles >= d.counter {
+			d.counter = 0
+			d.irq = true
+		} else {
+			d.counter -= cycles
+		}
+	}
+}
+
+func (d *testTimer) NextEvent() uint64 {
+	if d.counter == 0 {
+		return NO_EVENT
+	}
+	return d.counter
+}
+
+func (d *testTimer) IRQ() bool {
+	return d.irq
+}
+
+func (d *testTimer) Read(uint16) uint8 {
+	d.irq = false
+	return uint8(d.counter)
+}
+
+func (d *testTimer) Write(addr uint16, data uint8) {
+	d.counter = uint64(data)
+}
+
+// A CPU running LDA #$A9 through the whole of memory
+func newIdleCPU() (*CPU, RAM) {
+	ram := NewRAM(0x10000)
+	for i := range ram {
+		ram[i] = 0xA9
+	}
+	return NewCPU(ram), ram
+}
+
+// ----------------------------------------------------------------------------
+// Scheduling
+// ----------------------------------------------------------------------------
+
+func TestSchedulerFiresEventsOnTime(t *testing.T) {
+
+	cpu, _ := newIdleCPU()
+	scheduler := NewScheduler(cpu)
+	timer := &testTimer{}
+	scheduler.Attach("timer", timer, 1, 1)
+	bus := scheduler.Clocked(timer)
+
+	bus.Write(0, 100)
+	if err := scheduler.Run(99); err != nil {
+		t.Fatal(err)
+	}
+	if timer.irq {
+		t.Errorf("timer fired early")
+	}
+	if timer.calls != 0 {
+		t.Errorf("idle timer ticked %d times", timer.calls)
+	}
+	if err := scheduler.Run(1); err != nil {
+		t.Fatal(err)
+	}
+	if !timer.irq || !cpu.IRQLine().Asserted() {
+		t.Errorf("timer did not fire")
+	}
+
+	bus.Read(0)
+	if cpu.IRQLine().Asserted() {
+		t.Errorf("IRQ not acknowledged")
+	}
+
+}
+
+func TestSchedulerFractionalRatio(t *testing.T) {
+
+	cpu, _ := newIdleCPU()
+	scheduler := NewScheduler(cpu)
+	fast := &testTimer{}
+	slow := &testTimer{}
+	scheduler.Attach("fast", fast, 3, 1)
+	scheduler.Attach("slow", slow, 2, 3)
+
+	if err := scheduler.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	scheduler.Sync()
+	if fast.ticks != 3000 {
+		t.Errorf("expected 3000 fast ticks, got %d", fast.ticks)
+	}
+	if slow.ticks != 666 {
+		t.Errorf("expected 666 slow ticks, got %d", slow.ticks)
+	}
+
+}
+
+// A device whose register drives another, as a port drives a peripheral
+type testLink struct {
+	testTimer
+	peripheral *testTimer
+}
+
+func (d *testLink) Write(addr uint16, data uint8) {
+	d.peripheral.Write(addr, data)
+}
+
+func TestSyncedBusSchedulesPeripheral(t *testing.T) {
+
+	cpu, _ := newIdleCPU()
+	scheduler := NewScheduler(cpu)
+	link := &testLink{peripheral: &testTimer{}}
+	scheduler.Attach("link", link, 1, 1)
+	scheduler.Attach("peripheral", link.peripheral, 1, 1)
+	bus := scheduler.Synced(link)
+
+	if err := scheduler.Run(50); err != nil {
+		t.Fatal(err)
+	}
+	bus.Write(0, 10)
+	if err := scheduler.Run(10); err != nil {
+		t.Fatal(err)
+	}
+	if !link.peripheral.irq {
+		t.Errorf("peripheral did not fire")
+	}
+	if link.peripheral.ticks != 60 {
+		t.Errorf("expected 60 peripheral ticks, got %d", link.peripheral.ticks)
+	}
+
+}
+
+// ----------------------------------------------------------------------------
+// Interrupts
+// ----------------------------------------------------------------------------
+
+func TestDeviceInterruptsCPU(t *testing.T) {
+
+	cpu, ram := newIdleCPU()
+	ram[0x0200] = 0x58                      // CLI
+	copy(ram[0xFFFE:], []uint8{0x00, 0x30}) // IRQ vector $3000
+	copy(ram[0xFFFC:], []uint8{0x00, 0x02}) // Reset vector $0200
+	cpu.Reset()
+
+	scheduler := NewScheduler(cpu)
+	timer := &testTimer{}
+	scheduler.Attach("timer", timer, 1, 1)
+	scheduler.Clocked(timer).Write(0, 10)
+
+	if err := scheduler.Run(20); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.program_counter < 0x3000 || cpu.program_counter > 0x3010 {
+		t.Errorf("IRQ not taken, PC=%04X", cpu.program_counter)
+	}
+	if !cpu.is_set(FLAG_IRQ) {
+		t.Errorf("interrupt disable not set by IRQ")
+	}
+	if sources := cpu.IRQLine().Sources(); len(sources) != 1 || sources[0] != "timer" {
+		t.Errorf("unexpected IRQ sources: %v", sources)
+	}
+
+}
+
+func TestInterruptLineWiredOr(t *testing.T) {
+
+	line := NewInterruptLine()
+	line.Assert("via")
+	line.Assert("acia")
+	line.Release("via")
+	if !line.Asserted() {
+		t.Errorf("line released while acia still holds it")
+	}
+	line.Release("acia")
+	if line.Asserted() {
+		t.Errorf("line held with no sources")
+	}
+
+}
+
+func TestNMIIsEdgeTriggered(t *testing.T) {
+
+	cpu, ram := newIdleCPU()
+	copy(ram[0xFFFA:], []uint8{0x00, 0x40}) // NMI vector $4000
+	copy(ram[0xFFFC:], []uint8{0x00, 0x02}) // Reset vector $0200
+	cpu.Reset()
+
+	// A pulse shorter than an instruction is still taken
+	cpu.NMILine().Assert("button")
+	cpu.NMILine().Release("button")
+	if err := cpu.ExecuteCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.program_counter != 0x4000 {
+		t.Errorf("NMI not taken, PC=%04X", cpu.program_counter)
+	}
+
+	// Holding the line does not retrigger
+	cpu.NMILine().Assert("button")
+	for range 20 {
+		if err := cpu.ExecuteCycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpu.stack_pointer != 0xFD-6 {
+		t.Errorf("expected two NMIs, stack pointer %02X", cpu.stack_pointer)
+	}
+
+}
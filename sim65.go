@@ -0,0 +1,397 @@
+package cpu6502
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// sim65.go
+// Binaries for cc65's sim65 target, with paravirtualised host I/O
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Binary Format
+// ----------------------------------------------------------------------------
+// A 12 byte header: "sim65", the version, the CPU type, the zero page
+// address of the C stack pointer, then the load and reset addresses, low
+// byte first. The rest of the file is loaded into 64K of RAM at the load
+// address and the reset vector pointed at the reset address.
+// ----------------------------------------------------------------------------
+
+const (
+	SIM65_MAGIC       = "sim65"
+	SIM65_VERSION     = 2
+	SIM65_CPU_6502    = 0
+	SIM65_HEADER_SIZE = 12
+)
+
+// ----------------------------------------------------------------------------
+// Paravirtualisation
+// ----------------------------------------------------------------------------
+// The cc65 runtime reaches the host with JSR to six addresses below the
+// vectors. Arguments follow the cc65 convention, the last in A/X and the rest
+// on the C stack, and results come back in A/X with -1 for failure. File
+// descriptors 0-2 are the standard streams; files opened by the program are
+// host files.
+// ----------------------------------------------------------------------------
+
+const (
+	SIM65_OPEN  = 0xFFF4
+	SIM65_CLOSE = 0xFFF5
+	SIM65_READ  = 0xFFF6
+	SIM65_WRITE = 0xFFF7
+	SIM65_ARGS  = 0xFFF8
+	SIM65_EXIT  = 0xFFF9
+)
+
+// open() flags from cc65's fcntl.h
+const (
+	sim65ReadOnly  = 0x01
+	sim65WriteOnly = 0x02
+	sim65ReadWrite = 0x03
+	sim65Create    = 0x10
+	sim65Truncate  = 0x20
+	sim65Append    = 0x40
+	sim65Exclusive = 0x80
+	sim65PathSize  = 1024
+)
+
+var errSim65Exit = errors.New("sim65 program exited")
+
+// ----------------------------------------------------------------------------
+// Structures
+// ----------------------------------------------------------------------------
+
+type Sim65 struct {
+	Machine
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	sp     uint16 // zero page address of the C stack pointer
+	args   []string
+	files  map[uint16]*os.File
+	closed [3]bool // standard streams the program has closed
+	code   int
+}
+
+// ----------------------------------------------------------------------------
+// Initialization
+// ----------------------------------------------------------------------------
+
+// Reads a sim65 binary, which sees its path as argv[0] followed by args
+func ReadSim65(path string, args ...string) (*Sim65, error) {
+	binary, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := LoadSim65(binary, append([]string{path}, args...))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Loads a sim65 binary with the full argument vector. The standard streams
+// are the host's until replaced.
+func LoadSim65(binary []uint8, args []string) (*Sim65, error) {
+	if len(binary) < SIM65_HEADER_SIZE || string(binary[:5]) != SIM65_MAGIC {
+		return nil, fmt.Errorf("not a sim65 binary")
+	}
+	if binary[5] != SIM65_VERSION {
+		return nil, fmt.Errorf("sim65 header version %d, expected %d", binary[5], SIM65_VERSION)
+	}
+	if binary[6] != SIM65_CPU_6502 {
+		return nil, fmt.Errorf("sim65 CPU type %d is not supported, only the 6502", binary[6])
+	}
+	load := uint16(binary[8]) | uint16(binary[9])<<8
+	program := binary[SIM65_HEADER_SIZE:]
+	if int(load)+len(program) > 0x10000 {
+		return nil, fmt.Errorf("program at $%04X runs past $FFFF", load)
+	}
+
+	s := &Sim65{
+		Machine: *NewMachine(),
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		sp:      uint16(binary[7]),
+		args:    args,
+		files:   map[uint16]*os.File{},
+	}
+	ram := NewRAM(0x10000)
+	copy(ram[load:], program)
+	copy(ram[VECTOR_RESET:], binary[10:12])
+	s.Memory.Map(0x0000, 0xFFFF, ram)
+
+	calls := []func(*Registers){s.open, s.close, s.read, s.write, s.arguments, s.exit}
+	for i, call := range calls {
+		addr := SIM65_OPEN + uint16(i)
+		s.CPU.AddTrap(addr, func(registers *Registers, memory Bus) error {
+			call(registers)
+			if addr == SIM65_EXIT {
+				return errSim65Exit
+			}
+			return nil
+		})
+	}
+
+	s.Reset()
+	return s, nil
+}
+
+// Closes any files the program left open
+func (s *Sim65) Close() error {
+	var first error
+	for fd, file := range s.files {
+		if err := file.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(s.files, fd)
+	}
+	return first
+}
+
+// ----------------------------------------------------------------------------
+// Execution
+// ----------------------------------------------------------------------------
+
+// Runs the program until it exits, returning its exit code, or fails once
+// limit cycles have passed
+func (s *Sim65) Run(limit uint64) (int, error) {
+	err := s.Machine.Run(limit)
+	switch {
+	case errors.Is(err, errSim65Exit):
+		return s.code, nil
+	case err != nil:
+		return 0, err
+	}
+	return 0, fmt.Errorf("program did not exit within %d cycles", limit)
+}
+
+// ----------------------------------------------------------------------------
+// Calling Convention
+// ----------------------------------------------------------------------------
+
+func sim65_ax(r *Registers) uint16 {
+	return uint16(r.A) | uint16(r.X)<<8
+}
+
+func sim65_set_ax(r *Registers, value uint16) {
+	r.A, r.X = uint8(value), uint8(value>>8)
+}
+
+func sim65_fail(r *Registers) {
+	sim65_set_ax(r, 0xFFFF)
+}
+
+func (s *Sim65) read_word(addr uint16) uint16 {
+	return uint16(s.Memory.Read(addr)) | uint16(s.Memory.Read(addr+1))<<8
+}
+
+func (s *Sim65) write_word(addr uint16, value uint16) {
+	s.Memory.Write(addr, uint8(value))
+	s.Memory.Write(addr+1, uint8(value>>8))
+}
+
+// Reads the word at the top of the C stack and drops size bytes
+func (s *Sim65) pop_param(size uint16) uint16 {
+	sp := s.read_word(s.sp)
+	value := s.read_word(sp)
+	s.write_word(s.sp, sp+size)
+	return value
+}
+
+// The open file or standard stream for a descriptor
+func (s *Sim65) stream(fd uint16) any {
+	switch {
+	case fd > 2:
+		if file, ok := s.files[fd]; ok {
+			return file
+		}
+	case s.closed[fd]:
+	case fd == 0:
+		return s.Stdin
+	case fd == 1:
+		return s.Stdout
+	default:
+		return s.Stderr
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Host Calls
+// ----------------------------------------------------------------------------
+
+// int open(const char* name, int flags, ...), with Y the bytes of arguments
+// pushed, so the mode is there only if Y is 6. Fewer than the name and flags
+// are dropped from the stack and fail.
+func (s *Sim65) open(r *Registers) {
+	if r.Y < 4 {
+		s.write_word(s.sp, s.read_word(s.sp)+uint16(r.Y))
+		sim65_fail(r)
+		return
+	}
+	extra := uint16(r.Y) - 4
+	mode := s.pop_param(extra)
+	flags := s.pop_param(2)
+	name := s.pop_param(2)
+	if extra != 2 {
+		mode = 0600
+	}
+
+	var path []uint8
+	for addr := name; len(path) < sim65PathSize; addr++ {
+		c := s.Memory.Read(addr)
+		if c == 0 {
+			break
+		}
+		path = append(path, c)
+	}
+
+	var flag int
+	switch flags & sim65ReadWrite {
+	case sim65ReadOnly:
+		flag = os.O_RDONLY
+	case sim65WriteOnly:
+		flag = os.O_WRONLY
+	case sim65ReadWrite:
+		flag = os.O_RDWR
+	}
+	options := map[uint16]int{
+		sim65Create:    os.O_CREATE,
+		sim65Truncate:  os.O_TRUNC,
+		sim65Append:    os.O_APPEND,
+		sim65Exclusive: os.O_EXCL,
+	}
+	for bit, option := range options {
+		if flags&bit != 0 {
+			flag |= option
+		}
+	}
+
+	file, err := os.OpenFile(string(path), flag, os.FileMode(mode&0777))
+	if err != nil {
+		sim65_fail(r)
+		return
+	}
+	fd := uint16(3)
+	for s.files[fd] != nil {
+		fd++
+	}
+	s.files[fd] = file
+	sim65_set_ax(r, fd)
+}
+
+// int close(int fd)
+func (s *Sim65) close(r *Registers) {
+	fd := sim65_ax(r)
+	switch {
+	case fd <= 2 && !s.closed[fd]:
+		s.closed[fd] = true
+	case s.files[fd] != nil:
+		err := s.files[fd].Close()
+		delete(s.files, fd)
+		if err != nil {
+			sim65_fail(r)
+			return
+		}
+	default:
+		sim65_fail(r)
+		return
+	}
+	sim65_set_ax(r, 0)
+}
+
+// int read(int fd, void* buf, unsigned count), a single read from the host
+func (s *Sim65) read(r *Registers) {
+	count := sim65_ax(r)
+	addr := s.pop_param(2)
+	fd := s.pop_param(2)
+	reader, ok := s.stream(fd).(io.Reader)
+	if !ok || reader == nil {
+		sim65_fail(r)
+		return
+	}
+	buffer := make([]uint8, count)
+	n, err := reader.Read(buffer)
+	if err != nil && err != io.EOF {
+		sim65_fail(r)
+		return
+	}
+	for i, data := range buffer[:n] {
+		s.Memory.Write(addr+uint16(i), data)
+	}
+	sim65_set_ax(r, uint16(n))
+}
+
+// int write(int fd, const void* buf, unsigned count)
+func (s *Sim65) write(r *Registers) {
+	count := sim65_ax(r)
+	addr := s.pop_param(2)
+	fd := s.pop_param(2)
+	writer, ok := s.stream(fd).(io.Writer)
+	if !ok || writer == nil {
+		sim65_fail(r)
+		return
+	}
+	buffer := make([]uint8, count)
+	for i := range buffer {
+		buffer[i] = s.Memory.Read(addr + uint16(i))
+	}
+	n, err := writer.Write(buffer)
+	if err != nil {
+		sim65_fail(r)
+		return
+	}
+	sim65_set_ax(r, uint16(n))
+}
+
+// int __argc = args(char*** argv): the strings and the argv array are built
+// on the C stack, below the stack pointer, which is moved down past them
+func (s *Sim65) arguments(r *Registers) {
+	argv := sim65_ax(r)
+	table := s.read_word(s.sp) - uint16(len(s.args)+1)*2
+	s.write_word(argv, table)
+
+	sp := table
+	for i, arg := range s.args {
+		sp -= uint16(len(arg) + 1)
+		for j := range len(arg) {
+			s.Memory.Write(sp+uint16(j), arg[j])
+		}
+		s.Memory.Write(sp+uint16(len(arg)), 0)
+		s.write_word(table+uint16(i*2), sp)
+	}
+	s.write_word(table+uint16(len(s.args)*2), 0)
+	s.write_word(s.sp, sp)
+	sim65_set_ax(r, uint16(len(s.args)))
+}
+
+// void exit(int status), with the status in A
+func (s *Sim65) exit(r *Registers) {
+	s.code = int(r.A)
+}
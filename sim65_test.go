@@ -0,0 +1,214 @@
+package cpu6502
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// sim65_test.go
+// Tests sim65 binaries and the paravirtualised host calls
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// Tests
+// ----------------------------------------------------------------------------
+
+func TestSim65HostCalls(t *testing.T) {
+
+	// C stack pointer at $00, program and reset at $0200
+	binary := []uint8{'s', 'i', 'm', '6', '5', 2, 0, 0x00, 0x00, 0x02, 0x00, 0x02}
+	code := []uint8{
+		// write(1, "hi\n", 3) with the two stacked arguments below $C000
+		0xA9, 0xFC, 0x85, 0x00, 0xA9, 0xBF, 0x85, 0x01,
+		0xA9, 0x80, 0x8D, 0xFC, 0xBF, 0xA9, 0x02, 0x8D, 0xFD, 0xBF,
+		0xA9, 0x01, 0x8D, 0xFE, 0xBF, 0xA9, 0x00, 0x8D, 0xFF, 0xBF,
+		0xA9, 0x03, 0xA2, 0x00, 0x20, 0xF7, 0xFF,
+		0x8D, 0x01, 0x03,
+		// argc = args(&argv) with argv at $10
+		0xA9, 0x10, 0xA2, 0x00, 0x20, 0xF8, 0xFF,
+		0x8D, 0x00, 0x03,
+		// exit(42)
+		0xA9, 0x2A, 0x20, 0xF9, 0xFF,
+	}
+	program := make([]uint8, 0x90)
+	copy(program, code)
+	copy(program[0x80:], "hi\n")
+	binary = append(binary, program...)
+
+	s, err := LoadSim65(binary, []string{"test", "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var out strings.Builder
+	s.Stdout = &out
+
+	status, err := s.Run(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 42 {
+		t.Errorf("exit status %d", status)
+	}
+	if out.String() != "hi\n" {
+		t.Errorf("wrote %q", out.String())
+	}
+	if written := s.Memory.Read(0x0301); written != 3 {
+		t.Errorf("write returned %d", written)
+	}
+	if argc := s.Memory.Read(0x0300); argc != 2 {
+		t.Errorf("argc %d", argc)
+	}
+	argv := s.read_word(0x10)
+	arg := s.read_word(argv + 2)
+	if s.Memory.Read(arg) != 'x' || s.Memory.Read(arg+1) != 0 || s.read_word(argv+4) != 0 {
+		t.Errorf("argv[1] not set up")
+	}
+	if sp := s.read_word(0x00); sp >= argv {
+		t.Errorf("C stack at $%04X not moved below argv at $%04X", sp, argv)
+	}
+
+	if _, err := LoadSim65(append([]uint8("sim66"), binary[5:]...), nil); err == nil {
+		t.Errorf("loaded a binary with the wrong magic")
+	}
+
+}
+
+// Opens, reads and closes a file, pushing arguments through a copy of the
+// cc65 runtime's pushax, then tries an open without its flags
+//
+//	0200 START  LDX #$FF
+//	            TXS
+//	            CLD
+//	            LDA #$00     C stack at $C000
+//	            STA SP
+//	            LDA #$C0
+//	            STA SP+1
+//	            LDA #<NAME   open(NAME, O_RDONLY)
+//	            LDX #>NAME
+//	            JSR PUSHAX
+//	            LDA #$01
+//	            LDX #$00
+//	            JSR PUSHAX
+//	            LDY #$04
+//	            JSR OPEN
+//	            STA FD
+//	            STX FD+1
+//	            JSR PUSHAX   read(FD, BUF, 16)
+//	            LDA #<BUF
+//	            LDX #>BUF
+//	            JSR PUSHAX
+//	            LDA #$10
+//	            LDX #$00
+//	            JSR READ
+//	            STA COUNT
+//	            LDA FD       close(FD)
+//	            LDX FD+1
+//	            JSR CLOSE
+//	            STA CLOSED
+//	            LDA #<NAME   open(NAME) short of flags
+//	            LDX #>NAME
+//	            JSR PUSHAX
+//	            LDY #$02
+//	            JSR OPEN
+//	            STA FAILED
+//	            STX FAILED+1
+//	            LDA SP       exit(SP), zero once balanced
+//	            JSR EXIT
+//	025C PUSHAX PHA
+//	            LDA SP
+//	            SEC
+//	            SBC #$02
+//	            STA SP
+//	            BCS +2
+//	            DEC SP+1
+//	            LDY #$01
+//	            TXA
+//	            STA (SP),Y
+//	            PLA
+//	            DEY
+//	            STA (SP),Y
+//	            RTS
+var sim65FileRoundTrip = []uint8{
+	0xA2, 0xFF, 0x9A, 0xD8, 0xA9, 0x00, 0x85, 0x00, 0xA9, 0xC0, 0x85, 0x01,
+	0xA9, 0x00, 0xA2, 0x05, 0x20, 0x5C, 0x02, 0xA9, 0x01, 0xA2, 0x00, 0x20,
+	0x5C, 0x02, 0xA0, 0x04, 0x20, 0xF4, 0xFF, 0x8D, 0x10, 0x03, 0x8E, 0x11,
+	0x03, 0x20, 0x5C, 0x02, 0xA9, 0x00, 0xA2, 0x04, 0x20, 0x5C, 0x02, 0xA9,
+	0x10, 0xA2, 0x00, 0x20, 0xF6, 0xFF, 0x8D, 0x12, 0x03, 0xAD, 0x10, 0x03,
+	0xAE, 0x11, 0x03, 0x20, 0xF5, 0xFF, 0x8D, 0x13, 0x03, 0xA9, 0x00, 0xA2,
+	0x05, 0x20, 0x5C, 0x02, 0xA0, 0x02, 0x20, 0xF4, 0xFF, 0x8D, 0x14, 0x03,
+	0x8E, 0x15, 0x03, 0xA5, 0x00, 0x20, 0xF9, 0xFF, 0x48, 0xA5, 0x00, 0x38,
+	0xE9, 0x02, 0x85, 0x00, 0xB0, 0x02, 0xC6, 0x01, 0xA0, 0x01, 0x8A, 0x91,
+	0x00, 0x68, 0x88, 0x91, 0x00, 0x60,
+}
+
+func TestSim65FileRoundTrip(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(path, []uint8("hello, 6502"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The name goes at $0500, after the buffer at $0400
+	program := make([]uint8, 0x300, 0x300+len(path)+1)
+	copy(program, sim65FileRoundTrip)
+	program = append(append(program, path...), 0)
+	binary := append([]uint8{'s', 'i', 'm', '6', '5', 2, 0, 0x00, 0x00, 0x02, 0x00, 0x02}, program...)
+
+	s, err := LoadSim65(binary, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	status, err := s.Run(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 0 {
+		t.Errorf("C stack left at $C0%02X", status)
+	}
+	if fd := s.read_word(0x0310); fd != 3 {
+		t.Errorf("open returned %d", int16(fd))
+	}
+	if count := s.Memory.Read(0x0312); count != 11 {
+		t.Errorf("read returned %d", count)
+	}
+	buffer := make([]uint8, 11)
+	for i := range buffer {
+		buffer[i] = s.Memory.Read(0x0400 + uint16(i))
+	}
+	if string(buffer) != "hello, 6502" {
+		t.Errorf("read %q", buffer)
+	}
+	if closed := s.Memory.Read(0x0313); closed != 0 || len(s.files) != 0 {
+		t.Errorf("close returned %d with %d files open", closed, len(s.files))
+	}
+	if failed := s.read_word(0x0314); failed != 0xFFFF {
+		t.Errorf("open without flags returned %d", int16(failed))
+	}
+
+}
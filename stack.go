@@ -0,0 +1,53 @@
+package cpu6502
+
+// ----------------------------------------------------------------------------
+// stack.go
+// Hardware stack operation
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// The stack lives in page one and grows downwards
+
+const STACK_PAGE = 0x0100
+
+func (c *CPU) push(data uint8) {
+	c.bus.Write(STACK_PAGE|uint16(c.stack_pointer), data)
+	c.stack_pointer--
+}
+
+func (c *CPU) pull() uint8 {
+	c.stack_pointer++
+	return c.bus.Read(STACK_PAGE | uint16(c.stack_pointer))
+}
+
+// High byte first, so the address reads low/high in memory
+func (c *CPU) push_16(data uint16) {
+	c.push(uint8(data >> 8))
+	c.push(uint8(data))
+}
+
+func (c *CPU) pull_16() uint16 {
+	low := c.pull()
+	high := c.pull()
+	return uint16(high)<<8 | uint16(low)
+}
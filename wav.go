@@ -0,0 +1,76 @@
+package cpu6502
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// ----------------------------------------------------------------------------
+// wav.go
+// Writes PCM samples as a RIFF WAVE file
+// ----------------------------------------------------------------------------
+// Copyright (c) 2024 Robert L. Snyder <rob@mooneyedkitty.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+// ----------------------------------------------------------------------------
+
+// ----------------------------------------------------------------------------
+// WAV Output
+// ----------------------------------------------------------------------------
+// Mono, 16-bit signed little-endian samples. The whole file is written in
+// one go, so output is byte for byte repeatable for golden file tests.
+// ----------------------------------------------------------------------------
+
+func WriteWAV(w io.Writer, rate uint32, samples []int16) error {
+	size := uint32(len(samples) * 2)
+	header := struct {
+		Riff          [4]uint8
+		ChunkSize     uint32
+		Wave          [4]uint8
+		Fmt           [4]uint8
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]uint8
+		DataSize      uint32
+	}{
+		Riff:          [4]uint8{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + size,
+		Wave:          [4]uint8{'W', 'A', 'V', 'E'},
+		Fmt:           [4]uint8{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1, // PCM
+		Channels:      1,
+		SampleRate:    rate,
+		ByteRate:      rate * 2,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+		Data:          [4]uint8{'d', 'a', 't', 'a'},
+		DataSize:      size,
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, samples)
+}